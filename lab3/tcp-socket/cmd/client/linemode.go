@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio" // read input data from keyboard and from network connecting efficiently
+	"fmt"
+	"os"
+	"time"
+)
+
+// how long to wait for BYE after QUIT before hanging up anyway
+const quitTimeout = 2 * time.Second
+
+// runLineMode is the plain prompt loop, used when stdin/stdout is not a terminal
+func runLineMode(cs *clientSession) error {
+	if isTerminal(int(os.Stdout.Fd())) {
+		// redraw the bar in place, a redirected output only gets the final line
+		cs.progress = func(text string, finished bool) {
+			fmt.Print("\r" + escClearLine + text)
+			if finished {
+				fmt.Println()
+			}
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cs.readLoop(func(line string) {
+			fmt.Printf("\n%s\n", line)
+		})
+	}()
+
+	// stdin is read in its own goroutine so a lost connection ends the client right away
+	inputs := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			inputs <- scanner.Text()
+		}
+		close(inputs)
+	}()
+
+	fmt.Println("Please type 'help' for available commands: ")
+	fmt.Print("> ")
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return fmt.Errorf("Lost connection to Server: %w", err)
+			}
+			return nil
+
+		case input, ok := <-inputs:
+			if !ok {
+				// end of input behaves like QUIT
+				input = "QUIT"
+			}
+
+			lines, quit, err := cs.execute(input)
+			for _, line := range lines {
+				fmt.Println(line)
+			}
+			if err != nil {
+				return fmt.Errorf("Failed to send message: %w", err)
+			}
+			if quit {
+				return waitForBye(done)
+			}
+			fmt.Print("> ")
+		}
+	}
+}
+
+// waitForBye lets the read loop print the server's goodbye
+func waitForBye(done <-chan error) error {
+	select {
+	case <-done:
+	case <-time.After(quitTimeout):
+	}
+	return nil
+}
@@ -1,182 +1,108 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"net"
 	"log"
-	"bufio" // read input data from keyboard and from network connecting efficiently
 	"os" // interact with the system like exit program
-	"strings" // handle string (trim white space - check confition)
-	// trim : to remove white space at start and end of the string (can be at a specific-word)
-	// In Golang: from strings - providing Trim() and TrimSpace()
-	// Also having TrimLeft - TrimRight
+	"flag"
+	"time"
 
 	"socket-tcp/internal/protocol"
 )
 
-func main() {
-	conn, err := net.Dial("tcp", "localhost:8080")
-	if err != nil {
-		log.Fatalf("Failed to connect to TCP server: %v", err)
-	}
-	defer conn.Close()
-
-	fmt.Println("Connected to TCP server!")
-
-	msgHandler := protocol.NewMessageHandler(conn)
-	msgHandler.SendMessage(0, protocol.CommandType("GREET"), "Hello from Khanh Hung\n")
-	// conn.Write([]byte("Hello Server from KhanhHung!\n"))
-
-	var sessionID 		int
-	var authenticated 	bool
-
-	// create go routine to read response from the server 
-	go func() { // is a paralel function
-
-		for {
-			msg, err := msgHandler.ReadMessage()
-			if err != nil {
-				fmt.Println("Lost connection to Server: %v", err)
-				os.Exit(1)
-				return 
-			}
+var (
+	serverAddr	= flag.String("addr", "localhost:8080", "Server address")
+	keepalive	= flag.Duration("keepalive", 15*time.Second, "Interval between heartbeat PINGs (0 to disable)")
+	useTUI		= flag.Bool("tui", true, "Use the terminal UI when stdin and stdout are terminals")
+	commandList	= flag.String("c", "", `Run commands separated by ";" and exit, e.g. "AUTH admin 123; START; GUESS 50"`)
+	scriptFile	= flag.String("script", "", "Run commands from a file (one per line, - for stdin) and exit")
+	jsonOutput	= flag.Bool("json", false, "Print every answer of -c/-script as a JSON line")
+	replyTimeout	= flag.Duration("timeout", 10*time.Second, "How long -c/-script waits for each answer")
+	useUDP		= flag.Bool("udp", false, "Talk to cmd/udpserver over UDP instead of TCP")
+	udpLoss		= flag.Float64("loss", 0, "With -udp, probability to drop an outgoing datagram (0-1)")
+	compression	= flag.String("compress", "gzip,deflate", "Codecs to offer the server, in order of preference (empty = no compression)")
+	compressMin	= flag.Int("compress-threshold", protocol.DefaultCompressThreshold, "Lines and uploads smaller than this many bytes are not compressed")
+	startTLS	= flag.String("tls", "auto", "STARTTLS: auto (when the server offers it), require or off")
+	tlsCA		= flag.String("tls-ca", "", "PEM file with the CA of the server certificate (empty = system roots)")
+	tlsInsecure	= flag.Bool("tls-insecure", false, "Do not verify the server certificate (testing only)")
+)
 
-			// Process Message based on type
-			switch msg.Command {
-			case protocol.CommandType("OK"):  
-				if strings.Contains(msg.Payload, "Authentication Succesful") {
-					sessionID = msg.SessionID
-					authenticated = true 
-					fmt.Printf("\nAuthenticated with session ID: %d\n", sessionID)
-				}
-				fmt.Printf("\nServer: %s\n", msg.Payload)
-			case protocol.CommandType("ERROR"), protocol.CommandType("SERVER"), protocol.CommandType("ECHO"):
-				fmt.Printf("\nServer: %s\n", msg.Payload)
-			
-			case protocol.CommandType("BYE"):
-				fmt.Printf("\nServer: %s\n", msg.Payload)
-				os.Exit(0)
-			default:
-				fmt.Printf("\nServer [%s]: %s\n", msg.Command, msg.Payload)
-			}
-			// fmt.Print("> ")
+func main() {
+	flag.Parse()
+
+	// scripted mode: commands come from -c or -script, exit code tells if any failed
+	var cmds []string
+	scripted := *commandList != "" || *scriptFile != ""
+	if *commandList != "" {
+		cmds = splitCommands(*commandList)
+	} else if *scriptFile != "" {
+		var err error
+		if cmds, err = readScript(*scriptFile); err != nil {
+			log.Fatalf("Failed to read script: %v", err)
 		}
-		// scanner := bufio.NewScanner(conn)
-		// for scanner.Scan() { // loop for each line in data from server sent to us
-		// 	fmt.Printf("Server: %s\n", scanner.Text())
-		// }
-
-		// if err := scanner.Err(); err != nil {
-		// 	fmt.Println("Lost connection to server: %v", err)
-		// 	os.Exit(1)
-		// }
-	}()
-
-	// Read input from user and send to server 
-	// scanner := bufio.NewScanner(os.Stdin)
-	// fmt.Println("Type messages to send (type 'quit' to exit): ")
-	// Loop for sending messages to Server
-	// for {
-	// 	fmt.Print("> ")
-	// 	if !scanner.Scan() {
-	// 		break
-	// 	}
-
-	// 	message := scanner.Text()
-	// 	if strings.TrimSpace(message) == "quit" {
-	// 		fmt.Println("Disconnecting...")
-	// 		break
-	// 	}
+	}
 
-	// 	// Sending message to server
-	// 	if _, err := conn.Write([]byte(message + "\n")); err != nil {
-	// 		log.Printf("Failed to send message to server")
-	// 		break 
-	// 	}
-	// }
+	tlsConfig, err := clientTLSConfig(*serverAddr, *startTLS, *tlsCA, *tlsInsecure)
+	if err != nil {
+		log.Fatalf("Invalid TLS settings: %v", err)
+	}
 
-	scanner := bufio.NewScanner(os.Stdin)
-	fmt.Println("Please type 'help' for available commands: ")
-	
-	for {
-		fmt.Print("> ")
-		if !scanner.Scan() { break }
+	msgHandler, conn, err := connect(*serverAddr, *useUDP, *udpLoss)
+	if err != nil {
+		log.Fatalf("Failed to connect to server: %v", err)
+	}
+	defer conn.Close()
+	if *keepalive > 0 {
+		// server answers every PING, so silence for a few intervals means it is gone
+		msgHandler.SetReadTimeout(3 * *keepalive)
+	}
 
-		input := scanner.Text()
-		if input == "" {
-			continue
-		} 
+	hello := protocol.Hello{Version: protocol.ProtocolVersion, Binary: true}
+	if _, ok := msgHandler.(protocol.Compressor); ok {
+		hello.Codecs = protocol.ParseCodecs(*compression)
+	}
+	if err := handshake(msgHandler, hello, tlsConfig, *startTLS == "require", *compressMin); err != nil {
+		log.Fatalf("Handshake with server failed: %v", err)
+	}
+	if *keepalive > 0 {
+		// only now, STARTTLS must not have a PING of ours in the way
+		go sendHeartbeats(msgHandler, *keepalive)
+	}
 
-		// Analyze commands
-		parts := strings.SplitN(input, " ", 2)
-		command := strings.ToUpper(parts[0])
-		payload := ""
+	cs := newClientSession(msgHandler)
+	label := *serverAddr
+	switch {
+	case *useUDP:
+		label += " (udp)"
+	case usesTLS(msgHandler):
+		label += " (tls)"
+	}
 
-		if len(parts) > 1 {
-			payload = parts[1]
+	switch {
+	case scripted:
+		err = runScript(cs, cmds, *jsonOutput, *replyTimeout)
+	case *useTUI && isTerminal(int(os.Stdin.Fd())) && isTerminal(int(os.Stdout.Fd())):
+		// the TUI needs a real terminal on both ends, pipes and redirects get the line mode
+		err = runTUI(cs, label)
+	default:
+		switch {
+		case *useUDP:
+			fmt.Println("Connected to UDP server!")
+		case usesTLS(msgHandler):
+			fmt.Println("Connected to TCP server over TLS!")
+		default:
+			fmt.Println("Connected to TCP server!")
 		}
+		err = runLineMode(cs)
+	}
 
-		switch command {
-		case "HELP":
-			fmt.Println("Available Commands: ")
-			fmt.Println("  Auth username  password  - Authentication with the server")
-			fmt.Println("  QUIT					    - Disconnect from the server")
-			if authenticated {
-				fmt.Println("  START 				- Start a new guessing game")
-				fmt.Println("  GUESS number 		- Make a guess")
-				fmt.Println("  END 					- End the current game")
-				fmt.Println("  FILE filename		- Download a file")
-			}
-
-		case "AUTH":
-			if authenticated {
-				fmt.Println("Already Authenticated")
-				continue 	
-			}
-			err := msgHandler.SendMessage(0, protocol.CmdAuth, payload)
-			if err != nil {
-				log.Printf("Failed to send message: %v", err)
-                return
-			}
-		case "QUIT":
-            var err error
-            if authenticated {
-                err = msgHandler.SendMessage(sessionID, protocol.CmdQuit, "")
-            } else {
-                err = msgHandler.SendMessage(0, protocol.CmdQuit, "")
-            }
-            if err != nil {
-                log.Printf("Failed to send message: %v", err)
-            }
-            return
-
-        default:
-            if !authenticated {
-                fmt.Println("Not authenticated. Use AUTH username password")
-                continue
-			}
-			
-			var cmdType protocol.CommandType 
-			switch command {
-			case "START":
-                cmdType = protocol.CmdStartGame
-            case "GUESS":
-                cmdType = protocol.CmdGuess
-            case "END":
-                cmdType = protocol.CmdEndGame
-            case "FILE":
-                cmdType = protocol.CmdFile
-            default:
-                fmt.Println("Unknown command. Type 'help' for available commands")
-                continue
-			}
-
-			// Send command with Session ID
-			err := msgHandler.SendMessage(sessionID, cmdType, payload)
-			if err != nil {
-				log.Printf("Failed to send message: %v", err)
-				return
-			}
+	printUDPStats(msgHandler)
+	if err != nil {
+		if !errors.Is(err, errScriptFailed) {
+			fmt.Fprintln(os.Stderr, err)
 		}
+		conn.Close()
+		os.Exit(1)
 	}
-}
\ No newline at end of file
+}
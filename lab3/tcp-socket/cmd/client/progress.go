@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// how often a running transfer redraws its progress bar
+const progressInterval = 200 * time.Millisecond
+
+const progressBarWidth = 20
+
+// progressWriter counts the bytes of a transfer and reports a progress bar with the rate
+type progressWriter struct {
+	w      io.Writer
+	name   string
+	done   int64 // bytes of the file we have, including a resumed part
+	total  int64
+	report func(text string, finished bool)
+
+	start     time.Time
+	startDone int64 // bytes we already had, they do not count for the rate
+	last      time.Time
+}
+
+func newProgressWriter(w io.Writer, name string, done, total int64, report func(string, bool)) *progressWriter {
+	now := time.Now()
+	return &progressWriter{w: w, name: name, done: done, total: total, report: report, start: now, startDone: done, last: now}
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.done += int64(n)
+	if pw.report != nil && time.Since(pw.last) >= progressInterval {
+		pw.last = time.Now()
+		pw.report(pw.text(), false)
+	}
+	return n, err
+}
+
+// finish draws the final state, the terminal line can then be left
+func (pw *progressWriter) finish() {
+	if pw.report != nil {
+		pw.report(pw.text(), true)
+	}
+}
+
+// text renders e.g. "big.bin [########------------] 40% 4.0 MB/10.0 MB 2.1 MB/s"
+func (pw *progressWriter) text() string {
+	percent := 100.0
+	if pw.total > 0 {
+		percent = float64(pw.done) * 100 / float64(pw.total)
+	}
+	filled := int(percent) * progressBarWidth / 100
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled)
+
+	rate := 0.0
+	if elapsed := time.Since(pw.start).Seconds(); elapsed > 0 {
+		rate = float64(pw.done-pw.startDone) / elapsed
+	}
+	return fmt.Sprintf("%s [%s] %3.0f%% %s/%s %s/s", pw.name, bar, percent, formatBytes(float64(pw.done)), formatBytes(float64(pw.total)), formatBytes(rate))
+}
+
+// formatBytes prints sizes the way people read them, 1536 -> "1.5 KB"
+func formatBytes(n float64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[i])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[i])
+}
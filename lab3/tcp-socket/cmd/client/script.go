@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"socket-tcp/internal/protocol"
+)
+
+// errScriptFailed means the server answered ERROR (or a command was rejected locally), main exits 1
+var errScriptFailed = errors.New("script failed")
+
+// scriptResult is one line of -json output
+type scriptResult struct {
+	Command   string `json:"command"` // the command as written in the script
+	Status    string `json:"status"`  // OK, ERROR, BYE or FILE
+	SessionID int    `json:"session_id"`
+	Payload   string `json:"payload"`
+}
+
+// splitCommands turns "AUTH admin 123; START" or a script file into single commands
+// Blank lines and lines starting with # are skipped
+func splitCommands(text string) []string {
+	var cmds []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		for _, cmd := range strings.Split(line, ";") {
+			if cmd = strings.TrimSpace(cmd); cmd != "" {
+				cmds = append(cmds, cmd)
+			}
+		}
+	}
+	return cmds
+}
+
+// readScript loads commands from a file, "-" reads them from stdin
+func readScript(path string) ([]string, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return splitCommands(string(data)), nil
+}
+
+// runScript sends the commands one by one and waits for each answer before the next
+// It stops at the first ERROR and returns errScriptFailed, a script without QUIT is closed with one
+func runScript(cs *clientSession, cmds []string, jsonOut bool, timeout time.Duration) error {
+	cs.replies = make(chan *protocol.Message, 16)
+
+	// with -json stdout only carries results, everything else goes to stderr
+	out := io.Writer(os.Stdout)
+	if jsonOut {
+		out = os.Stderr
+	}
+	show := func(line string) { fmt.Fprintln(out, line) }
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cs.readLoop(func(line string) {
+			if !jsonOut {
+				show(line)
+			}
+		})
+	}()
+
+	encoder := json.NewEncoder(os.Stdout)
+	report := func(cmd string, msg *protocol.Message) {
+		if jsonOut {
+			encoder.Encode(scriptResult{Command: cmd, Status: string(msg.Command), SessionID: msg.SessionID, Payload: msg.Payload})
+		}
+	}
+
+	for _, cmd := range cmds {
+		if !jsonOut {
+			show("> " + cmd)
+		}
+
+		lines, quit, err := cs.execute(cmd)
+		if err != nil {
+			return fmt.Errorf("Failed to send message: %w", err)
+		}
+		if lines != nil {
+			if strings.EqualFold(cmd, "HELP") {
+				for _, line := range lines {
+					show(line)
+				}
+				continue
+			}
+			// rejected before anything was sent, e.g. not authenticated yet
+			report(cmd, &protocol.Message{Command: protocol.RespError, Payload: strings.Join(lines, " ")})
+			if !jsonOut {
+				show(strings.Join(lines, "\n"))
+			}
+			return errScriptFailed
+		}
+
+		msg, err := waitForReply(cs.replies, done, timeout)
+		if err != nil {
+			return err
+		}
+		report(cmd, msg)
+		if msg.Command == protocol.RespError {
+			return errScriptFailed
+		}
+		if quit || msg.Command == protocol.RespBye {
+			return nil
+		}
+	}
+
+	// the script did not say QUIT, log out cleanly anyway
+	st := cs.status()
+	if err := cs.msgHandler.SendMessage(st.SessionID, protocol.CmdQuit, ""); err != nil {
+		return nil
+	}
+	return waitForBye(done)
+}
+
+// waitForReply blocks until the answer to the last command, the connection ends or timeout passes
+func waitForReply(replies <-chan *protocol.Message, done <-chan error, timeout time.Duration) (*protocol.Message, error) {
+	select {
+	case msg := <-replies:
+		return msg, nil
+	case err := <-done:
+		// BYE is handed over right before the read loop ends, it may still be waiting
+		select {
+		case msg := <-replies:
+			return msg, nil
+		default:
+		}
+		if err == nil {
+			err = io.EOF
+		}
+		return nil, fmt.Errorf("Lost connection to Server: %w", err)
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("No answer from server after %v", timeout)
+	}
+}
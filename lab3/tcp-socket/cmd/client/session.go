@@ -0,0 +1,514 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"socket-tcp/internal/protocol"
+)
+
+// commands the user can type, used by help and tab completion
+var commands = []string{"HELP", "AUTH", "QUIT", "START", "GUESS", "END", "FILE", "LS", "STAT", "FIND", "UPLOAD", "LIST", "APIKEY", "MFA", "SESSIONS", "REVOKE"}
+
+// uploadChunk is the size of one DATA message, the server accepts up to 4MB
+const uploadChunk = 1 << 20
+
+// clientSession is what every UI mode shares: the connection and what we know about our login
+// The read loop and the input loop run in different goroutines, so the state is behind a mutex
+type clientSession struct {
+	msgHandler protocol.MessageConn
+
+	mu            sync.Mutex
+	sessionID     int
+	username      string
+	pendingUser   string // sent in AUTH, confirmed by the OK
+	authenticated bool
+	gameActive    bool
+	upload        *clientUpload // announced with UPLOAD, streamed after the server's RESUME
+
+	// replies gets every answer to a command (OK, ERROR, BYE, finished FILE), scripted mode waits on it
+	replies chan *protocol.Message
+
+	// progress draws the bar of a running download, nil when nobody watches (scripts, pipes)
+	progress func(text string, finished bool)
+}
+
+// clientUpload is the local file behind a running UPLOAD
+type clientUpload struct {
+	path   string
+	name   string
+	size   int64
+	offset int64 // where the server wants the data to start
+}
+
+// status is a snapshot for the status bar
+type status struct {
+	Username      string
+	SessionID     int
+	Authenticated bool
+	GameActive    bool
+}
+
+func newClientSession(msgHandler protocol.MessageConn) *clientSession {
+	return &clientSession{msgHandler: msgHandler}
+}
+
+func (cs *clientSession) status() status {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return status{
+		Username:      cs.username,
+		SessionID:     cs.sessionID,
+		Authenticated: cs.authenticated,
+		GameActive:    cs.gameActive,
+	}
+}
+
+func helpLines(authenticated bool) []string {
+	lines := []string{
+		"Available Commands: ",
+		"  AUTH username password  - Authentication with the server",
+		"  AUTH TOKEN token        - Log in with the token of an earlier login or an API key",
+		"  AUTH MFA code           - Second login step for users with MFA, app or recovery code",
+		"  QUIT                    - Disconnect from the server",
+	}
+	if authenticated {
+		lines = append(lines,
+			"  START [variant] [opts]  - Start a game: classic, hotcold or mastermind,",
+			"                            opts range=1-500 digits=4 guesses=10 time=2m",
+			"  GUESS number            - Make a guess, a number or a mastermind code",
+			"  END                     - End the current game",
+			"  FILE filename [a-b]     - Download a file or the bytes a to b, a broken download resumes",
+			"  LS [dir] [page=N]       - List a directory of the server's files",
+			"  STAT path               - Size, modification time and checksum of a file",
+			"  FIND pattern [page=N]   - Search the server's files, e.g. FIND *.txt",
+			"  UPLOAD path [name]      - Upload a file, an interrupted upload resumes",
+			"  LIST                    - List your uploaded files and quota",
+			"  APIKEY CREATE name      - Create an API key for scripts, APIKEY LIST / APIKEY REVOKE id",
+			"  MFA [STATUS]            - MFA state, MFA SETUP then MFA ENABLE code to turn it on",
+			"  MFA DISABLE code        - Turn MFA off, MFA CODES code gives new recovery codes",
+			"  SESSIONS [ALL]          - Your logins with address and time, ALL lists everyone's (admin)",
+			"  REVOKE id               - End another of your sessions, its connection is closed",
+		)
+	}
+	return lines
+}
+
+// execute turns one typed line into a message for the server
+// lines are local output (help, usage hints), quit means QUIT was sent and we wait for BYE
+func (cs *clientSession) execute(input string) (lines []string, quit bool, err error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return nil, false, nil
+	}
+
+	// Analyze commands
+	parts := strings.SplitN(input, " ", 2)
+	command := strings.ToUpper(parts[0])
+	payload := ""
+	if len(parts) > 1 {
+		payload = parts[1]
+	}
+
+	st := cs.status()
+
+	switch command {
+	case "HELP":
+		return helpLines(st.Authenticated), false, nil
+
+	case "AUTH":
+		if st.Authenticated {
+			return []string{"Already Authenticated"}, false, nil
+		}
+		username, _, _ := strings.Cut(payload, " ")
+		if username == "TOKEN" {
+			username = "" // the answer tells who the token belongs to
+		}
+		cs.mu.Lock()
+		if username != "MFA" { // the code is for the user of the password before
+			cs.pendingUser = username
+		}
+		cs.mu.Unlock()
+		return nil, false, cs.msgHandler.SendMessage(0, protocol.CmdAuth, payload)
+
+	case "QUIT":
+		return nil, true, cs.msgHandler.SendMessage(st.SessionID, protocol.CmdQuit, "")
+	}
+
+	if !st.Authenticated {
+		return []string{"Not authenticated. Use AUTH username password"}, false, nil
+	}
+
+	var cmdType protocol.CommandType
+	switch command {
+	case "START":
+		cmdType = protocol.CmdStartGame
+	case "GUESS":
+		cmdType = protocol.CmdGuess
+	case "END":
+		cmdType = protocol.CmdEndGame
+	case "FILE":
+		cmdType = protocol.CmdFile
+		payload = fileRequest(payload)
+	case "LS":
+		cmdType = protocol.CmdLs
+	case "STAT":
+		cmdType = protocol.CmdStat
+	case "FIND":
+		cmdType = protocol.CmdFind
+	case "UPLOAD":
+		return cs.startUpload(st.SessionID, payload)
+	case "LIST":
+		cmdType = protocol.CmdList
+	case "APIKEY":
+		cmdType = protocol.CmdAPIKey
+	case "MFA":
+		cmdType = protocol.CmdMFA
+	case "SESSIONS":
+		cmdType = protocol.CmdSessions
+	case "REVOKE":
+		cmdType = protocol.CmdRevoke
+	default:
+		return []string{"Unknown command. Type 'help' for available commands"}, false, nil
+	}
+
+	// Send command with Session ID
+	return nil, false, cs.msgHandler.SendMessage(st.SessionID, cmdType, payload)
+}
+
+// readLoop handles server messages until BYE (returns nil) or the connection breaks
+// show receives every line meant for the user
+func (cs *clientSession) readLoop(show func(string)) error {
+	for {
+		msg, err := cs.msgHandler.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		// Process Message based on type
+		switch msg.Command {
+		case protocol.CmdPing:
+			// server checking we are still here
+			cs.msgHandler.SendMessage(msg.SessionID, protocol.CmdPong, msg.Payload)
+
+		case protocol.CmdPong:
+			// answer to our keepalive, nothing to show
+
+		case protocol.RespOK:
+			if up := cs.resumeUpload(msg); up != nil {
+				// not the answer yet, that comes once the data is in
+				go cs.sendUpload(msg.SessionID, up, show)
+				continue
+			}
+			cs.trackState(msg)
+			show("Server: " + msg.Payload)
+			cs.reply(msg)
+
+		case protocol.RespError:
+			cs.stopUpload()
+			show("Server: " + msg.Payload)
+			cs.reply(msg)
+
+		case protocol.RespServer:
+			show("Server: " + msg.Payload)
+
+		case protocol.RespBye:
+			show("Server: " + msg.Payload)
+			cs.reply(msg)
+			return nil
+
+		case protocol.CmdFile:
+			// raw file bytes follow the header, they must be read before the next message
+			if err := cs.saveDownload(msg.Payload, show); err != nil {
+				show(fmt.Sprintf("Download failed: %v", err))
+				msg = &protocol.Message{SessionID: msg.SessionID, Command: protocol.RespError, Payload: "Download failed: " + err.Error()}
+			}
+			cs.reply(msg)
+
+		default:
+			show(fmt.Sprintf("Server [%s]: %s", msg.Command, msg.Payload))
+		}
+	}
+}
+
+// reply hands an answer to whoever waits for it, the interactive modes don't
+func (cs *clientSession) reply(msg *protocol.Message) {
+	if cs.replies != nil {
+		cs.replies <- msg
+	}
+}
+
+// trackState follows login and game state from the OK answers
+func (cs *clientSession) trackState(msg *protocol.Message) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	switch {
+	case strings.Contains(msg.Payload, "Authentication Successful"):
+		cs.sessionID = msg.SessionID
+		cs.username = cs.pendingUser
+		if _, user, ok := strings.Cut(msg.Payload, "logged in as "); ok {
+			cs.username, _, _ = strings.Cut(user, ",") // usernames have no commas
+		}
+		cs.authenticated = true
+		cs.gameActive = strings.Contains(msg.Payload, "game resumed")
+	case strings.Contains(msg.Payload, "Game started"):
+		cs.gameActive = true
+	case strings.Contains(msg.Payload, "Correct"), strings.Contains(msg.Payload, "Game ended"),
+		strings.Contains(msg.Payload, "game over"):
+		cs.gameActive = false
+	case strings.HasPrefix(msg.Payload, "Uploaded "):
+		cs.upload = nil
+	}
+}
+
+// startUpload announces a local file, the data is sent once the server says where to resume
+func (cs *clientSession) startUpload(sessionID int, payload string) ([]string, bool, error) {
+	path, name, _ := strings.Cut(strings.TrimSpace(payload), " ")
+	if path == "" {
+		return []string{"Usage: UPLOAD path [name]"}, false, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return []string{"Cannot upload " + path + ": not a readable file"}, false, nil
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		name = filepath.Base(path)
+	}
+
+	cs.mu.Lock()
+	cs.upload = &clientUpload{path: path, name: name, size: info.Size()}
+	cs.mu.Unlock()
+	return nil, false, cs.msgHandler.SendMessage(sessionID, protocol.CmdUpload, fmt.Sprintf("%d %s", info.Size(), name))
+}
+
+// resumeUpload returns the pending upload with its offset filled in if msg is "OK RESUME <offset> <name>"
+func (cs *clientSession) resumeUpload(msg *protocol.Message) *clientUpload {
+	var offset int64
+	var name string
+	if _, err := fmt.Sscanf(msg.Payload, "RESUME %d %s", &offset, &name); err != nil {
+		return nil
+	}
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.upload == nil || offset < 0 || offset > cs.upload.size {
+		return nil
+	}
+	up := *cs.upload
+	up.offset = offset
+	return &up
+}
+
+// stopUpload ends the chunk loop, after an ERROR the server drops the rest anyway
+func (cs *clientSession) stopUpload() {
+	cs.mu.Lock()
+	cs.upload = nil
+	cs.mu.Unlock()
+}
+
+// sendUpload streams the file from up.offset on as DATA chunks
+func (cs *clientSession) sendUpload(sessionID int, up *clientUpload, show func(string)) {
+	file, err := os.Open(up.path)
+	if err != nil {
+		show(fmt.Sprintf("Upload failed: %v", err))
+		cs.stopUpload()
+		return
+	}
+	defer file.Close()
+
+	offset := up.offset
+	if offset > 0 {
+		show(fmt.Sprintf("Resuming upload of %s at %d of %d bytes", up.name, offset, up.size))
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		show(fmt.Sprintf("Upload failed: %v", err))
+		cs.stopUpload()
+		return
+	}
+
+	for offset < up.size {
+		cs.mu.Lock()
+		running := cs.upload != nil
+		cs.mu.Unlock()
+		if !running {
+			return // the server answered ERROR
+		}
+
+		n := min(int64(uploadChunk), up.size-offset)
+		if err := cs.msgHandler.SendData(sessionID, protocol.CmdData, fmt.Sprintf("%d %d", offset, n), file, n); err != nil {
+			// half a chunk went out, the connection is out of sync
+			show(fmt.Sprintf("Upload failed: %v", err))
+			cs.reply(&protocol.Message{SessionID: sessionID, Command: protocol.RespError, Payload: "Upload failed: " + err.Error()})
+			return
+		}
+		offset += n
+	}
+}
+
+// fileRequest turns "name [a-b]" into the FILE payload, asking only for the rest of the file
+// when downloads/ holds part of it
+func fileRequest(payload string) string {
+	payload = strings.TrimSpace(payload)
+	if i := strings.LastIndex(payload, " "); i >= 0 && isRange(payload[i+1:]) {
+		return payload[:i] + " bytes=" + payload[i+1:]
+	}
+	if info, err := os.Stat(partPath(payload)); err == nil && info.Size() > 0 {
+		return fmt.Sprintf("%s bytes=%d-", payload, info.Size())
+	}
+	return payload
+}
+
+// isRange accepts "a-b", "a-" and "-k"
+func isRange(s string) bool {
+	first, last, found := strings.Cut(s, "-")
+	if !found || first == "" && last == "" {
+		return false
+	}
+	for _, part := range []string{first, last} {
+		if _, err := strconv.ParseUint(part, 10, 64); part != "" && err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// partPath is where a download is written until it is complete and verified
+func partPath(name string) string {
+	return filepath.Join("downloads", filepath.Base(name)+".part")
+}
+
+// fileHeader is "FILE <n> <offset> <total> <sha256> <name>", n bytes follow it
+type fileHeader struct {
+	n, offset, total int64
+	sum, name        string
+}
+
+func parseFileHeader(payload string) (fileHeader, error) {
+	var h fileHeader
+	fields := strings.SplitN(payload, " ", 5)
+	if len(fields) != 5 {
+		return h, errors.New("invalid FILE header: " + payload)
+	}
+	var err error
+	for i, v := range []*int64{&h.n, &h.offset, &h.total} {
+		if *v, err = strconv.ParseInt(fields[i], 10, 64); err != nil || *v < 0 {
+			return h, errors.New("invalid FILE header: " + payload)
+		}
+	}
+	h.sum, h.name = fields[3], filepath.Base(fields[4])
+	return h, nil
+}
+
+// saveDownload stores the bytes that follow a FILE header in downloads/
+// The tail of a file goes to <name>.part and is renamed once the checksum matches,
+// so a broken download can be resumed; any other range is saved as <name>.<first>-<last>
+func (cs *clientSession) saveDownload(payload string, show func(string)) error {
+	h, err := parseFileHeader(payload)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll("downloads", 0755); err != nil {
+		cs.msgHandler.ReadData(io.Discard, h.n) // still drain the bytes so the connection stays usable
+		return err
+	}
+
+	part := partPath(h.name)
+	partInfo, err := os.Stat(part)
+	tail := h.offset+h.n == h.total && (h.offset == 0 || err == nil && partInfo.Size() >= h.offset)
+	if !tail {
+		return cs.saveRange(h, show)
+	}
+
+	file, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE, 0644)
+	if err == nil {
+		err = file.Truncate(h.offset)
+	}
+	if err == nil {
+		_, err = file.Seek(h.offset, io.SeekStart)
+	}
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		cs.msgHandler.ReadData(io.Discard, h.n)
+		return err
+	}
+
+	if h.offset > 0 {
+		show(fmt.Sprintf("Resuming %s at %d of %d bytes", h.name, h.offset, h.total))
+	}
+	progress := newProgressWriter(file, h.name, h.offset, h.total, cs.progress)
+	err = cs.msgHandler.ReadData(progress, h.n)
+	file.Close()
+	if err != nil {
+		return err // what arrived stays in the .part file for the next attempt
+	}
+	progress.finish()
+
+	if sum, err := fileChecksum(part); err != nil || sum != h.sum {
+		os.Remove(part)
+		return fmt.Errorf("checksum mismatch for %s, the file changed on the server; partial download removed, try again", h.name)
+	}
+	if err := os.Rename(part, filepath.Join("downloads", h.name)); err != nil {
+		return err
+	}
+	show(fmt.Sprintf("Downloaded %s (%d bytes, checksum OK) to downloads/", h.name, h.total))
+	return nil
+}
+
+// saveRange stores part of a file next to the downloads, it cannot be checked against the checksum
+func (cs *clientSession) saveRange(h fileHeader, show func(string)) error {
+	last := h.offset + h.n - 1
+	path := filepath.Join("downloads", fmt.Sprintf("%s.%d-%d", h.name, h.offset, last))
+	file, err := os.Create(path)
+	if err != nil {
+		cs.msgHandler.ReadData(io.Discard, h.n)
+		return err
+	}
+	defer file.Close()
+
+	progress := newProgressWriter(file, h.name, 0, h.n, cs.progress)
+	if err := cs.msgHandler.ReadData(progress, h.n); err != nil {
+		return err
+	}
+	progress.finish()
+	show(fmt.Sprintf("Downloaded bytes %d-%d of %s (%d bytes) to %s", h.offset, last, h.name, h.total, path))
+	return nil
+}
+
+// fileChecksum is the hex SHA-256 the server sends in the FILE header
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// sendHeartbeats keeps the connection alive while the user is idle at the prompt
+func sendHeartbeats(msgHandler protocol.MessageConn, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := msgHandler.SendMessage(0, protocol.CmdPing, fmt.Sprint(time.Now().UnixNano())); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// No raw terminal support here, the client always uses line mode
+
+func isTerminal(fd int) bool { return false }
+
+func makeRaw(fd int) (func(), error) { return nil, errors.New("raw terminal not supported") }
+
+func terminalSize(fd int) (int, int, error) { return 0, 0, errors.New("not supported") }
+
+func notifyResize(ch chan<- os.Signal) {}
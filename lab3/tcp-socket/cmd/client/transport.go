@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"socket-tcp/internal/protocol"
+	"socket-tcp/internal/rudp"
+)
+
+// connect dials the server over TCP, or over UDP with the rudp reliability layer
+func connect(addr string, udp bool, loss float64) (protocol.MessageConn, io.Closer, error) {
+	if !udp {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return nil, nil, err
+		}
+		return protocol.NewMessageHandler(conn), conn, nil
+	}
+
+	cfg := rudp.DefaultConfig()
+	cfg.Loss = loss
+	conn, err := rudp.Dial(addr, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rudp.NewMessageConn(conn), conn, nil
+}
+
+// clientTLSConfig builds what STARTTLS verifies the server with, nil for -tls off
+func clientTLSConfig(addr, mode, caFile string, insecure bool) (*tls.Config, error) {
+	switch mode {
+	case "off":
+		return nil, nil
+	case "auto", "require":
+	default:
+		return nil, fmt.Errorf("-tls must be auto, require or off, not %q", mode)
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{ServerName: host, InsecureSkipVerify: insecure, MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+	}
+	return cfg, nil
+}
+
+// handshake runs before any command: the server's HELLO, STARTTLS if it offers it and tlsConfig is set,
+// then our HELLO, and switches on the compression the server agreed to
+func handshake(msgHandler protocol.MessageConn, offer protocol.Hello, tlsConfig *tls.Config, requireTLS bool, threshold int) error {
+	serverHello, err := readHello(msgHandler)
+	if err != nil {
+		return err
+	}
+
+	upgrader, canUpgrade := msgHandler.(protocol.TLSUpgrader)
+	switch {
+	case tlsConfig != nil && canUpgrade && serverHello.StartTLS:
+		if err := msgHandler.SendMessage(0, protocol.CmdStartTLS, ""); err != nil {
+			return err
+		}
+		if _, err := expectOK(msgHandler, protocol.CmdStartTLS); err != nil {
+			return err
+		}
+		if err := upgrader.StartTLS(tlsConfig, false); err != nil {
+			return fmt.Errorf("TLS handshake failed: %w", err)
+		}
+		// a new start inside TLS, the server greets again
+		if _, err := readHello(msgHandler); err != nil {
+			return err
+		}
+	case requireTLS:
+		return errors.New("the server does not offer STARTTLS")
+	}
+
+	if err := msgHandler.SendMessage(0, protocol.CmdHello, offer.String()); err != nil {
+		return err
+	}
+	payload, err := expectOK(msgHandler, protocol.CmdHello)
+	if err != nil {
+		return err
+	}
+	agreed, err := protocol.ParseHello(payload)
+	if err != nil {
+		return err
+	}
+	if compressor, ok := msgHandler.(protocol.Compressor); ok {
+		compressor.SetCompression(agreed.Codec(), threshold)
+	}
+	return nil
+}
+
+// readHello waits for the server's HELLO and checks we speak its version
+func readHello(msgHandler protocol.MessageConn) (protocol.Hello, error) {
+	msg, err := nextMessage(msgHandler)
+	if err != nil {
+		return protocol.Hello{}, err
+	}
+	if msg.Command == protocol.RespError {
+		return protocol.Hello{}, errors.New(msg.Payload) // e.g. too many connections
+	}
+	if msg.Command != protocol.CmdHello {
+		return protocol.Hello{}, fmt.Errorf("expected HELLO from the server, got %s %s", msg.Command, msg.Payload)
+	}
+	hello, err := protocol.ParseHello(msg.Payload)
+	if err != nil {
+		return protocol.Hello{}, err
+	}
+	return hello, protocol.CheckVersion(hello.Version)
+}
+
+// expectOK waits for "OK <command> ..." and returns what follows the command
+func expectOK(msgHandler protocol.MessageConn, command protocol.CommandType) (string, error) {
+	msg, err := nextMessage(msgHandler)
+	if err != nil {
+		return "", err
+	}
+	payload, ok := strings.CutPrefix(msg.Payload, string(command)+" ")
+	if msg.Command != protocol.RespOK || !ok {
+		return "", fmt.Errorf("%s refused: %s", command, msg.Payload)
+	}
+	return payload, nil
+}
+
+// nextMessage skips heartbeats, answering the server's PINGs
+func nextMessage(msgHandler protocol.MessageConn) (*protocol.Message, error) {
+	for {
+		msg, err := msgHandler.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		switch msg.Command {
+		case protocol.CmdPing:
+			msgHandler.SendMessage(msg.SessionID, protocol.CmdPong, msg.Payload)
+		case protocol.CmdPong:
+		default:
+			return msg, nil
+		}
+	}
+}
+
+func usesTLS(msgHandler protocol.MessageConn) bool {
+	upgrader, ok := msgHandler.(protocol.TLSUpgrader)
+	return ok && upgrader.TLS()
+}
+
+// printUDPStats shows what the reliability layer had to do, to compare runs with different -loss
+func printUDPStats(msgHandler protocol.MessageConn) {
+	mc, ok := msgHandler.(*rudp.MessageConn)
+	if !ok {
+		return
+	}
+	st := mc.Conn().Stats()
+	fmt.Fprintf(os.Stderr, "UDP: %d datagrams sent, %d retransmitted, %d received, %d duplicates, %d dropped by -loss\n",
+		st.Sent, st.Retransmits, st.Received, st.Duplicates, st.Dropped)
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ANSI sequences used by the TUI
+const (
+	escAltScreenOn  = "\x1b[?1049h"
+	escAltScreenOff = "\x1b[?1049l"
+	escClearLine    = "\x1b[2K"
+	escReverse      = "\x1b[7m"
+	escReset        = "\x1b[0m"
+)
+
+// maxScrollback caps the message pane history
+const maxScrollback = 1000
+
+// tui draws a scrolling message pane, a status bar and an input line
+// Everything runs on one goroutine (run), the others only feed it through channels
+type tui struct {
+	cs   *clientSession
+	addr string
+	out  *bufio.Writer
+
+	width, height int
+
+	lines    []string // message pane, oldest first
+	scroll   int      // rows scrolled up from the bottom
+	progress string   // running download, shown in the status bar
+
+	input   []rune
+	cursor  int
+	history []string
+	histPos int // == len(history) when editing a new line
+}
+
+// runTUI takes over the terminal until QUIT, Ctrl+C or the connection drops
+func runTUI(cs *clientSession, addr string) error {
+	restore, err := makeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return err
+	}
+
+	t := &tui{cs: cs, addr: addr, out: bufio.NewWriter(os.Stdout)}
+	t.resize()
+	t.out.WriteString(escAltScreenOn)
+	defer func() {
+		t.out.WriteString(escAltScreenOff)
+		t.out.Flush()
+		restore()
+	}()
+
+	return t.run()
+}
+
+func (t *tui) run() error {
+	serverLines := make(chan string, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- t.cs.readLoop(func(line string) { serverLines <- line })
+	}()
+
+	// only the newest progress matters, the read loop is the only sender
+	progress := make(chan string, 1)
+	t.cs.progress = func(text string, finished bool) {
+		if finished {
+			text = ""
+		}
+		select {
+		case <-progress:
+		default:
+		}
+		progress <- text
+	}
+
+	keys := make(chan []byte)
+	go readKeys(keys)
+
+	resized := make(chan os.Signal, 1)
+	notifyResize(resized)
+
+	t.addLine("Connected to " + t.addr + ". Type 'help' for available commands, Tab completes, PgUp/PgDn scroll.")
+	quitting := false
+	var quitDeadline <-chan time.Time
+
+	for {
+		t.render()
+
+		select {
+		case line := <-serverLines:
+			t.addLine(line)
+
+		case text := <-progress:
+			t.progress = text
+
+		case err := <-done:
+			// drain what the read loop showed before it ended
+			for len(serverLines) > 0 {
+				t.addLine(<-serverLines)
+			}
+			if err != nil && !quitting {
+				return fmt.Errorf("Lost connection to Server: %w", err)
+			}
+			return nil
+
+		case <-quitDeadline:
+			return nil
+
+		case <-resized:
+			t.resize()
+
+		case data, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			if t.handleKeys(data) && !quitting {
+				quitting = true
+				quitDeadline = time.After(quitTimeout)
+			}
+		}
+	}
+}
+
+// readKeys forwards raw stdin chunks, escape sequences normally arrive in one read
+func readKeys(keys chan<- []byte) {
+	buf := make([]byte, 256)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			close(keys)
+			return
+		}
+		keys <- append([]byte(nil), buf[:n]...)
+	}
+}
+
+// handleKeys edits the input line, returns true once QUIT was sent
+func (t *tui) handleKeys(data []byte) bool {
+	for len(data) > 0 {
+		switch {
+		case data[0] == 0x1b: // escape sequence
+			data = t.handleEscape(data)
+			continue
+		case data[0] == 0x03: // Ctrl+C
+			return t.submit("QUIT")
+		case data[0] == 0x04: // Ctrl+D on an empty line
+			if len(t.input) == 0 {
+				return t.submit("QUIT")
+			}
+		case data[0] == '\r' || data[0] == '\n':
+			line := string(t.input)
+			t.input, t.cursor = nil, 0
+			if strings.TrimSpace(line) != "" {
+				t.history = append(t.history, line)
+			}
+			t.histPos = len(t.history)
+			if t.submit(line) {
+				return true
+			}
+		case data[0] == 0x7f || data[0] == 0x08: // Backspace
+			if t.cursor > 0 {
+				t.input = append(t.input[:t.cursor-1], t.input[t.cursor:]...)
+				t.cursor--
+			}
+		case data[0] == '\t':
+			t.complete()
+		case data[0] == 0x01: // Ctrl+A
+			t.cursor = 0
+		case data[0] == 0x05: // Ctrl+E
+			t.cursor = len(t.input)
+		case data[0] == 0x15: // Ctrl+U
+			t.input, t.cursor = t.input[t.cursor:], 0
+		case data[0] == 0x0c: // Ctrl+L
+			t.out.WriteString("\x1b[2J")
+		case data[0] >= 0x20:
+			r, size := utf8.DecodeRune(data)
+			t.insert(r)
+			data = data[size:]
+			continue
+		}
+		data = data[1:]
+	}
+	return false
+}
+
+// handleEscape understands arrows, Home/End, Delete and PgUp/PgDn; returns the unread rest
+func (t *tui) handleEscape(data []byte) []byte {
+	if len(data) < 3 || (data[1] != '[' && data[1] != 'O') {
+		return data[1:] // lone ESC
+	}
+
+	switch data[2] {
+	case 'A':
+		t.historyMove(-1)
+	case 'B':
+		t.historyMove(1)
+	case 'C':
+		if t.cursor < len(t.input) {
+			t.cursor++
+		}
+	case 'D':
+		if t.cursor > 0 {
+			t.cursor--
+		}
+	case 'H':
+		t.cursor = 0
+	case 'F':
+		t.cursor = len(t.input)
+	case '3', '5', '6':
+		if len(data) < 4 || data[3] != '~' {
+			return data[3:]
+		}
+		switch data[2] {
+		case '3': // Delete
+			if t.cursor < len(t.input) {
+				t.input = append(t.input[:t.cursor], t.input[t.cursor+1:]...)
+			}
+		case '5':
+			t.scrollBy(t.paneHeight() - 1)
+		case '6':
+			t.scrollBy(-(t.paneHeight() - 1))
+		}
+		return data[4:]
+	}
+	return data[3:]
+}
+
+func (t *tui) insert(r rune) {
+	t.input = append(t.input[:t.cursor], append([]rune{r}, t.input[t.cursor:]...)...)
+	t.cursor++
+}
+
+func (t *tui) historyMove(delta int) {
+	pos := t.histPos + delta
+	if pos < 0 || pos > len(t.history) {
+		return
+	}
+	t.histPos = pos
+	if pos == len(t.history) {
+		t.input = nil
+	} else {
+		t.input = []rune(t.history[pos])
+	}
+	t.cursor = len(t.input)
+}
+
+// complete finishes the command word, or lists the candidates when there are several
+func (t *tui) complete() {
+	word := string(t.input[:t.cursor])
+	if strings.Contains(word, " ") {
+		return // only commands are completed
+	}
+
+	var matches []string
+	for _, cmd := range commands {
+		if strings.HasPrefix(cmd, strings.ToUpper(word)) {
+			matches = append(matches, cmd)
+		}
+	}
+	sort.Strings(matches)
+
+	switch len(matches) {
+	case 0:
+	case 1:
+		rest := t.input[t.cursor:]
+		t.input = append([]rune(matches[0]+" "), rest...)
+		t.cursor = len(matches[0]) + 1
+	default:
+		t.addLine("Completions: " + strings.Join(matches, " "))
+	}
+}
+
+// submit runs a typed line through the session, returns true if it was QUIT
+func (t *tui) submit(line string) bool {
+	if strings.TrimSpace(line) != "" {
+		t.addLine("> " + line)
+	}
+	lines, quit, err := t.cs.execute(line)
+	for _, l := range lines {
+		t.addLine(l)
+	}
+	if err != nil {
+		t.addLine(fmt.Sprintf("Failed to send message: %v", err))
+	}
+	return quit
+}
+
+func (t *tui) addLine(line string) {
+	t.lines = append(t.lines, strings.Split(line, "\n")...)
+	if len(t.lines) > maxScrollback {
+		t.lines = t.lines[len(t.lines)-maxScrollback:]
+	}
+	if t.scroll > 0 {
+		t.scroll++ // keep the view still while the user reads older lines
+	}
+}
+
+func (t *tui) scrollBy(rows int) {
+	t.scroll += rows
+	maxScroll := len(t.wrapped()) - t.paneHeight()
+	if t.scroll > maxScroll {
+		t.scroll = maxScroll
+	}
+	if t.scroll < 0 {
+		t.scroll = 0
+	}
+}
+
+func (t *tui) resize() {
+	w, h, err := terminalSize(int(os.Stdout.Fd()))
+	if err != nil || w <= 0 || h < 3 {
+		w, h = 80, 24
+	}
+	t.width, t.height = w, h
+}
+
+// the message pane is everything above the status bar and input line
+func (t *tui) paneHeight() int {
+	return t.height - 2
+}
+
+// wrapped splits long lines at the terminal width
+func (t *tui) wrapped() []string {
+	var rows []string
+	for _, line := range t.lines {
+		runes := []rune(line)
+		for len(runes) > t.width {
+			rows = append(rows, string(runes[:t.width]))
+			runes = runes[t.width:]
+		}
+		rows = append(rows, string(runes))
+	}
+	return rows
+}
+
+func (t *tui) statusText() string {
+	st := t.cs.status()
+	user, session, game := "-", "-", "none"
+	if st.Authenticated {
+		user = st.Username
+		session = fmt.Sprint(st.SessionID)
+	}
+	if st.GameActive {
+		game = "active"
+	}
+	text := fmt.Sprintf(" %s | user: %s | session: %s | game: %s", t.addr, user, session, game)
+	if t.progress != "" {
+		text += " | " + t.progress
+	}
+	if t.scroll > 0 {
+		text += fmt.Sprintf(" | scrolled %d", t.scroll)
+	}
+	return text
+}
+
+// render redraws the whole screen, it is small enough to not bother with diffs
+func (t *tui) render() {
+	rows := t.wrapped()
+	pane := t.paneHeight()
+	end := len(rows) - t.scroll
+	start := end - pane
+	if start < 0 {
+		start = 0
+	}
+
+	t.out.WriteString("\x1b[H")
+	for i := 0; i < pane; i++ {
+		t.out.WriteString(escClearLine)
+		if start+i < end {
+			t.out.WriteString(rows[start+i])
+		}
+		t.out.WriteString("\r\n")
+	}
+
+	// status bar in reverse video
+	t.out.WriteString(escClearLine + escReverse + padRight(t.statusText(), t.width) + escReset + "\r\n")
+
+	// input line, scrolled horizontally so the cursor stays visible
+	prompt := "> "
+	visible := t.width - len(prompt) - 1
+	offset := 0
+	if t.cursor > visible {
+		offset = t.cursor - visible
+	}
+	shown := t.input[offset:]
+	if len(shown) > visible {
+		shown = shown[:visible]
+	}
+	t.out.WriteString(escClearLine + prompt + string(shown))
+	fmt.Fprintf(t.out, "\x1b[%d;%dH", t.height, len(prompt)+t.cursor-offset+1)
+	t.out.Flush()
+}
+
+func padRight(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) >= width {
+		return string(runes[:width])
+	}
+	return s + strings.Repeat(" ", width-len(runes))
+}
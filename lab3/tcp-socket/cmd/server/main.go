@@ -1,39 +1,90 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt" // lib for function to print out to the screen - Println - Printf
-	"log" // for logging
-	"net"  // tcp lib - dependency : For functions working with network like TCP, UDP, HTTP, ...
-	"strings"
+	"log/slog" // leveled structured logging
+	"net"
+	"net/http"
 	"flag"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
-	"socket-tcp/internal/protocol"
+	"socket-tcp/internal/audit"
 	"socket-tcp/internal/auth"
+	"socket-tcp/internal/protocol"
+	"socket-tcp/internal/server"
 	"socket-tcp/internal/storage"
+	"socket-tcp/pkg/util"
 )
 
+var defaults = server.DefaultConfig()
 
 var (
 	port 		= flag.String("port", "8080", "Server port")
 	userFile	= flag.String("users", "data/users.json", "User data file")
 	storageType	= flag.String("storage", "json", "Storage type (json or gob)")
+	gameFile	= flag.String("games", "data/games.json", "File that keeps running games over a restart (empty = games end with the server)")
+	gameSave	= flag.Duration("game-save-interval", defaults.GameSaveInterval, "How often running games are saved besides at shutdown (0 = only at shutdown)")
+	fileRoot	= flag.String("files", defaults.FileRoot, "Directory served by the FILE command")
+	exclude		= flag.String("exclude", strings.Join(defaults.ExcludePatterns, ","), "Comma-separated patterns hidden from LS/FIND/STAT/FILE (dot files always are)")
+	pageSize	= flag.Int("page-size", defaults.ListPageSize, "Entries per LS/FIND page (0 = no paging)")
+	maxUpload	= flag.Int64("max-upload", defaults.MaxUploadSize, "Max bytes per uploaded file (0 = unlimited)")
+	uploadQuota	= flag.Int64("upload-quota", defaults.UploadQuota, "Bytes each user may store in uploads unless set per user (0 = unlimited)")
+	compression	= flag.String("compress", "gzip,deflate", "Codecs offered in HELLO, in order of preference (empty = never compress)")
+	compressMin	= flag.Int("compress-threshold", defaults.CompressThreshold, "Lines and transfers smaller than this many bytes are not compressed")
+	tlsCert		= flag.String("tls-cert", "", "PEM certificate for STARTTLS (empty = STARTTLS not offered)")
+	tlsKey		= flag.String("tls-key", "", "PEM private key of -tls-cert")
+	requireTLS	= flag.Bool("require-tls", false, "Refuse AUTH until the connection was upgraded with STARTTLS, the REST API is served over HTTPS")
+	tokenKey	= flag.String("token-key", "", "File with the access token signing key, shared with cmd/udpserver (empty = random, tokens end with the process)")
+	tokenTTL	= flag.Duration("token-ttl", auth.DefaultTokenTTL, "How long an access token issued at login is valid")
+	sessionPolicy	= flag.String("session-policy", string(auth.SessionPolicyMany), "What a login does to the user's other sessions: many (keep them), kick (end them) or reject (refuse the login)")
+	readTimeout	= flag.Duration("read-timeout", defaults.ReadTimeout, "Idle time before the server sends a heartbeat PING")
+	writeTimeout	= flag.Duration("write-timeout", defaults.WriteTimeout, "Max time to block writing to a client")
+	maxMissed	= flag.Int("max-missed", defaults.MaxMissed, "Heartbeats a client may miss before it is disconnected")
+	maxConns	= flag.Int("max-conns", defaults.MaxConns, "Max concurrent connections (0 = unlimited)")
+	maxConnsPerIP	= flag.Int("max-conns-per-ip", defaults.MaxConnsPerIP, "Max concurrent connections from one IP (0 = unlimited)")
+	cmdRate		= flag.Float64("rate", defaults.CommandRate, "Commands per second allowed per session (0 = unlimited)")
+	cmdBurst	= flag.Int("burst", defaults.CommandBurst, "Commands a session may send in a burst")
+	authRate	= flag.Float64("auth-rate", defaults.AuthRate, "AUTH attempts per second allowed per connection (0 = unlimited)")
+	authBurst	= flag.Int("auth-burst", defaults.AuthBurst, "AUTH attempts a connection may send in a burst")
+	logLevel	= flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+	logFormat	= flag.String("log-format", "text", "Log format (text or json)")
+	auditFile	= flag.String("audit-log", "data/audit.log", "Append-only audit log file (empty to disable)")
+	adminAddr	= flag.String("admin-addr", "", "HTTP address for metrics/health/sessions, e.g. :9090 (empty to disable). Without a host it listens on 127.0.0.1 only, /sessions shows every user and IP without a login")
+	apiAddr		= flag.String("api-addr", "", "HTTP address for the REST API, e.g. :8090 (empty to disable)")
+	apiTokenTTL	= flag.Duration("api-token-ttl", defaults.APITokenTTL, "Idle time before a REST token expires (0 = never)")
+	wsAddr		= flag.String("ws-addr", "", "HTTP address for the browser client and WebSocket gateway, e.g. :8088 (empty to disable)")
 )
 
 
-
-// main func to run	
+// main func to run
 func main() {
 	// Parse cmd-line flags
 	flag.Parse()
 
 	// Set up logger
-	log.SetOutput(os.Stdout)
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+	logger, err := newLogger(os.Stdout, *logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
 
+	auditLog := audit.Discard()
+	if *auditFile != "" {
+		auditLog, err = audit.Open(*auditFile)
+		if err != nil {
+			fatal("Failed to open audit log", "err", err)
+		}
+		defer auditLog.Close()
+	}
 
 	// Initialize the storage
-	var st storage.StorageType 
+	var st storage.StorageType
 	if *storageType == "gob" {
 		st = storage.GOBStorage
 	} else {
@@ -45,174 +96,142 @@ func main() {
 	// Load Users
 	users, err := userStorage.LoadUsers()
 	if err != nil {
-		log.Fatalf("Failed to load users: %v", err)
+		fatal("Failed to load users", "err", err)
 	}
 
-	log.Printf("Loaded %d users from file", len(users))
+	slog.Info("Loaded users from file", "count", len(users), "file", *userFile)
 
 	// Create auth manager
-	authManager := auth.NewAuthManager(users)
-
-	listener, err := net.Listen("tcp", ":"+*port)
+	authManager, err := auth.NewAuthManager(users)
 	if err != nil {
-		log.Fatalf("Failed to start tcp server: %v" , err)
+		fatal("Invalid users file", "file", *userFile, "err", err)
 	}
-	// delay to execute this command until func main ending
-	// help the resources free if the errors occur
-	defer listener.Close()
-
-	fmt.Printf("Server TCP is running on port %s!\n", *port)
+	var key []byte
+	if *tokenKey != "" {
+		if key, err = auth.LoadTokenKey(*tokenKey); err != nil {
+			fatal("Failed to load token key", "err", err)
+		}
+	}
+	authManager.SetTokenKey(key, *tokenTTL)
+	policy, err := auth.ParseSessionPolicy(*sessionPolicy)
+	if err != nil {
+		fatal("Invalid -session-policy", "err", err)
+	}
+	authManager.SetSessionPolicy(policy)
 
-	// Accept and Handle Connecting
-	for {
-		conn, err := listener.Accept()
+	// STARTTLS only when there is a certificate
+	var tlsConfig *tls.Config
+	if *tlsCert != "" || *tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
 		if err != nil {
-			log.Printf("Failed to accepting connect from : %v", err)
-			continue
+			fatal("Failed to load TLS certificate", "err", err)
 		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	if *requireTLS && tlsConfig == nil {
+		fatal("-require-tls needs -tls-cert and -tls-key")
+	}
 
-		// handle connect in each goroutine
-		go handleConnection(conn, authManager)
+	var gameStorage *storage.GameStorage
+	if *gameFile != "" {
+		gameStorage = storage.NewGameStorage(*gameFile)
 	}
-}
 
-func handleConnection(conn net.Conn, authManager *auth.AuthManager) {
-	defer conn.Close() // close connect when this function ending to avoid resource leakage
+	cfg := server.Config{
+		Addr:              ":" + *port,
+		FileRoot:          *fileRoot,
+		ExcludePatterns:   util.SplitList(*exclude, ","),
+		ListPageSize:      *pageSize,
+		MaxUploadSize:     *maxUpload,
+		UploadQuota:       *uploadQuota,
+		Compression:       protocol.ParseCodecs(*compression),
+		CompressThreshold: *compressMin,
+		TLSConfig:         tlsConfig,
+		RequireTLS:        *requireTLS,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		MaxMissed:         *maxMissed,
+		MaxConns:          *maxConns,
+		MaxConnsPerIP:     *maxConnsPerIP,
+		CommandRate:       *cmdRate,
+		CommandBurst:      *cmdBurst,
+		AuthRate:          *authRate,
+		AuthBurst:         *authBurst,
+		APITokenTTL:       *apiTokenTTL,
+		Users:             userStorage,
+		Games:             gameStorage,
+		GameSaveInterval:  *gameSave,
+		Logger:            logger,
+		Audit:             auditLog,
+	}
+	srv := server.New(cfg, authManager)
+	if err := srv.Listen(); err != nil {
+		fatal("Failed to start tcp server", "err", err)
+	}
 
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("Panic in connection handler: %v", r)
+	if *adminAddr != "" {
+		startHTTPServer("Admin HTTP server", loopbackDefault(*adminAddr), srv.AdminHandler(), nil)
+	}
+	if *apiAddr != "" {
+		// REST logins need TLS as well, plain HTTP ones are refused
+		var apiTLS *tls.Config
+		if *requireTLS {
+			apiTLS = tlsConfig
 		}
-	}()
-
+		startHTTPServer("REST API", *apiAddr, srv.APIHandler(), apiTLS)
+	}
+	if *wsAddr != "" {
+		startWebServer(*wsAddr, srv.WebSocketHandler())
+	}
 
-	clientAddr := conn.RemoteAddr().String() // get IP address and Port of client
-	fmt.Printf("New connection from %s\n", clientAddr) // on terminal
+	// Ctrl+C closes the listener and every client, then main returns and the deferred closes run
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		slog.Info("Shutting down")
+		srv.Close()
+	}()
 
-	// conn.Write([]byte("Welcome to TCP server!\n")) // send welcome message to client - transform into bytes because Write method require data as byte format
-	msgHandler := protocol.NewMessageHandler(conn)
-	if err := msgHandler.SendMessage(0, protocol.CommandType("SERVER"), "Welcome to TCP Socket Server! Please use AUTH username password to login."); err != nil {
-			log.Printf("Failed to send welcome message: %v", err)
-			return 
+	if err := srv.Serve(); err != nil {
+		fatal("Server stopped", "err", err)
 	}
-	
-	// Default sessionID
-	sessionID := 0
-	authenticated := false
+}
 
-	// [process to handle receive message from client]
-	for {
-		msg, err := msgHandler.ReadMessage()
+// startHTTPServer serves one of the HTTP surfaces (admin, REST API, WebSocket gateway) on its own port
+// With tlsConfig it is HTTPS, the certificate comes from the config
+func startHTTPServer(name, addr string, handler http.Handler, tlsConfig *tls.Config) {
+	httpServer := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 5 * time.Second,
+		TLSConfig:         tlsConfig,
+	}
+	go func() {
+		slog.Info(name+" is running", "addr", addr, "tls", tlsConfig != nil)
+		var err error
+		if tlsConfig != nil {
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
 		if err != nil {
-			fmt.Printf("Connection from %s closed: %v \n", clientAddr, err)
-			return
+			slog.Error(name+" stopped", "err", err)
 		}
+	}()
+}
 
-		// Showing message received
-		fmt.Printf("Received from %s: Command= %s - SessionID= %d - Payload= %s\n", clientAddr, msg.Command, msg.SessionID, msg.Payload)
-		
-		// Process message based on commamd
-		switch msg.Command {
-		case protocol.CmdAuth:
-			// Handle authentication
-			if authenticated {
-				if err := msgHandler.SendMessage(sessionID, protocol.CommandType("ERROR"), "Already authenticated"); err != nil {
-					log.Printf("Failed to send error message: %v", err)
-				}
-				continue // ignore new cmd line
-			}
-
-			// Virtual authenticate simply
-			parts := strings.SplitN(msg.Payload, " ", 2)
-			if len(parts) != 2 {
-				msgHandler.SendMessage(0, protocol.CommandType("ERROR"), "Invalid auth format")
-				continue
-			}
-
-			username, password := parts[0], parts[1]
-
-			// Authenticate user
-			newSessionID, err := authManager.AuthenticateUser(username, password)
-			if err != nil {
-				if err := msgHandler.SendMessage(0, protocol.CommandType("ERROR"), "Authentication Failed: " + err.Error()); err != nil {
-					log.Print("Failed to send error message: %v", err)
-				}
-				continue
-			}
-
-			sessionID = newSessionID
-			authenticated = true
-
-			if err := msgHandler.SendMessage(sessionID, protocol.CommandType("OK"), fmt.Sprintf("Authentication Successful. Your session ID is %d", sessionID)); err != nil {
-				log.Printf("Failed to send success message: %v", err)
-				break
-			}
-			log.Printf("Client %s authenticated as %s with session ID %d", clientAddr, username, sessionID)
-
-		case protocol.CmdQuit:
-			if authenticated && msg.SessionID != sessionID{
-				if err := msgHandler.SendMessage(sessionID, protocol.CommandType("ERROR"), "Invalid session ID"); err != nil {
-					log.Printf("Failed to send error message: %v", err)
-				}
-				continue
-			}
-
-			// Send goodbye
-			if authenticated {
-				if err := msgHandler.SendMessage(sessionID, protocol.CommandType("BYE"), "Goodbye!"); err != nil {
-					log.Printf("Failed to send goodbye message: %v", err)
-				}
-			} else {
-				if err := msgHandler.SendMessage(0, protocol.CommandType("BYE"), "Goodbye!"); err != nil {
-					log.Printf("Failed to send goodbye message: %v", err)
-				}
-			}
-			
-			log.Printf("Client %s disconnected", clientAddr)
-			return
-		default:
-			// Check authentication
-			if !authenticated {
-				if err := msgHandler.SendMessage(0, protocol.CommandType("ERROR"), "Not authenticated"); err != nil {
-					log.Printf("Failed to send error message: %v", err)
-				}
-				continue
-			}
-
-			// Check session
-			if msg.SessionID != sessionID {
-				if err := msgHandler.SendMessage(sessionID, protocol.CommandType("ERROR"), "Invalid session ID"); err != nil {
-					log.Printf("Failed to send error message: %v", err)
-				}
-				continue
-			}
-
-			// Handle other commands (will implement later)
-			if err := msgHandler.SendMessage(sessionID, protocol.CommandType("ECHO"), 
-				fmt.Sprintf("Received command: %s with payload: %s", msg.Command, msg.Payload)); err != nil {
-				log.Printf("Failed to send response message: %v", err)
-				break
-			}
-		}
+// loopbackDefault puts 127.0.0.1 in front of a bare ":port", listening elsewhere has to be asked for
+func loopbackDefault(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
 	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
 
-	log.Printf("Connection from %s closed!", clientAddr)
-	// buffer := make([]byte, 1024) // create a slice as byte with size 1024 to contain data from client
-
-	// for {
-	// 	n, err := conn.Read(buffer) // read data from client into Buffer, return reading number of bytes n
-	// 	if err != nil {
-	// 		fmt.Printf("Connection from %s closed: %v \n", clientAddr, err)
-	// 		return 
-	// 	}
-
-	// 	// showing data getting from client
-	// 	data := buffer[:n]
-	// 	fmt.Printf("Recieved from %s: %s", clientAddr, string(data)) // byte to string
-
-	// 	// Sending repsonse Echo
-	// 	conn.Write([]byte(fmt.Sprintf("Echo: %s", string(data))))
-
-	// }
-	
-}
\ No newline at end of file
+// fatal logs an error and stops the server, slog has no Fatal
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
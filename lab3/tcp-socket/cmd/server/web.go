@@ -0,0 +1,23 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// the browser client, built into the binary so the server runs from any directory
+//
+//go:embed web
+var webFiles embed.FS
+
+// startWebServer serves the browser client on / and the WebSocket gateway on /ws
+func startWebServer(addr string, gateway http.Handler) {
+	static, _ := fs.Sub(webFiles, "web")
+
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(static)))
+	mux.Handle("/ws", gateway)
+
+	startHTTPServer("WebSocket gateway", addr, mux, nil)
+}
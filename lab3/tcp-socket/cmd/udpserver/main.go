@@ -0,0 +1,133 @@
+// udpserver - the same server as cmd/server, but clients talk to it over UDP datagrams
+// (internal/rudp adds sequence numbers, ACKs and retransmission). Run the client with -udp.
+// -loss drops outgoing datagrams on purpose, to compare with TCP on a bad network.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"socket-tcp/internal/auth"
+	"socket-tcp/internal/server"
+	"socket-tcp/internal/storage"
+	"socket-tcp/pkg/util"
+)
+
+var defaults = server.DefaultConfig()
+
+var (
+	port		= flag.String("port", "8081", "Server UDP port")
+	userFile	= flag.String("users", "data/users.json", "User data file, only read: cmd/server owns it")
+	storageType	= flag.String("storage", "json", "Storage type (json or gob)")
+	gameFile	= flag.String("games", "data/udp-games.json", "File that keeps running games over a restart, not the one of cmd/server (empty = games end with the server)")
+	gameSave	= flag.Duration("game-save-interval", defaults.GameSaveInterval, "How often running games are saved besides at shutdown (0 = only at shutdown)")
+	fileRoot	= flag.String("files", defaults.FileRoot, "Directory served by the FILE command")
+	exclude		= flag.String("exclude", strings.Join(defaults.ExcludePatterns, ","), "Comma-separated patterns hidden from LS/FIND/STAT/FILE (dot files always are)")
+	pageSize	= flag.Int("page-size", defaults.ListPageSize, "Entries per LS/FIND page (0 = no paging)")
+	maxUpload	= flag.Int64("max-upload", defaults.MaxUploadSize, "Max bytes per uploaded file (0 = unlimited)")
+	uploadQuota	= flag.Int64("upload-quota", defaults.UploadQuota, "Bytes each user may store in uploads unless set per user (0 = unlimited)")
+	readTimeout	= flag.Duration("read-timeout", defaults.ReadTimeout, "Idle time before the server sends a heartbeat PING")
+	writeTimeout	= flag.Duration("write-timeout", defaults.WriteTimeout, "Max time to retry one reliable datagram")
+	maxMissed	= flag.Int("max-missed", defaults.MaxMissed, "Heartbeats a client may miss before it is disconnected")
+	maxConns	= flag.Int("max-conns", defaults.MaxConns, "Max concurrent clients (0 = unlimited)")
+	maxConnsPerIP	= flag.Int("max-conns-per-ip", defaults.MaxConnsPerIP, "Max concurrent clients from one IP (0 = unlimited)")
+	rto		= flag.Duration("rto", defaults.UDP.RetransmitTimeout, "Wait for an ACK before the first retransmission")
+	retries		= flag.Int("retries", defaults.UDP.MaxRetries, "Retransmissions before a client is considered gone")
+	loss		= flag.Float64("loss", 0, "Probability to drop an outgoing datagram (0-1), simulates packet loss")
+	logLevel	= flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+	tokenKey	= flag.String("token-key", "", "File with the access token signing key, shared with cmd/server (empty = random, tokens end with the process)")
+	tokenTTL	= flag.Duration("token-ttl", auth.DefaultTokenTTL, "How long an access token issued at login is valid")
+	sessionPolicy	= flag.String("session-policy", string(auth.SessionPolicyMany), "What a login does to the user's other sessions: many (keep them), kick (end them) or reject (refuse the login)")
+)
+
+func main() {
+	flag.Parse()
+
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(*logLevel)); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid log level %q\n", *logLevel)
+		os.Exit(2)
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: lvl}))
+	slog.SetDefault(logger)
+
+	st := storage.JSONStorage
+	if *storageType == "gob" {
+		st = storage.GOBStorage
+	}
+	userStorage := storage.NewUserStorage(*userFile, st)
+	users, err := userStorage.LoadUsers()
+	if err != nil {
+		fatal("Failed to load users", "err", err)
+	}
+	slog.Info("Loaded users from file", "count", len(users), "file", *userFile)
+
+	cfg := defaults
+	cfg.Addr = ":" + *port
+	cfg.FileRoot = *fileRoot
+	cfg.ExcludePatterns = util.SplitList(*exclude, ",")
+	cfg.ListPageSize = *pageSize
+	cfg.MaxUploadSize = *maxUpload
+	cfg.UploadQuota = *uploadQuota
+	cfg.ReadTimeout = *readTimeout
+	cfg.WriteTimeout = *writeTimeout
+	cfg.MaxMissed = *maxMissed
+	cfg.MaxConns = *maxConns
+	cfg.MaxConnsPerIP = *maxConnsPerIP
+	cfg.UDP.RetransmitTimeout = *rto
+	cfg.UDP.MaxRetries = *retries
+	cfg.UDP.Loss = *loss
+	// cfg.Users stays nil: cmd/server saves the same file from its own copy of the users, so saving
+	// here as well would undo its API keys and MFA changes. What changes over UDP ends with the process.
+	if *gameFile != "" {
+		cfg.Games = storage.NewGameStorage(*gameFile)
+	}
+	cfg.GameSaveInterval = *gameSave
+	cfg.Logger = logger
+
+	authManager, err := auth.NewAuthManager(users)
+	if err != nil {
+		fatal("Invalid users file", "file", *userFile, "err", err)
+	}
+	var key []byte
+	if *tokenKey != "" {
+		if key, err = auth.LoadTokenKey(*tokenKey); err != nil {
+			fatal("Failed to load token key", "err", err)
+		}
+	}
+	authManager.SetTokenKey(key, *tokenTTL)
+	policy, err := auth.ParseSessionPolicy(*sessionPolicy)
+	if err != nil {
+		fatal("Invalid -session-policy", "err", err)
+	}
+	authManager.SetSessionPolicy(policy)
+
+	srv := server.New(cfg, authManager)
+	if err := srv.ListenUDP(); err != nil {
+		fatal("Failed to start udp server", "err", err)
+	}
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		slog.Info("Shutting down")
+		srv.Close()
+	}()
+
+	if err := srv.ServeUDP(); err != nil {
+		fatal("Server stopped", "err", err)
+	}
+}
+
+// fatal logs an error and stops the server, slog has no Fatal
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
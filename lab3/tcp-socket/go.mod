@@ -0,0 +1,3 @@
+module socket-tcp
+
+go 1.22
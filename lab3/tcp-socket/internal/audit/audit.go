@@ -0,0 +1,88 @@
+// Audit - append-only trail of security relevant events (logins, logouts, admin actions, downloads)
+// Kept apart from the normal server log so it can be retained and reviewed on its own.
+
+package audit
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Event names written in the "event" field of every record
+const (
+	EventLogin                = "login"
+	EventLoginFailed          = "login_failed"
+	EventLogout               = "logout"
+	EventAdminAction          = "admin_action"
+	EventFileDownload         = "file_download"
+	EventFileUpload           = "file_upload"
+	EventAPIKeyCreated        = "api_key_created"
+	EventAPIKeyRevoked        = "api_key_revoked"
+	EventMFAEnabled           = "mfa_enabled"
+	EventMFADisabled          = "mfa_disabled"
+	EventRecoveryCodeUsed     = "recovery_code_used"
+	EventRecoveryCodesRenewed = "recovery_codes_renewed"
+	EventSessionRevoked       = "session_revoked"
+)
+
+// Audit records have no level and carry the event name as "event" instead of "msg"
+var handlerOptions = &slog.HandlerOptions{
+	ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+		switch a.Key {
+		case slog.LevelKey:
+			return slog.Attr{}
+		case slog.MessageKey:
+			a.Key = "event"
+		}
+		return a
+	},
+}
+
+// Log writes one JSON line per event
+type Log struct {
+	logger *slog.Logger
+	closer io.Closer
+	mu     sync.Mutex
+}
+
+// Open opens (or creates) the audit file in append mode
+func Open(path string) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Log{
+		logger: slog.New(slog.NewJSONHandler(file, handlerOptions)),
+		closer: file,
+	}, nil
+}
+
+// Discard returns a Log that drops every event, used when auditing is turned off
+func Discard() *Log {
+	return &Log{
+		logger: slog.New(slog.NewJSONHandler(io.Discard, handlerOptions)),
+	}
+}
+
+// Record appends an event with extra key/value attributes
+func (l *Log) Record(event string, attrs ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logger.Info(event, attrs...)
+}
+
+// Close flushes and closes the underlying file
+func (l *Log) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
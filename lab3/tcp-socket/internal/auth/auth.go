@@ -2,33 +2,70 @@ package auth
 
 import (
 	"socket-tcp/internal/model"
+	"socket-tcp/pkg/util"
 	"encoding/base64"
 	"sync"
 	"errors"
-	"crypto/rand"
-	"math/big"
+	"sort"
+	"time"
 )
 
+// ErrUserNotFound is returned for an unknown username
+var ErrUserNotFound = errors.New("User not found")
+
+// ErrNoFreeSession - every session ID is taken, the login can be retried once someone logs out
+var ErrNoFreeSession = errors.New("No free session, try again later")
+
+// Session IDs are 3 digits, so there are at most MaxSessionID-MinSessionID+1 sessions at once
+const (
+	MinSessionID = 100
+	MaxSessionID = 999
+)
+
+// random draws before newSession looks for a free ID in order
+const maxSessionIDTries = 20
+
 // AuthManager handles authentication and session management
 // Using maps to quickly access following key	
 type AuthManager struct {
 	users 				map[string]*model.User				// username -> User
 	connectedUsers		map[int]*model.ConnectedClient		// SessionID -> ConnectedClient
+	apiKeys 			map[string]string 			// APIKey.ID -> username
+	tokenKey 			[]byte 						// HMAC key of the access tokens, see token.go
+	tokenTTL 			time.Duration
+	mfaPending 			map[string]string 			// username -> TOTP secret waiting for EnableMFA
+	totpUsed 			map[string]int64 			// username -> last TOTP period used, against replays
+	policy 				SessionPolicy 				// what a login does to the user's other sessions, see sessions.go
+	onEvict 			[]func(SessionInfo) 		// told about sessions SessionPolicyKick ended
 	mu 					sync.RWMutex 				// avoid race condition when many process access one resources - can be a variable
 }
 
-func NewAuthManager(users []*model.User) *AuthManager { // users slice
+// NewAuthManager fails on users that do not validate or share a username, see model.CheckUsers
+func NewAuthManager(users []*model.User) (*AuthManager, error) { // users slice
+	if err := model.CheckUsers(users); err != nil {
+		return nil, err
+	}
 	// transform to format - map
 	userMap := make(map[string]*model.User)
+	keyMap := make(map[string]string)
 	for _, user := range users {
 		userMap[user.Username] = user 
+		for _, key := range user.APIKeys {
+			keyMap[key.ID] = user.Username
+		}
 	}
 
 	return &AuthManager{
 		users: 			userMap,
 		connectedUsers: make(map[int]*model.ConnectedClient),
+		apiKeys: 		keyMap,
+		tokenKey: 		newTokenKey(), // tokens die with the process unless SetTokenKey is called
+		tokenTTL: 		DefaultTokenTTL,
+		mfaPending: 	make(map[string]string),
+		totpUsed: 		make(map[string]int64),
+		policy: 		SessionPolicyMany,
 		mu:				sync.RWMutex{},
-	}
+	}, nil
 }
 
 // EncryptPassword - using base64
@@ -50,39 +87,76 @@ func (am *AuthManager) AuthenticateUser(username, password string) (int, error)
 	am.mu.RUnlock()
 
 	if !exists {
-		return 0, errors.New("User not found")
+		return 0, ErrUserNotFound
 	}
 
 	if !VerifyPassword(password, user.Password) {
 		return 0, errors.New("Invalid Password")
 	}
+	if user.MFAEnabled() {
+		return 0, ErrMFARequired // the password was right, AuthenticateMFA finishes the login
+	}
+	return am.newSession(user)
+}
 
-	sessionID, err := GenerateSessionID()
+// newSession logs the user in under a new unique session ID
+func (am *AuthManager) newSession(user *model.User) (int, error) {
+	am.mu.Lock()
+
+	evicted, err := am.applyPolicyLocked(user.Username)
 	if err != nil {
+		am.mu.Unlock()
 		return 0, err
 	}
+	sessionID, err := am.freeSessionIDLocked()
+	if err == nil {
+		// create and store connected client
+		am.connectedUsers[sessionID] = &model.ConnectedClient{
+			User: user,
+			SessionID: sessionID,
+			LoginTime: time.Now(),
+		}
+	}
+	hooks := am.onEvict
+	am.mu.Unlock()
 
-	// Make sure we have unique sessionID
-	am.mu.Lock()
-	defer am.mu.Unlock()
-
-	for {
-		if _, exists := am.connectedUsers[sessionID]; !exists {
-			break 
+	// outside the lock, the hooks may well call back
+	for _, info := range evicted {
+		for _, hook := range hooks {
+			hook(info)
 		}
-		sessionID, err = GenerateSessionID()
+	}
+	if err != nil {
+		return 0, err
+	}
+	return sessionID, nil
+}
+
+// freeSessionIDLocked draws a few random IDs, then takes the next free one after the last draw
+// so a nearly full pool cannot keep the lock for long
+func (am *AuthManager) freeSessionIDLocked() (int, error) {
+	pool := MaxSessionID - MinSessionID + 1
+	if len(am.connectedUsers) >= pool {
+		return 0, ErrNoFreeSession
+	}
+	sessionID := 0
+	for i := 0; i < maxSessionIDTries; i++ {
+		id, err := GenerateSessionID()
 		if err != nil {
 			return 0, err
 		}
+		if _, exists := am.connectedUsers[id]; !exists {
+			return id, nil
+		}
+		sessionID = id
 	}
-	// create and store connected client
-	client := &model.ConnectedClient{
-		User: user,
-		SessionID: sessionID,
+	for i := 1; i < pool; i++ {
+		id := MinSessionID + (sessionID-MinSessionID+i)%pool
+		if _, exists := am.connectedUsers[id]; !exists {
+			return id, nil
+		}
 	}
-	am.connectedUsers[sessionID] = client
-	
-	return sessionID, nil
+	return 0, ErrNoFreeSession
 }
 
 // ValidateSession if session ID is valid
@@ -93,12 +167,110 @@ func (am *AuthManager) ValidateSession(sessionID int) bool {
 	return exists
 }
 
-func GenerateSessionID() (int, error) {
-	// Generate a random number between 100 & 999
-	nBig, err := rand.Int(rand.Reader, big.NewInt(900))
-	if err != nil {
-		return 0, err
+// SessionInfo is the public view of a session, without the user's password
+type SessionInfo struct {
+	SessionID 		int 		`json:"session_id"`
+	Username 		string 		`json:"username"`
+	LoginTime 		time.Time 	`json:"login_time"`
+	Remote 			string 		`json:"remote,omitempty"`
+}
+
+func infoOf(client *model.ConnectedClient) SessionInfo {
+	return SessionInfo{
+		SessionID: client.SessionID,
+		Username:  client.User.Username,
+		LoginTime: client.LoginTime,
+		Remote:    client.Remote,
+	}
+}
+
+func sortSessions(sessions []SessionInfo) {
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LoginTime.Before(sessions[j].LoginTime)
+	})
+}
+
+// Sessions lists the active sessions ordered by login time
+func (am *AuthManager) Sessions() []SessionInfo {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	sessions := make([]SessionInfo, 0, len(am.connectedUsers))
+	for _, client := range am.connectedUsers {
+		sessions = append(sessions, infoOf(client))
+	}
+	sortSessions(sessions)
+	return sessions
+}
+
+// User returns the user logged in with the session, nil if there is none
+func (am *AuthManager) User(sessionID int) *model.User {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+	client, exists := am.connectedUsers[sessionID]
+	if !exists {
+		return nil
+	}
+	return client.User
+}
+
+// UpdateUser changes a user through a copy, so handlers still reading the old *model.User are not raced
+// Sessions of the user see the new copy from then on
+func (am *AuthManager) UpdateUser(username string, update func(u *model.User)) (*model.User, error) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	return am.updateUserLocked(username, update)
+}
+
+// updateUserLocked is UpdateUser for callers already holding am.mu
+func (am *AuthManager) updateUserLocked(username string, update func(u *model.User)) (*model.User, error) {
+	old, exists := am.users[username]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	updated := *old
+	update(&updated)
+	updated.Username = old.Username // the map key cannot change
+
+	am.users[username] = &updated
+	for _, client := range am.connectedUsers {
+		if client.User == old {
+			client.User = &updated
+		}
+	}
+	return &updated, nil
+}
+
+// Users returns every user ordered by username, e.g. to save them after UpdateUser
+func (am *AuthManager) Users() []*model.User {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	users := make([]*model.User, 0, len(am.users))
+	for _, user := range am.users {
+		users = append(users, user)
 	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Username < users[j].Username
+	})
+	return users
+}
 
-	return int(nBig.Int64()) + 100, nil
+// SessionCount returns how many sessions are active
+func (am *AuthManager) SessionCount() int {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+	return len(am.connectedUsers)
+}
+
+// RemoveSession drops a session, called when the connection goes away
+func (am *AuthManager) RemoveSession(sessionID int) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	delete(am.connectedUsers, sessionID)
+}
+
+func GenerateSessionID() (int, error) {
+	// Generate a random number between 100 & 999
+	return util.GenerateRandomInt(MinSessionID, MaxSessionID)
 }	
\ No newline at end of file
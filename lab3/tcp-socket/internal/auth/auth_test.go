@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"socket-tcp/internal/model"
+)
+
+func TestSessionPoolFull(t *testing.T) {
+	am, err := NewAuthManager([]*model.User{{Username: "bob", Password: EncryptPassword("pw")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := MaxSessionID - MinSessionID + 1
+	seen := make(map[int]bool)
+	for i := 0; i < pool; i++ {
+		id, err := am.AuthenticateUser("bob", "pw")
+		if err != nil || id < MinSessionID || id > MaxSessionID || seen[id] {
+			t.Fatalf("login %d: session %d, %v", i, id, err)
+		}
+		seen[id] = true
+	}
+	if _, err := am.AuthenticateUser("bob", "pw"); !errors.Is(err, ErrNoFreeSession) {
+		t.Fatalf("full pool: got %v", err)
+	}
+
+	am.RemoveSession(MinSessionID + 42)
+	if id, err := am.AuthenticateUser("bob", "pw"); err != nil || id != MinSessionID+42 {
+		t.Fatalf("got session %d, %v, want the freed one", id, err)
+	}
+}
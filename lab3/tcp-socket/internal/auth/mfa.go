@@ -0,0 +1,274 @@
+// MFA - time based one-time passwords (RFC 6238) as a second login step:
+//   - enrollment is two steps, BeginMFA hands out a secret and an otpauth:// URI for the authenticator app,
+//     EnableMFA turns it on once the app shows a matching code. Until then nothing is stored with the user.
+//   - a code is accepted one period before and after now, for clocks a little off, and only once.
+//   - recovery codes are for a lost phone, each works once. Only their SHA-256 is kept with the user.
+
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"socket-tcp/internal/model"
+	"socket-tcp/pkg/util"
+)
+
+const (
+	totpDigits = 6
+	totpPeriod = 30 // seconds
+	totpSkew   = 1  // periods accepted before and after the current one
+)
+
+// RecoveryCodeCount is how many recovery codes a user gets at a time
+const RecoveryCodeCount = 10
+
+var (
+	ErrMFARequired   = errors.New("MFA code required")
+	ErrInvalidCode   = errors.New("Invalid code")
+	ErrMFAEnabled    = errors.New("MFA is already enabled")
+	ErrMFANotEnabled = errors.New("MFA is not enabled")
+	ErrNoMFASetup    = errors.New("No MFA setup in progress")
+)
+
+var secretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a new 160 bit secret in base32, the form authenticator apps take
+func GenerateTOTPSecret() (string, error) {
+	key, err := util.GenerateRandomBytes(20)
+	if err != nil {
+		return "", err
+	}
+	return secretEncoding.EncodeToString(key), nil
+}
+
+// TOTPURI is the otpauth:// URI of a secret, shown as a QR code it sets up an authenticator app in one scan
+func TOTPURI(account, secret string) string {
+	query := url.Values{
+		"secret":    {secret},
+		"issuer":    {tokenIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {strconv.Itoa(totpDigits)},
+		"period":    {strconv.Itoa(totpPeriod)},
+	}
+	return "otpauth://totp/" + url.PathEscape(tokenIssuer+":"+account) + "?" + query.Encode()
+}
+
+// TOTPCode returns the code of the secret at time t, what the authenticator app shows
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(t.Unix()/totpPeriod)), nil
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	key, err := secretEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil || len(key) == 0 {
+		return nil, errors.New("invalid TOTP secret")
+	}
+	return key, nil
+}
+
+// hotp is RFC 4226: HMAC-SHA1 of the counter, dynamically truncated to totpDigits
+func hotp(key []byte, counter uint64) string {
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1_000_000)
+}
+
+// checkTOTP returns the period the code belongs to, periods up to last were used already
+func checkTOTP(secret, code string, now time.Time, last int64) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step > last && hmac.Equal([]byte(hotp(key, uint64(step))), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// newRecoveryCodes returns codes to show the user once and the hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := util.GenerateRandomString(10, util.Digits+util.LowerLetters)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode ignores case and dashes, people type codes the way they like
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.ReplaceAll(code, "-", ""))))
+	return hex.EncodeToString(sum[:])
+}
+
+// BeginMFA starts the enrollment with a fresh secret, a second call replaces the first one
+func (am *AuthManager) BeginMFA(username string) (secret, uri string, err error) {
+	secret, err = GenerateTOTPSecret()
+	if err != nil {
+		return "", "", err
+	}
+
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	user, exists := am.users[username]
+	if !exists {
+		return "", "", ErrUserNotFound
+	}
+	if user.MFAEnabled() {
+		return "", "", ErrMFAEnabled
+	}
+	am.mfaPending[username] = secret
+	return secret, TOTPURI(username, secret), nil
+}
+
+// EnableMFA finishes the enrollment with a code from the app and returns the recovery codes
+// Logins that skip the password step must not outlive it: the user's API keys are revoked
+// and the access tokens issued so far stop working
+func (am *AuthManager) EnableMFA(username, code string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	user, exists := am.users[username]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	if user.MFAEnabled() {
+		return nil, ErrMFAEnabled
+	}
+	secret, pending := am.mfaPending[username]
+	if !pending {
+		return nil, ErrNoMFASetup
+	}
+	step, ok := checkTOTP(secret, strings.TrimSpace(code), time.Now(), 0)
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+
+	am.updateUserLocked(username, func(u *model.User) {
+		u.TOTPSecret = secret
+		u.RecoveryCodes = hashes
+		u.APIKeys = nil
+		u.TokenGeneration++
+	})
+	for _, key := range user.APIKeys {
+		delete(am.apiKeys, key.ID)
+	}
+	delete(am.mfaPending, username)
+	am.totpUsed[username] = step
+	return codes, nil
+}
+
+// DisableMFA turns MFA off, it takes a code too so a forgotten open session is not enough
+func (am *AuthManager) DisableMFA(username, code string) error {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	if _, err := am.verifyMFALocked(username, code); err != nil {
+		return err
+	}
+	am.updateUserLocked(username, func(u *model.User) {
+		u.TOTPSecret = ""
+		u.RecoveryCodes = nil
+	})
+	delete(am.totpUsed, username)
+	return nil
+}
+
+// NewRecoveryCodes replaces all recovery codes of the user, the old ones stop working
+func (am *AuthManager) NewRecoveryCodes(username, code string) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	if _, err := am.verifyMFALocked(username, code); err != nil {
+		return nil, err
+	}
+	am.updateUserLocked(username, func(u *model.User) {
+		u.RecoveryCodes = hashes
+	})
+	return codes, nil
+}
+
+// MFAStatus tells whether MFA is on and how many recovery codes are left
+func (am *AuthManager) MFAStatus(username string) (enabled bool, recoveryCodes int, err error) {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+	user, exists := am.users[username]
+	if !exists {
+		return false, 0, ErrUserNotFound
+	}
+	return user.MFAEnabled(), len(user.RecoveryCodes), nil
+}
+
+// AuthenticateMFA is the second login step, only call it after AuthenticateUser answered ErrMFARequired
+// for the same user. usedRecovery means a recovery code was spent and the users should be saved.
+func (am *AuthManager) AuthenticateMFA(username, code string) (sessionID int, usedRecovery bool, err error) {
+	am.mu.Lock()
+	usedRecovery, err = am.verifyMFALocked(username, code)
+	user := am.users[username]
+	am.mu.Unlock()
+	if err != nil {
+		return 0, false, err
+	}
+	sessionID, err = am.newSession(user)
+	return sessionID, usedRecovery, err
+}
+
+// verifyMFALocked accepts a TOTP code or spends a recovery code
+func (am *AuthManager) verifyMFALocked(username, code string) (bool, error) {
+	user, exists := am.users[username]
+	if !exists {
+		return false, ErrUserNotFound
+	}
+	if !user.MFAEnabled() {
+		return false, ErrMFANotEnabled
+	}
+
+	code = strings.TrimSpace(code)
+	if step, ok := checkTOTP(user.TOTPSecret, code, time.Now(), am.totpUsed[username]); ok {
+		am.totpUsed[username] = step // no replay of a code seen on the wire
+		return false, nil
+	}
+	hash := hashRecoveryCode(code)
+	for i, stored := range user.RecoveryCodes {
+		if hmac.Equal([]byte(stored), []byte(hash)) {
+			am.updateUserLocked(username, func(u *model.User) {
+				u.RecoveryCodes = append(append([]string(nil), u.RecoveryCodes[:i]...), u.RecoveryCodes[i+1:]...)
+			})
+			return true, nil
+		}
+	}
+	return false, ErrInvalidCode
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA-1 vectors of RFC 6238 appendix B, cut to 6 digits
+func TestTOTPCode(t *testing.T) {
+	secret := secretEncoding.EncodeToString([]byte("12345678901234567890"))
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1234567890:  "005924",
+		20000000000: "353130",
+	} {
+		code, err := TOTPCode(secret, time.Unix(unix, 0))
+		if err != nil || code != want {
+			t.Errorf("TOTPCode at %d = %q, %v, want %s", unix, code, err, want)
+		}
+	}
+	// apps show the secret in lower case or with padding sometimes
+	if code, _ := TOTPCode(strings.ToLower(secret)+"====", time.Unix(59, 0)); code != "287082" {
+		t.Errorf("lower case secret gives %q", code)
+	}
+}
+
+func TestCheckTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	current := now.Unix() / totpPeriod
+	code := func(offset int64) string {
+		c, _ := TOTPCode(secret, now.Add(time.Duration(offset)*totpPeriod*time.Second))
+		return c
+	}
+
+	for offset := int64(-1); offset <= 1; offset++ {
+		if step, ok := checkTOTP(secret, code(offset), now, 0); !ok || step != current+offset {
+			t.Errorf("code %d periods off: step %d, %v", offset, step, ok)
+		}
+	}
+	for _, offset := range []int64{-2, 2} {
+		if _, ok := checkTOTP(secret, code(offset), now, 0); ok {
+			t.Errorf("code %d periods off accepted", offset)
+		}
+	}
+	if _, ok := checkTOTP(secret, code(0), now, current); ok {
+		t.Error("a used period was accepted again")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri := TOTPURI("admin user", "ABC")
+	want := "otpauth://totp/socket-tcp:admin%20user?algorithm=SHA1&digits=6&issuer=socket-tcp&period=30&secret=ABC"
+	if uri != want {
+		t.Errorf("got %s\nwant %s", uri, want)
+	}
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SessionPolicy decides what a login does when the user already has sessions
+type SessionPolicy string
+
+const (
+	SessionPolicyMany   SessionPolicy = "many"   // every login gets its own session
+	SessionPolicyKick   SessionPolicy = "kick"   // one session per user, a new login ends the old ones
+	SessionPolicyReject SessionPolicy = "reject" // one session per user, no login while it lasts
+)
+
+// ErrAlreadyLoggedIn is the answer to a login under SessionPolicyReject
+var ErrAlreadyLoggedIn = errors.New("User is already logged in elsewhere")
+
+// ParseSessionPolicy checks a policy given as a flag
+func ParseSessionPolicy(s string) (SessionPolicy, error) {
+	switch policy := SessionPolicy(s); policy {
+	case SessionPolicyMany, SessionPolicyKick, SessionPolicyReject:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown session policy %q, use many, kick or reject", s)
+}
+
+// SetSessionPolicy changes the policy for the logins to come, sessions already open stay
+func (am *AuthManager) SetSessionPolicy(policy SessionPolicy) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	am.policy = policy
+}
+
+// OnEvict registers a function told about every session SessionPolicyKick ends,
+// the server uses it to hang up the connection behind it
+func (am *AuthManager) OnEvict(hook func(SessionInfo)) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	am.onEvict = append(am.onEvict, hook)
+}
+
+// applyPolicyLocked runs the policy before a new session of the user, it returns the sessions it ended
+func (am *AuthManager) applyPolicyLocked(username string) ([]SessionInfo, error) {
+	if am.policy != SessionPolicyKick && am.policy != SessionPolicyReject {
+		return nil, nil
+	}
+	existing := am.sessionsOfLocked(username)
+	if len(existing) == 0 {
+		return nil, nil
+	}
+	if am.policy == SessionPolicyReject {
+		return nil, ErrAlreadyLoggedIn
+	}
+	for _, info := range existing {
+		delete(am.connectedUsers, info.SessionID)
+	}
+	return existing, nil
+}
+
+// SessionsOf lists the user's sessions ordered by login time
+func (am *AuthManager) SessionsOf(username string) []SessionInfo {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+	return am.sessionsOfLocked(username)
+}
+
+func (am *AuthManager) sessionsOfLocked(username string) []SessionInfo {
+	var sessions []SessionInfo
+	for _, client := range am.connectedUsers {
+		if client.User.Username == username {
+			sessions = append(sessions, infoOf(client))
+		}
+	}
+	sortSessions(sessions)
+	return sessions
+}
+
+// Session returns one session, false if it does not exist
+func (am *AuthManager) Session(sessionID int) (SessionInfo, bool) {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+	client, exists := am.connectedUsers[sessionID]
+	if !exists {
+		return SessionInfo{}, false
+	}
+	return infoOf(client), true
+}
+
+// SetRemote records where the session's login came from, for SESSIONS and /sessions
+func (am *AuthManager) SetRemote(sessionID int, remote string) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	if client, exists := am.connectedUsers[sessionID]; exists {
+		client.Remote = remote
+	}
+}
@@ -0,0 +1,271 @@
+// Tokens - two ways to log in without sending the password again:
+//   - access tokens, JWTs signed with HMAC-SHA256 and handed out at every password login. They expire and
+//     carry the user's token generation, raising it (EnableMFA does) makes every older token invalid.
+//   - API keys "sk_<id>_<secret>" for scripts. They do not expire but can be revoked. Only the SHA-256 of a key
+//     is kept with the user.
+
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"socket-tcp/internal/model"
+	"socket-tcp/pkg/util"
+)
+
+// DefaultTokenTTL is how long an access token is valid unless SetTokenKey says otherwise
+const DefaultTokenTTL = time.Hour
+
+// tokenIssuer is the "iss" claim, a token of another issuer is not ours even if the key matches
+const tokenIssuer = "socket-tcp"
+
+// MaxAPIKeys limits the keys one user can have
+const MaxAPIKeys = 10
+
+const apiKeyPrefix = "sk_"
+
+var (
+	ErrInvalidToken   = errors.New("Invalid token")
+	ErrTokenExpired   = errors.New("Token expired")
+	ErrTokenRevoked   = errors.New("Token was revoked")
+	ErrAPIKeyNotFound = errors.New("API key not found")
+)
+
+// the only header we issue and accept, "alg": "none" and friends are refused
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// TokenClaims is the payload of an access token
+type TokenClaims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"` // username
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	Gen       int    `json:"gen,omitempty"` // model.User.TokenGeneration at the time it was issued
+}
+
+func newTokenKey() []byte {
+	key, err := util.GenerateRandomBytes(32)
+	if err != nil {
+		panic("auth: no randomness for the token key: " + err.Error())
+	}
+	return key
+}
+
+// LoadTokenKey reads a signing key from a file, servers sharing the file accept each other's tokens
+func LoadTokenKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := bytes.TrimSpace(data)
+	if len(key) < 32 {
+		return nil, fmt.Errorf("token key in %s is too short, use at least 32 bytes", path)
+	}
+	return key, nil
+}
+
+// SetTokenKey replaces the signing key, tokens signed with the old one stop working
+// A key that survives restarts keeps tokens valid across them, nil keeps the random one
+func (am *AuthManager) SetTokenKey(key []byte, ttl time.Duration) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	if key != nil {
+		am.tokenKey = key
+	}
+	am.tokenTTL = ttl
+}
+
+// IssueToken signs an access token for the user
+func (am *AuthManager) IssueToken(username string) (string, time.Time, error) {
+	am.mu.RLock()
+	user, exists := am.users[username]
+	key, ttl := am.tokenKey, am.tokenTTL
+	am.mu.RUnlock()
+	if !exists {
+		return "", time.Time{}, ErrUserNotFound
+	}
+
+	now := time.Now()
+	expires := now.Add(ttl).Truncate(time.Second)
+	claims, err := json.Marshal(TokenClaims{Issuer: tokenIssuer, Subject: username, IssuedAt: now.Unix(), ExpiresAt: expires.Unix(), Gen: user.TokenGeneration})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	signed := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return signed + "." + sign(key, signed), expires, nil
+}
+
+// VerifyToken checks signature, expiry and generation of an access token and returns its claims
+func (am *AuthManager) VerifyToken(token string) (*TokenClaims, error) {
+	am.mu.RLock()
+	key := am.tokenKey
+	am.mu.RUnlock()
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return nil, ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(sign(key, parts[0]+"."+parts[1]))) {
+		return nil, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Issuer != tokenIssuer || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	am.mu.RLock()
+	user, exists := am.users[claims.Subject]
+	am.mu.RUnlock()
+	if exists && claims.Gen != user.TokenGeneration {
+		return nil, ErrTokenRevoked
+	}
+	return &claims, nil
+}
+
+func sign(key []byte, data string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// AuthenticateToken logs in with an access token or an API key, like AuthenticateUser does with a password
+func (am *AuthManager) AuthenticateToken(token string) (int, error) {
+	var username string
+	if strings.HasPrefix(token, apiKeyPrefix) {
+		user, err := am.checkAPIKey(token)
+		if err != nil {
+			return 0, err
+		}
+		username = user
+	} else {
+		claims, err := am.VerifyToken(token)
+		if err != nil {
+			return 0, err
+		}
+		username = claims.Subject
+	}
+
+	am.mu.RLock()
+	user, exists := am.users[username]
+	am.mu.RUnlock()
+	if !exists {
+		return 0, ErrUserNotFound // deleted after the token was issued
+	}
+	return am.newSession(user)
+}
+
+// checkAPIKey returns the owner of a valid key
+func (am *AuthManager) checkAPIKey(key string) (string, error) {
+	id, _, found := strings.Cut(strings.TrimPrefix(key, apiKeyPrefix), "_")
+	if !found {
+		return "", ErrInvalidToken
+	}
+
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+	username, exists := am.apiKeys[id]
+	if !exists {
+		return "", ErrInvalidToken
+	}
+	hash := hashAPIKey(key)
+	for _, stored := range am.users[username].APIKeys {
+		if stored.ID == id && hmac.Equal([]byte(stored.Hash), []byte(hash)) {
+			return username, nil
+		}
+	}
+	return "", ErrInvalidToken
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIKey adds a named key to the user, the returned key is the only time it can be seen
+func (am *AuthManager) CreateAPIKey(username, name string) (string, model.APIKey, error) {
+	id, err := util.GenerateRandomString(8, util.AlphaNumeric)
+	if err != nil {
+		return "", model.APIKey{}, err
+	}
+	secret, err := util.GenerateRandomString(32, util.AlphaNumeric)
+	if err != nil {
+		return "", model.APIKey{}, err
+	}
+	key := apiKeyPrefix + id + "_" + secret
+	info := model.APIKey{ID: id, Name: name, Hash: hashAPIKey(key), Created: time.Now().UTC().Truncate(time.Second)}
+
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	user, exists := am.users[username]
+	if !exists {
+		return "", model.APIKey{}, ErrUserNotFound
+	}
+	if len(user.APIKeys) >= MaxAPIKeys {
+		return "", model.APIKey{}, fmt.Errorf("At most %d API keys per user, revoke one first", MaxAPIKeys)
+	}
+	for _, existing := range user.APIKeys {
+		if existing.Name == name {
+			return "", model.APIKey{}, fmt.Errorf("An API key named %s already exists", name)
+		}
+	}
+	if _, taken := am.apiKeys[id]; taken {
+		return "", model.APIKey{}, errors.New("API key ID collision, try again")
+	}
+
+	am.updateUserLocked(username, func(u *model.User) {
+		u.APIKeys = append(append([]model.APIKey(nil), u.APIKeys...), info) // the old copy keeps its slice
+	})
+	am.apiKeys[id] = username
+	return key, info, nil
+}
+
+// RevokeAPIKey removes a key by ID or name, logins with it fail from now on
+func (am *AuthManager) RevokeAPIKey(username, idOrName string) (model.APIKey, error) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	user, exists := am.users[username]
+	if !exists {
+		return model.APIKey{}, ErrUserNotFound
+	}
+	for i, key := range user.APIKeys {
+		if key.ID != idOrName && key.Name != idOrName {
+			continue
+		}
+		am.updateUserLocked(username, func(u *model.User) {
+			u.APIKeys = append(append([]model.APIKey(nil), u.APIKeys[:i]...), u.APIKeys[i+1:]...)
+		})
+		delete(am.apiKeys, key.ID)
+		return key, nil
+	}
+	return model.APIKey{}, ErrAPIKeyNotFound
+}
+
+// APIKeys lists the user's keys in the order they were created
+func (am *AuthManager) APIKeys(username string) ([]model.APIKey, error) {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	user, exists := am.users[username]
+	if !exists {
+		return nil, ErrUserNotFound
+	}
+	return append([]model.APIKey(nil), user.APIKeys...), nil
+}
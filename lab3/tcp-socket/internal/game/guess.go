@@ -1,11 +1,26 @@
-package game 
+package game
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"sync"
+	"time"
 
 	"socket-tcp/internal/model"
 )
 
+// Default range of the secret number
+const (
+	MinNumber = 1
+	MaxNumber = 100
+)
+
+var (
+	ErrGameInProgress = errors.New("A game is already in progress, use END to stop it")
+	ErrNoActiveGame   = errors.New("No active game, use START to begin")
+)
+
 type GuessingGame struct {
 	games 		map[int]*model.GameState	// sessionID -> GameState
 	mu 			sync.RWMutex
@@ -18,19 +33,185 @@ func NewGuessingGame() *GuessingGame {
 	}
 }
 
-func (gg *GuessingGame) StartGame(sessionID int) (string, error) {
+// Outcome says whether a guess ended the game
+type Outcome int
+
+const (
+	Playing Outcome = iota
+	Won
+	Lost	// out of guesses or out of time
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Won:
+		return "won"
+	case Lost:
+		return "lost"
+	}
+	return "playing"
+}
+
+// StartGame picks a new secret for the session, zero options are the classic 1 to 100 game
+func (gg *GuessingGame) StartGame(sessionID int, opts model.GameOptions) (string, error) {
+	v, err := setup(&opts)
+	if err != nil {
+		return "", err
+	}
+
+	gg.mu.Lock()
+	defer gg.mu.Unlock()
+
+	now := time.Now()
+	if state, exists := gg.games[sessionID]; exists && state.InProgress && !expired(state, now) {
+		return "", ErrGameInProgress
+	}
+
+	target, err := v.Secret(opts)
+	if err != nil {
+		return "", err
+	}
+
+	state := &model.GameState{
+		Target: 		target,
+		InProgress: 	true,
+		Options: 		opts,
+	}
+	var limits []string
+	if opts.MaxGuesses > 0 {
+		limits = append(limits, fmt.Sprintf("%d guesses", opts.MaxGuesses))
+	}
+	if opts.TimeLimit > 0 {
+		state.Deadline = now.Add(opts.TimeLimit).UTC()
+		limits = append(limits, opts.TimeLimit.String())
+	}
+	gg.games[sessionID] = state
+
+	text := "Game started! " + v.Rules(opts)
+	if len(limits) > 0 {
+		text += ". You have " + strings.Join(limits, " and ")
+	}
+	return text, nil
+}
+
+// MakeGuess lets the variant judge a guess, the game is over unless the outcome is Playing
+func (gg *GuessingGame) MakeGuess(sessionID int, guess string) (string, Outcome, error) {
+	gg.mu.Lock()
+	defer gg.mu.Unlock()
+
+	state, exists := gg.games[sessionID]
+	if !exists || !state.InProgress {
+		return "", Playing, ErrNoActiveGame
+	}
+	v, err := Lookup(state.Options.Variant)
+	if err != nil {
+		return "", Playing, err
+	}
+	if expired(state, time.Now()) {
+		delete(gg.games, sessionID)
+		return "Time is up, game over. " + v.Reveal(state.Options, state.Target), Lost, nil
+	}
 
+	hint, correct, err := v.Judge(state.Options, state.Target, strings.TrimSpace(guess))
+	if err != nil {
+		return "", Playing, err
+	}
+	state.GuessCount++
+	if correct {
+		delete(gg.games, sessionID)
+		return fmt.Sprintf("Correct! %s, found in %d guesses", v.Reveal(state.Options, state.Target), state.GuessCount), Won, nil
+	}
+
+	if limit := state.Options.MaxGuesses; limit > 0 {
+		if state.GuessCount >= limit {
+			delete(gg.games, sessionID)
+			return hint + ". No guesses left, game over. " + v.Reveal(state.Options, state.Target), Lost, nil
+		}
+		hint += fmt.Sprintf(", %d guesses left", limit-state.GuessCount)
+	}
+	return hint, Playing, nil
 }
 
-func (gg *GuessingGame) MakeGuess() {
+// EndGame gives up the current game and reveals the secret
+func (gg *GuessingGame) EndGame(sessionID int) (string, error) {
+	gg.mu.Lock()
+	defer gg.mu.Unlock()
 
+	state, exists := gg.games[sessionID]
+	if !exists || !state.InProgress {
+		return "", ErrNoActiveGame
+	}
+	v, err := Lookup(state.Options.Variant)
+	if err != nil {
+		return "", err
+	}
+
+	delete(gg.games, sessionID)
+	return "Game ended. " + v.Reveal(state.Options, state.Target), nil
 }
 
-func (gg *GuessingGame) EndGame() {
+// Game returns a copy of the session's game, e.g. for the options START filled in
+func (gg *GuessingGame) Game(sessionID int) (model.GameState, bool) {
+	gg.mu.RLock()
+	defer gg.mu.RUnlock()
+
+	state, exists := gg.games[sessionID]
+	if !exists || !state.InProgress {
+		return model.GameState{}, false
+	}
+	return *state, true
+}
 
+// expired is a game whose time limit ran out, it ends on the next guess
+func expired(state *model.GameState, now time.Time) bool {
+	return !state.Deadline.IsZero() && now.After(state.Deadline)
 }
 
 // has active game trakc if a session has an active game
 func (gg *GuessingGame) HasActiveGame(sessionID int) bool {
+	gg.mu.RLock()
+	defer gg.mu.RUnlock()
 
-}
\ No newline at end of file
+	state, exists := gg.games[sessionID]
+	return exists && state.InProgress && !expired(state, time.Now())
+}
+
+// Snapshot copies the running games, e.g. to save them before a restart
+func (gg *GuessingGame) Snapshot() map[int]model.GameState {
+	gg.mu.RLock()
+	defer gg.mu.RUnlock()
+
+	now := time.Now()
+	games := make(map[int]model.GameState, len(gg.games))
+	for sessionID, state := range gg.games {
+		if state.InProgress && !expired(state, now) {
+			games[sessionID] = *state
+		}
+	}
+	return games
+}
+
+// Restore continues a saved game in the session, unless the session already plays one
+func (gg *GuessingGame) Restore(sessionID int, state model.GameState) error {
+	gg.mu.Lock()
+	defer gg.mu.Unlock()
+
+	now := time.Now()
+	if current, exists := gg.games[sessionID]; exists && current.InProgress && !expired(current, now) {
+		return ErrGameInProgress
+	}
+	if expired(&state, now) {
+		return errors.New("saved game ran out of time")
+	}
+	// games saved before there were variants have no options, setup makes them classic 1 to 100
+	v, err := setup(&state.Options)
+	if err != nil {
+		return fmt.Errorf("invalid saved game: %w", err)
+	}
+	if !v.Valid(state.Options, state.Target) || state.GuessCount < 0 {
+		return fmt.Errorf("invalid saved game: target %d, %d guesses", state.Target, state.GuessCount)
+	}
+	state.InProgress = true
+	gg.games[sessionID] = &state
+	return nil
+}
@@ -0,0 +1,166 @@
+package game
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"socket-tcp/internal/model"
+)
+
+// Limits on what START may ask for
+const (
+	MaxRange      = 1_000_000_000
+	MaxGuessLimit = 1000
+	MinTimeLimit  = 5 * time.Second
+	MaxTimeLimit  = 24 * time.Hour // saved games do not live longer
+)
+
+var (
+	ErrInvalidOptions = errors.New("Invalid game options")
+	ErrInvalidGuess   = errors.New("Invalid guess")
+)
+
+// Variant is one kind of guessing game. GuessingGame keeps the state, counts the guesses and
+// enforces the limits, a Variant decides what the secret is and what a guess is told.
+// New games only need Register, the server passes START options and guesses through as text.
+type Variant interface {
+	// Name is what START selects the variant with
+	Name() string
+	// Setup fills in the defaults and rejects options the variant does not take
+	Setup(opts *model.GameOptions) error
+	// Secret picks what the player has to find
+	Secret(opts model.GameOptions) (int, error)
+	// Valid says whether a secret fits the options, for restoring saved games
+	Valid(opts model.GameOptions, secret int) bool
+	// Rules tells the player what to guess, the START answer
+	Rules(opts model.GameOptions) string
+	// Judge answers a guess, an error wrapping ErrInvalidGuess rejects it without counting it
+	Judge(opts model.GameOptions, secret int, guess string) (hint string, correct bool, err error)
+	// Reveal says what the secret was once the game is over
+	Reveal(opts model.GameOptions, secret int) string
+}
+
+var (
+	variantsMu sync.RWMutex
+	variants   = make(map[string]Variant)
+)
+
+// Register makes a variant available to START, names are case-insensitive
+func Register(v Variant) {
+	variantsMu.Lock()
+	defer variantsMu.Unlock()
+
+	name := strings.ToLower(v.Name())
+	if _, dup := variants[name]; dup {
+		panic("game: variant " + name + " registered twice")
+	}
+	variants[name] = v
+}
+
+// Lookup finds a registered variant, "" is classic
+func Lookup(name string) (Variant, error) {
+	if name == "" {
+		name = Classic
+	}
+	variantsMu.RLock()
+	defer variantsMu.RUnlock()
+
+	v, exists := variants[strings.ToLower(name)]
+	if !exists {
+		return nil, fmt.Errorf("%w: unknown variant %q, try %s", ErrInvalidOptions, name, strings.Join(variantNamesLocked(), ", "))
+	}
+	return v, nil
+}
+
+// Variants lists the registered variant names
+func Variants() []string {
+	variantsMu.RLock()
+	defer variantsMu.RUnlock()
+	return variantNamesLocked()
+}
+
+func variantNamesLocked() []string {
+	names := make([]string, 0, len(variants))
+	for name := range variants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseOptions reads the START payload, "[variant] [range=1-500] [digits=4] [guesses=10] [time=2m]"
+// The variant may also be given as variant=name. Checking the values is left to StartGame.
+func ParseOptions(payload string) (model.GameOptions, error) {
+	var opts model.GameOptions
+	for i, field := range strings.Fields(payload) {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			if i > 0 {
+				return opts, fmt.Errorf("%w: %q is not key=value", ErrInvalidOptions, field)
+			}
+			key, value = "variant", field
+		}
+
+		var err error
+		switch strings.ToLower(key) {
+		case "variant":
+			opts.Variant = strings.ToLower(value)
+		case "range":
+			lo, hi, ok := strings.Cut(value, "-")
+			if !ok {
+				return opts, fmt.Errorf("%w: range is min-max, e.g. range=1-500", ErrInvalidOptions)
+			}
+			if opts.Min, err = strconv.Atoi(lo); err == nil {
+				opts.Max, err = strconv.Atoi(hi)
+			}
+		case "min":
+			opts.Min, err = strconv.Atoi(value)
+		case "max":
+			opts.Max, err = strconv.Atoi(value)
+		case "digits":
+			opts.Digits, err = strconv.Atoi(value)
+		case "guesses":
+			opts.MaxGuesses, err = strconv.Atoi(value)
+		case "time":
+			opts.TimeLimit, err = parseTimeLimit(value)
+		default:
+			return opts, fmt.Errorf("%w: unknown option %q", ErrInvalidOptions, key)
+		}
+		if err != nil {
+			return opts, fmt.Errorf("%w: bad value for %s: %q", ErrInvalidOptions, key, value)
+		}
+	}
+	return opts, nil
+}
+
+// parseTimeLimit takes a duration like 90s or 2m, a bare number is seconds
+func parseTimeLimit(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// setup resolves the variant and checks the options every variant shares
+func setup(opts *model.GameOptions) (Variant, error) {
+	v, err := Lookup(opts.Variant)
+	if err != nil {
+		return nil, err
+	}
+	opts.Variant = strings.ToLower(v.Name())
+	if err := v.Setup(opts); err != nil {
+		return nil, err
+	}
+	switch {
+	case opts.MaxGuesses < 0 || opts.MaxGuesses > MaxGuessLimit:
+		return nil, fmt.Errorf("%w: guesses must be 1 to %d, or 0 for no limit", ErrInvalidOptions, MaxGuessLimit)
+	case opts.TimeLimit != 0 && (opts.TimeLimit < MinTimeLimit || opts.TimeLimit > MaxTimeLimit):
+		return nil, fmt.Errorf("%w: time must be %v to %v, or 0 for no limit", ErrInvalidOptions, MinTimeLimit, MaxTimeLimit)
+	}
+	return v, nil
+}
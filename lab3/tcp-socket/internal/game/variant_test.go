@@ -0,0 +1,162 @@
+package game
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"socket-tcp/internal/model"
+)
+
+func TestParseOptions(t *testing.T) {
+	for payload, want := range map[string]model.GameOptions{
+		"":                                    {},
+		"hotcold range=1-500 guesses=10":      {Variant: HotCold, Min: 1, Max: 500, MaxGuesses: 10},
+		"variant=Mastermind digits=5 time=2m": {Variant: Mastermind, Digits: 5, TimeLimit: 2 * time.Minute},
+		"min=10 max=20 time=90":               {Min: 10, Max: 20, TimeLimit: 90 * time.Second},
+	} {
+		if got, err := ParseOptions(payload); err != nil || got != want {
+			t.Errorf("%q: got %+v, %v, want %+v", payload, got, err, want)
+		}
+	}
+	for _, payload := range []string{"classic hotcold", "range=5", "guesses=many", "time=soon", "colour=red"} {
+		if _, err := ParseOptions(payload); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("%q: got %v", payload, err)
+		}
+	}
+}
+
+func TestStartOptions(t *testing.T) {
+	gg := NewGuessingGame()
+	for i, opts := range []model.GameOptions{
+		{Variant: "chess"},
+		{Min: 50, Max: 50},
+		{Max: MaxRange + 1},
+		{Digits: 4},
+		{Variant: Mastermind, Max: 10},
+		{Variant: Mastermind, Digits: MaxDigits + 1},
+		{MaxGuesses: MaxGuessLimit + 1},
+		{TimeLimit: time.Second},
+	} {
+		if _, err := gg.StartGame(i, opts); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("%+v: got %v", opts, err)
+		}
+	}
+
+	text, err := gg.StartGame(1, model.GameOptions{Variant: HotCold, Max: 500, MaxGuesses: 10, TimeLimit: time.Minute})
+	if err != nil || text != "Game started! Guess a number between 1 and 500, hints say how close you are: burning, hot, warm, cool or cold. You have 10 guesses and 1m0s" {
+		t.Fatalf("got %q, %v", text, err)
+	}
+	state, _ := gg.Game(1)
+	if state.Options.Variant != HotCold || state.Target < 1 || state.Target > 500 || time.Until(state.Deadline) <= 0 {
+		t.Errorf("unexpected game %+v", state)
+	}
+}
+
+func TestMastermind(t *testing.T) {
+	var m mastermind
+	opts := model.GameOptions{Variant: Mastermind, Digits: 4}
+	for guess, want := range map[string]string{
+		"0132": "0132: 2 bulls, 2 cows",
+		"4567": "4567: 0 bulls, 0 cows",
+		"3210": "3210: 0 bulls, 4 cows",
+	} {
+		if hint, correct, err := m.Judge(opts, 123, guess); hint != want || correct || err != nil {
+			t.Errorf("%s: got %q, %v, %v", guess, hint, correct, err)
+		}
+	}
+	if _, correct, err := m.Judge(opts, 123, "0123"); !correct || err != nil {
+		t.Errorf("0123 not correct: %v", err)
+	}
+	for _, guess := range []string{"123", "01234", "0112", "01a3"} {
+		if _, _, err := m.Judge(opts, 123, guess); !errors.Is(err, ErrInvalidGuess) {
+			t.Errorf("%s: got %v", guess, err)
+		}
+	}
+	if got := m.Reveal(opts, 123); got != "The code was 0123" {
+		t.Errorf("Reveal = %q", got)
+	}
+
+	for i := 0; i < 100; i++ {
+		secret, err := m.Secret(opts)
+		if err != nil || !m.Valid(opts, secret) {
+			t.Fatalf("bad secret %d, %v", secret, err)
+		}
+	}
+}
+
+func TestHotCold(t *testing.T) {
+	var h hotCold
+	opts := model.GameOptions{Variant: HotCold, Min: 1, Max: 101}
+	for guess, want := range map[string]string{
+		"51":  "51 is burning",
+		"46":  "46 is hot",
+		"40":  "40 is warm",
+		"80":  "80 is cool",
+		"101": "101 is cold",
+	} {
+		if hint, _, err := h.Judge(opts, 50, guess); hint != want || err != nil {
+			t.Errorf("%s: got %q, %v", guess, hint, err)
+		}
+	}
+	for _, guess := range []string{"0", "102", "fifty"} {
+		if _, _, err := h.Judge(opts, 50, guess); !errors.Is(err, ErrInvalidGuess) {
+			t.Errorf("%s: got %v", guess, err)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	gg := NewGuessingGame()
+	if err := gg.Restore(1, model.GameState{Target: 50, Options: model.GameOptions{MaxGuesses: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if text, outcome, err := gg.MakeGuess(1, "abc"); err == nil || outcome != Playing {
+		t.Errorf("invalid guess taken: %q", text)
+	}
+	if text, outcome, _ := gg.MakeGuess(1, " 10 "); text != "10 is too low, 1 guesses left" || outcome != Playing {
+		t.Errorf("got %q, %v", text, outcome)
+	}
+	if text, outcome, _ := gg.MakeGuess(1, "90"); text != "90 is too high. No guesses left, game over. The number was 50" || outcome != Lost {
+		t.Errorf("got %q, %v", text, outcome)
+	}
+	if gg.HasActiveGame(1) {
+		t.Error("lost game still active")
+	}
+
+	if _, err := gg.StartGame(2, model.GameOptions{TimeLimit: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	gg.games[2].Deadline = time.Now().Add(-time.Second)
+	if gg.HasActiveGame(2) || len(gg.Snapshot()) != 0 {
+		t.Error("expired game still active")
+	}
+	if text, outcome, _ := gg.MakeGuess(2, "50"); !strings.HasPrefix(text, "Time is up, game over. The number was") || outcome != Lost {
+		t.Errorf("got %q, %v", text, outcome)
+	}
+}
+
+func TestRestoreOldSave(t *testing.T) {
+	gg := NewGuessingGame()
+	// saved before variants, no options
+	if err := gg.Restore(1, model.GameState{Target: 42, GuessCount: 3, InProgress: true}); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := gg.Game(1); state.Options != (model.GameOptions{Variant: Classic, Min: MinNumber, Max: MaxNumber}) {
+		t.Errorf("options %+v", state.Options)
+	}
+	if err := gg.Restore(2, model.GameState{Target: 1234, Options: model.GameOptions{Variant: Mastermind, Digits: 4}}); err != nil {
+		t.Error(err)
+	}
+	for _, state := range []model.GameState{
+		{Target: 101},
+		{Target: 1123, Options: model.GameOptions{Variant: Mastermind}},
+		{Target: 5, Options: model.GameOptions{Variant: "chess"}},
+		{Target: 5, Deadline: time.Now().Add(-time.Minute)},
+	} {
+		if err := gg.Restore(3, state); err == nil {
+			t.Errorf("restored %+v", state)
+		}
+	}
+}
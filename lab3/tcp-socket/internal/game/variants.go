@@ -0,0 +1,206 @@
+package game
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"socket-tcp/internal/model"
+	"socket-tcp/pkg/util"
+)
+
+// Names of the built-in variants
+const (
+	Classic    = "classic"
+	HotCold    = "hotcold"
+	Mastermind = "mastermind"
+)
+
+// Code lengths for mastermind, the digits of a code all differ
+const (
+	MinDigits     = 3
+	MaxDigits     = 8
+	DefaultDigits = 4
+)
+
+func init() {
+	Register(classic{})
+	Register(hotCold{})
+	Register(mastermind{})
+}
+
+// classic is the original game: too low or too high
+type classic struct{}
+
+func (classic) Name() string { return Classic }
+
+func (classic) Setup(opts *model.GameOptions) error { return setupRange(opts) }
+
+func (classic) Secret(opts model.GameOptions) (int, error) {
+	return util.GenerateRandomInt(opts.Min, opts.Max)
+}
+
+func (classic) Valid(opts model.GameOptions, secret int) bool {
+	return secret >= opts.Min && secret <= opts.Max
+}
+
+func (classic) Rules(opts model.GameOptions) string {
+	return fmt.Sprintf("Guess a number between %d and %d", opts.Min, opts.Max)
+}
+
+func (classic) Judge(opts model.GameOptions, secret int, guess string) (string, bool, error) {
+	n, err := strconv.Atoi(guess)
+	if err != nil {
+		return "", false, fmt.Errorf("%w, use GUESS number", ErrInvalidGuess)
+	}
+	switch {
+	case n < secret:
+		return fmt.Sprintf("%d is too low", n), false, nil
+	case n > secret:
+		return fmt.Sprintf("%d is too high", n), false, nil
+	}
+	return "", true, nil
+}
+
+func (classic) Reveal(opts model.GameOptions, secret int) string {
+	return fmt.Sprintf("The number was %d", secret)
+}
+
+// setupRange defaults to MinNumber-MaxNumber, numbers start at 1
+func setupRange(opts *model.GameOptions) error {
+	if opts.Digits != 0 {
+		return fmt.Errorf("%w: digits is for %s", ErrInvalidOptions, Mastermind)
+	}
+	if opts.Min == 0 {
+		opts.Min = MinNumber
+	}
+	if opts.Max == 0 {
+		opts.Max = MaxNumber
+	}
+	if opts.Min < 1 || opts.Max > MaxRange || opts.Min >= opts.Max {
+		return fmt.Errorf("%w: range must be min-max with 1 <= min < max <= %d", ErrInvalidOptions, MaxRange)
+	}
+	return nil
+}
+
+// hotCold says how close a guess is instead of which way to go
+type hotCold struct{ classic }
+
+func (hotCold) Name() string { return HotCold }
+
+func (hotCold) Rules(opts model.GameOptions) string {
+	return fmt.Sprintf("Guess a number between %d and %d, hints say how close you are: burning, hot, warm, cool or cold", opts.Min, opts.Max)
+}
+
+func (hotCold) Judge(opts model.GameOptions, secret int, guess string) (string, bool, error) {
+	n, err := strconv.Atoi(guess)
+	if err != nil || n < opts.Min || n > opts.Max {
+		return "", false, fmt.Errorf("%w, use GUESS with a number between %d and %d", ErrInvalidGuess, opts.Min, opts.Max)
+	}
+	if n == secret {
+		return "", true, nil
+	}
+	distance := float64(n - secret)
+	if distance < 0 {
+		distance = -distance
+	}
+	// relative to the range, so hints mean the same for 1-100 and 1-1000000
+	var hint string
+	switch share := distance / float64(opts.Max-opts.Min); {
+	case share <= 0.01:
+		hint = "burning"
+	case share <= 0.05:
+		hint = "hot"
+	case share <= 0.15:
+		hint = "warm"
+	case share <= 0.30:
+		hint = "cool"
+	default:
+		hint = "cold"
+	}
+	return fmt.Sprintf("%d is %s", n, hint), false, nil
+}
+
+// mastermind hides a code of different digits, a guess learns its bulls (right digit, right place)
+// and cows (right digit, wrong place). The code is kept as a number, leading zeros come from Digits.
+type mastermind struct{}
+
+func (mastermind) Name() string { return Mastermind }
+
+func (mastermind) Setup(opts *model.GameOptions) error {
+	if opts.Min != 0 || opts.Max != 0 {
+		return fmt.Errorf("%w: %s takes digits, not a range", ErrInvalidOptions, Mastermind)
+	}
+	if opts.Digits == 0 {
+		opts.Digits = DefaultDigits
+	}
+	if opts.Digits < MinDigits || opts.Digits > MaxDigits {
+		return fmt.Errorf("%w: digits must be %d to %d", ErrInvalidOptions, MinDigits, MaxDigits)
+	}
+	return nil
+}
+
+func (mastermind) Secret(opts model.GameOptions) (int, error) {
+	digits := []byte("0123456789")
+	// the first Digits places of a Fisher-Yates shuffle
+	for i := 0; i < opts.Digits; i++ {
+		j, err := util.GenerateRandomInt(i, len(digits)-1)
+		if err != nil {
+			return 0, err
+		}
+		digits[i], digits[j] = digits[j], digits[i]
+	}
+	return strconv.Atoi(string(digits[:opts.Digits]))
+}
+
+func (m mastermind) Valid(opts model.GameOptions, secret int) bool {
+	return secret >= 0 && distinctDigits(m.code(opts, secret), opts.Digits)
+}
+
+func (mastermind) Rules(opts model.GameOptions) string {
+	return fmt.Sprintf("Guess the %d digit code, its digits all differ and it may start with 0. "+
+		"Bulls are right digits in the right place, cows right digits in the wrong place", opts.Digits)
+}
+
+func (m mastermind) Judge(opts model.GameOptions, secret int, guess string) (string, bool, error) {
+	if !distinctDigits(guess, opts.Digits) {
+		return "", false, fmt.Errorf("%w, use GUESS with %d different digits", ErrInvalidGuess, opts.Digits)
+	}
+	code := m.code(opts, secret)
+	if guess == code {
+		return "", true, nil
+	}
+	bulls, cows := 0, 0
+	for i := 0; i < len(guess); i++ {
+		switch {
+		case guess[i] == code[i]:
+			bulls++
+		case strings.IndexByte(code, guess[i]) >= 0:
+			cows++
+		}
+	}
+	return fmt.Sprintf("%s: %d bulls, %d cows", guess, bulls, cows), false, nil
+}
+
+func (m mastermind) Reveal(opts model.GameOptions, secret int) string {
+	return "The code was " + m.code(opts, secret)
+}
+
+func (mastermind) code(opts model.GameOptions, secret int) string {
+	return fmt.Sprintf("%0*d", opts.Digits, secret)
+}
+
+// distinctDigits checks s is n digits, none twice
+func distinctDigits(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	var seen [10]bool
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' || seen[s[i]-'0'] {
+			return false
+		}
+		seen[s[i]-'0'] = true
+	}
+	return true
+}
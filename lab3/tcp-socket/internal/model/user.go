@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 // User struct - represent user in the system
 type User struct {
 	Username string 		`json:"username"`
@@ -7,6 +9,34 @@ type User struct {
 	Fullname string			`json:"fullname"`
 	Emails    []string 		`json:"emails"`
 	Addresses  []Address	`json:"addresses"`
+	Role 		string 		`json:"role,omitempty"` // empty means RoleUser
+	UploadQuota	int64		`json:"upload_quota,omitempty"` // bytes, 0 means the server default, -1 unlimited
+	APIKeys		[]APIKey	`json:"api_keys,omitempty"` // long-lived logins for scripts, see auth/token.go
+	TOTPSecret	string		`json:"totp_secret,omitempty"` // base32, set means AUTH asks for a code too, see auth/mfa.go
+	RecoveryCodes	[]string	`json:"recovery_codes,omitempty"` // SHA-256 of the unused codes, hex
+	TokenGeneration	int		`json:"token_generation,omitempty"` // access tokens of an older generation are refused, see auth/token.go
+}
+
+// MFAEnabled - the second step of the login is needed
+func (u *User) MFAEnabled() bool {
+	return u.TOTPSecret != ""
+}
+
+const (
+	RoleUser 	= "user"
+	RoleAdmin 	= "admin"
+)
+
+// HasRole - admins may do everything a normal user can
+func (u *User) HasRole(role string) bool {
+	if role == "" || u.Role == RoleAdmin {
+		return true
+	}
+	userRole := u.Role
+	if userRole == "" {
+		userRole = RoleUser
+	}
+	return userRole == role
 }
 
 type Address struct {
@@ -14,14 +44,43 @@ type Address struct {
 	Details		string 		`json:"details"`
 }
 
+// APIKey - only the hash of the key is kept, the key itself is shown once when it is created
+type APIKey struct {
+	ID			string		`json:"id"` // public part of the key, used to find it
+	Name		string		`json:"name"`
+	Hash		string		`json:"hash"` // SHA-256 of the whole key, hex
+	Created		time.Time	`json:"created"`
+}
+
 type ConnectedClient struct {
 	User 					*User
 	SessionID 				int	// unique random key
+	LoginTime 				time.Time
+	Remote 					string	// address the login came from, set by the server
 }
 
 type GameState struct {
-	Target 			int 
-	GuessCount 		int
-	InProgress		bool 
+	Target 			int 		`json:"target"`
+	GuessCount 		int 		`json:"guess_count"`
+	InProgress		bool 		`json:"in_progress"`
+	Options 		GameOptions `json:"options"`
+	Deadline 		time.Time 	`json:"deadline"`	// zero without a time limit
+}
+
+// GameOptions - what START asked for, zero values mean the variant's defaults
+type GameOptions struct {
+	Variant 		string 		`json:"variant"`	// "" is classic
+	Min 			int 		`json:"min,omitempty"`
+	Max 			int 		`json:"max,omitempty"`
+	Digits 			int 		`json:"digits,omitempty"`	// length of the code for mastermind
+	MaxGuesses 		int 		`json:"max_guesses,omitempty"`	// 0 is no limit
+	TimeLimit 		time.Duration `json:"time_limit,omitempty"`	// 0 is no limit
+}
+
+// SavedGame - a running game kept over a restart, sessions do not survive one so it belongs to the user
+type SavedGame struct {
+	Username 		string 		`json:"username"`
+	State 			GameState 	`json:"state"`
+	Saved 			time.Time 	`json:"saved"`
 }
 
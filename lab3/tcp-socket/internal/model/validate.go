@@ -0,0 +1,228 @@
+// Validation - what a User may contain. Normalize cleans up what people type, Validate says what is still wrong,
+// field by field, so a client can point at the input to fix.
+
+package model
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"unicode"
+)
+
+const (
+	MinUsernameLen = 3
+	MaxUsernameLen = 32
+	MaxFullnameLen = 100
+	MaxEmails      = 10
+	MaxAddresses   = 10
+	MaxDetailsLen  = 200
+)
+
+// Address types a user may have
+const (
+	AddressHome     = "home"
+	AddressWork     = "work"
+	AddressBilling  = "billing"
+	AddressShipping = "shipping"
+	AddressOther    = "other"
+)
+
+// AddressTypes lists the valid Address.Type values
+var AddressTypes = []string{AddressHome, AddressWork, AddressBilling, AddressShipping, AddressOther}
+
+// reservedUsernames are words AUTH takes as keywords instead of a username
+var reservedUsernames = []string{"TOKEN", "MFA"}
+
+// FieldError is one problem with one field, Field is the JSON path like "emails[1]"
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationErrors is every problem found in a user
+type ValidationErrors []FieldError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, fe := range e {
+		messages[i] = fe.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Fields maps each field to its message, the shape REST clients get
+func (e ValidationErrors) Fields() map[string]string {
+	fields := make(map[string]string, len(e))
+	for _, fe := range e {
+		fields[fe.Field] = fe.Message
+	}
+	return fields
+}
+
+func (e *ValidationErrors) add(field, format string, args ...any) {
+	*e = append(*e, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// ValidateUsername checks length, characters and the words AUTH reserves
+func ValidateUsername(username string) error {
+	switch {
+	case len(username) < MinUsernameLen || len(username) > MaxUsernameLen:
+		return fmt.Errorf("must be %d to %d characters", MinUsernameLen, MaxUsernameLen)
+	case !isAlphaNum(rune(username[0])):
+		return errors.New("must start with a letter or digit")
+	}
+	for _, r := range username {
+		if !isAlphaNum(r) && r != '.' && r != '_' && r != '-' {
+			return fmt.Errorf("may only contain letters, digits, '.', '_' and '-', not %q", r)
+		}
+	}
+	for _, reserved := range reservedUsernames {
+		if strings.EqualFold(username, reserved) {
+			return fmt.Errorf("%s is reserved", reserved)
+		}
+	}
+	return nil
+}
+
+func isAlphaNum(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// NormalizeEmail parses an RFC 5322 address, "Name <a@b>" included, and returns the bare address
+// with the domain in lower case. The local part is kept, some servers do care about its case.
+func NormalizeEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return "", errors.New("not a valid email address")
+	}
+	if _, err := mail.ParseAddress(addr.Address); err != nil {
+		return "", errors.New("quoted local parts are not supported") // they come back unquoted
+	}
+	at := strings.LastIndex(addr.Address, "@")
+	return addr.Address[:at+1] + strings.ToLower(addr.Address[at+1:]), nil
+}
+
+// Normalize trims what people type: spaces, email forms and duplicates, the case of address types
+// Invalid emails are left as they are for Validate to report
+func (u *User) Normalize() {
+	u.Username = strings.TrimSpace(u.Username)
+	u.Fullname = strings.TrimSpace(u.Fullname)
+
+	emails := make([]string, 0, len(u.Emails))
+	seen := make(map[string]bool)
+	for _, email := range u.Emails {
+		if normalized, err := NormalizeEmail(email); err == nil {
+			email = normalized
+		}
+		if key := strings.ToLower(email); !seen[key] {
+			seen[key] = true
+			emails = append(emails, email)
+		}
+	}
+	u.Emails = emails
+
+	addresses := make([]Address, len(u.Addresses))
+	for i, addr := range u.Addresses {
+		addresses[i] = Address{Type: strings.ToLower(strings.TrimSpace(addr.Type)), Details: strings.TrimSpace(addr.Details)}
+	}
+	u.Addresses = addresses
+}
+
+// Validate checks every field, a nil error or ValidationErrors. Call Normalize first.
+func (u *User) Validate() error {
+	var errs ValidationErrors
+	if err := ValidateUsername(u.Username); err != nil {
+		errs.add("username", "%v", err)
+	}
+	if u.Password == "" {
+		errs.add("password", "is required")
+	}
+	if len(u.Fullname) > MaxFullnameLen {
+		errs.add("fullname", "must be at most %d characters", MaxFullnameLen)
+	} else if hasControl(u.Fullname) {
+		errs.add("fullname", "must not contain control characters")
+	}
+	if u.Role != "" && u.Role != RoleUser && u.Role != RoleAdmin {
+		errs.add("role", "must be %s or %s", RoleUser, RoleAdmin)
+	}
+
+	if len(u.Emails) > MaxEmails {
+		errs.add("emails", "at most %d addresses", MaxEmails)
+	}
+	for i, email := range u.Emails {
+		if normalized, err := NormalizeEmail(email); err != nil {
+			errs.add(fmt.Sprintf("emails[%d]", i), "%v", err)
+		} else if normalized != email {
+			errs.add(fmt.Sprintf("emails[%d]", i), "must be a bare address like %s", normalized)
+		}
+	}
+
+	if len(u.Addresses) > MaxAddresses {
+		errs.add("addresses", "at most %d addresses", MaxAddresses)
+	}
+	for i, addr := range u.Addresses {
+		if !validAddressType(addr.Type) {
+			errs.add(fmt.Sprintf("addresses[%d].type", i), "must be one of %s", strings.Join(AddressTypes, ", "))
+		}
+		switch {
+		case addr.Details == "":
+			errs.add(fmt.Sprintf("addresses[%d].details", i), "is required")
+		case len(addr.Details) > MaxDetailsLen:
+			errs.add(fmt.Sprintf("addresses[%d].details", i), "must be at most %d characters", MaxDetailsLen)
+		case hasControl(addr.Details):
+			errs.add(fmt.Sprintf("addresses[%d].details", i), "must not contain control characters")
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validAddressType(t string) bool {
+	for _, valid := range AddressTypes {
+		if t == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// control characters would break the line protocol and the logs
+func hasControl(s string) bool {
+	return strings.IndexFunc(s, unicode.IsControl) >= 0
+}
+
+// CheckUsers normalizes and validates a list of users as loaded from storage,
+// duplicate usernames (ignoring case) are an error too instead of one silently replacing the other
+func CheckUsers(users []*User) error {
+	var problems []string
+	seen := make(map[string]int)
+	for i, user := range users {
+		if user == nil {
+			problems = append(problems, fmt.Sprintf("user %d: empty entry", i+1))
+			continue
+		}
+		user.Normalize()
+		if err := user.Validate(); err != nil {
+			problems = append(problems, fmt.Sprintf("user %d (%s): %v", i+1, user.Username, err))
+		}
+		key := strings.ToLower(user.Username)
+		if first, dup := seen[key]; dup {
+			problems = append(problems, fmt.Sprintf("user %d (%s): duplicate username, user %d has it already", i+1, user.Username, first+1))
+			continue
+		}
+		seen[key] = i
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid users: " + strings.Join(problems, ", "))
+	}
+	return nil
+}
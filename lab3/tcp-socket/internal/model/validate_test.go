@@ -0,0 +1,109 @@
+package model
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidateUsername(t *testing.T) {
+	for _, name := range []string{"bob", "user1", "a.b-c_d", strings.Repeat("x", MaxUsernameLen)} {
+		if err := ValidateUsername(name); err != nil {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+	for name, want := range map[string]string{
+		"ab":                                  "3 to 32 characters",
+		strings.Repeat("x", MaxUsernameLen+1): "3 to 32 characters",
+		"_bob":                                "start with a letter or digit",
+		"bob smith":                           "not ' '",
+		"bøb":                                 "not 'ø'",
+		"token":                               "TOKEN is reserved",
+		"Mfa":                                 "MFA is reserved",
+	} {
+		if err := ValidateUsername(name); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v, want %q", name, err, want)
+		}
+	}
+}
+
+func TestNormalizeEmail(t *testing.T) {
+	for in, want := range map[string]string{
+		"bob@example.com":             "bob@example.com",
+		"  Bob@Example.COM ":          "Bob@example.com",
+		"Bob Smith <bob@example.com>": "bob@example.com",
+	} {
+		if got, err := NormalizeEmail(in); err != nil || got != want {
+			t.Errorf("%q: got %q, %v, want %q", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "bob", "bob@", "@example.com", "bob@@example.com", "a@b.com, c@d.com", `"odd name"@example.com`} {
+		if got, err := NormalizeEmail(in); err == nil {
+			t.Errorf("%q: accepted as %q", in, got)
+		}
+	}
+}
+
+func TestNormalizeAndValidate(t *testing.T) {
+	u := &User{
+		Username:  " bob ",
+		Password:  "secret",
+		Fullname:  " Bob ",
+		Emails:    []string{"Bob <bob@EXAMPLE.com>", "bob@example.com", "BOB@example.com", "b2@example.com"},
+		Addresses: []Address{{Type: " Home ", Details: " 1 Main St "}},
+	}
+	u.Normalize()
+	if err := u.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	want := &User{
+		Username:  "bob",
+		Password:  "secret",
+		Fullname:  "Bob",
+		Emails:    []string{"bob@example.com", "b2@example.com"},
+		Addresses: []Address{{Type: AddressHome, Details: "1 Main St"}},
+	}
+	if !reflect.DeepEqual(u, want) {
+		t.Fatalf("got %+v, want %+v", u, want)
+	}
+
+	u.Emails = append(u.Emails, "not-an-email")
+	u.Addresses = append(u.Addresses, Address{Type: "castle", Details: ""})
+	u.Fullname = "Bob\nInjected"
+	u.Role = "root"
+	var errs ValidationErrors
+	if !errors.As(u.Validate(), &errs) {
+		t.Fatal("want ValidationErrors")
+	}
+	fields := errs.Fields()
+	for _, field := range []string{"fullname", "role", "emails[2]", "addresses[1].type", "addresses[1].details"} {
+		if fields[field] == "" {
+			t.Errorf("no error for %s in %v", field, fields)
+		}
+	}
+	if len(fields) != 5 {
+		t.Errorf("unexpected errors %v", fields)
+	}
+}
+
+func TestCheckUsers(t *testing.T) {
+	users := []*User{
+		{Username: "admin", Password: "x"},
+		{Username: "user1", Password: "x", Emails: []string{"User1@Example.com"}},
+	}
+	if err := CheckUsers(users); err != nil {
+		t.Fatal(err)
+	}
+	if users[1].Emails[0] != "User1@example.com" {
+		t.Errorf("emails not normalized: %v", users[1].Emails)
+	}
+
+	users = append(users, &User{Username: "Admin", Password: "x"}, &User{Username: "x", Password: "x"}, nil)
+	err := CheckUsers(users)
+	for _, want := range []string{"user 3 (Admin): duplicate username, user 1", "user 4 (x): username:", "user 5: empty entry"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("got %v, want %q", err, want)
+		}
+	}
+}
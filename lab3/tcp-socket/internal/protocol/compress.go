@@ -0,0 +1,304 @@
+// Compression - optional, negotiated once per connection in HELLO (see hello.go)
+// Both directions are self-describing, but a side only accepts the codec HELLO agreed on:
+//   - a long line is sent as "~<codec> <base64 of the compressed line>"
+//   - compressed data is announced by a "~data <codec>" line right before its header line,
+//     the bytes then come as frames (4 byte big-endian length + data) ended by an empty frame
+// Lines and transfers below the threshold are sent as before.
+
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Codec names a compression format
+type Codec string
+
+const (
+	CodecNone    Codec = "none"
+	CodecGzip    Codec = "gzip"
+	CodecDeflate Codec = "deflate"
+)
+
+// DefaultCompressThreshold is the smallest line or transfer worth compressing
+const DefaultCompressThreshold = 1024
+
+const (
+	compressedPrefix = "~"     // a compressed line starts with it, plain lines never do
+	dataMarker       = "~data" // announces a compressed transfer
+	maxFrameSize     = 1 << 20
+)
+
+var ErrUnknownCodec = errors.New("unknown compression codec")
+
+// Compressor is implemented by the transports that can compress, the TCP MessageHandler
+type Compressor interface {
+	// SetCompression starts compressing what is sent from now on, CodecNone stops it
+	SetCompression(codec Codec, threshold int)
+}
+
+// ParseCodecs reads a comma separated list like "gzip,deflate", unknown names are skipped
+func ParseCodecs(list string) []Codec {
+	var codecs []Codec
+	for _, name := range strings.Split(list, ",") {
+		codec := Codec(strings.ToLower(strings.TrimSpace(name)))
+		if codec.valid() && codec != CodecNone {
+			codecs = append(codecs, codec)
+		}
+	}
+	return codecs
+}
+
+// NegotiateCodec picks the first offered codec that is also supported, CodecNone if there is none
+func NegotiateCodec(offered, supported []Codec) Codec {
+	for _, codec := range offered {
+		for _, s := range supported {
+			if codec == s {
+				return codec
+			}
+		}
+	}
+	return CodecNone
+}
+
+func (c Codec) valid() bool {
+	return c == CodecNone || c == CodecGzip || c == CodecDeflate
+}
+
+func (c Codec) newWriter(w io.Writer) (io.WriteCloser, error) {
+	switch c {
+	case CodecGzip:
+		return gzip.NewWriter(w), nil
+	case CodecDeflate:
+		return flate.NewWriter(w, flate.DefaultCompression)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, c)
+}
+
+func (c Codec) newReader(r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case CodecGzip:
+		return gzip.NewReader(r)
+	case CodecDeflate:
+		return flate.NewReader(r), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, c)
+}
+
+// SetCompression implements Compressor
+func (mh *MessageHandler) SetCompression(codec Codec, threshold int) {
+	mh.writeMu.Lock()
+	defer mh.writeMu.Unlock()
+	if !codec.valid() {
+		codec = CodecNone
+	}
+	mh.codec = codec
+	mh.threshold = threshold
+}
+
+// agreedCodec is the codec from HELLO, "" or CodecNone if there is none
+func (mh *MessageHandler) agreedCodec() Codec {
+	mh.writeMu.Lock()
+	defer mh.writeMu.Unlock()
+	return mh.codec
+}
+
+// compressing reports whether something of size bytes should be compressed, writeMu is held
+func (mh *MessageHandler) compressing(size int64) bool {
+	return mh.codec != "" && mh.codec != CodecNone && size >= int64(mh.threshold)
+}
+
+// compressLine turns an encoded line into its compressed form, if that is shorter
+func (mh *MessageHandler) compressLine(line string) string {
+	if !mh.compressing(int64(len(line))) {
+		return line
+	}
+	var buf bytes.Buffer
+	zw, err := mh.codec.newWriter(&buf)
+	if err != nil {
+		return line
+	}
+	zw.Write([]byte(line))
+	if zw.Close() != nil {
+		return line
+	}
+	compressed := fmt.Sprintf("%s%s %s\n", compressedPrefix, mh.codec, base64.StdEncoding.EncodeToString(buf.Bytes()))
+	if len(compressed) >= len(line) {
+		return line
+	}
+	return compressed
+}
+
+// decompressLine is the reverse of compressLine, line is "~<codec> <base64>" without the newline
+// Only the agreed codec is taken, without one a compressed line is an error
+func decompressLine(line string, agreed Codec) (string, error) {
+	name, encoded, found := strings.Cut(strings.TrimPrefix(line, compressedPrefix), " ")
+	codec := Codec(name)
+	if !found || !codec.valid() || codec == CodecNone {
+		return "", fmt.Errorf("%w: unknown compressed line", ErrInvalidFormat)
+	}
+	if codec != agreed {
+		return "", fmt.Errorf("%w: %s compressed line, but no such codec was agreed", ErrInvalidFormat, codec)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", fmt.Errorf("%w: bad compressed line", ErrInvalidFormat)
+	}
+	zr, err := codec.newReader(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("%w: bad compressed line", ErrInvalidFormat)
+	}
+	defer zr.Close()
+	// the same cap as a plain line, a small compressed line must not expand into a huge one
+	plain, err := io.ReadAll(io.LimitReader(zr, MaxLineSize+1))
+	if err != nil || len(plain) > MaxLineSize {
+		return "", fmt.Errorf("%w: bad compressed line", ErrInvalidFormat)
+	}
+	return string(plain), nil
+}
+
+// frameWriter cuts a compressed stream into length-prefixed frames, end writes the empty one
+type frameWriter struct {
+	mh *MessageHandler
+}
+
+func (fw *frameWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := fw.frame(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (fw *frameWriter) frame(p []byte) error {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(p)))
+	if fw.mh.writeTimeout > 0 {
+		fw.mh.conn.SetWriteDeadline(time.Now().Add(fw.mh.writeTimeout))
+	}
+	if _, err := fw.mh.conn.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := fw.mh.conn.Write(p)
+	return err
+}
+
+func (fw *frameWriter) end() error {
+	return fw.frame(nil)
+}
+
+// frameReader reads the frames of one transfer, io.EOF at the empty frame
+type frameReader struct {
+	mh        *MessageHandler
+	remaining int // bytes left in the current frame
+	done      bool
+}
+
+func (fr *frameReader) Read(p []byte) (int, error) {
+	if fr.done {
+		return 0, io.EOF
+	}
+	if fr.remaining == 0 {
+		if fr.mh.readTimeout > 0 {
+			fr.mh.conn.SetReadDeadline(time.Now().Add(fr.mh.readTimeout))
+		}
+		var length [4]byte
+		if _, err := io.ReadFull(fr.mh.reader, length[:]); err != nil {
+			return 0, err
+		}
+		size := binary.BigEndian.Uint32(length[:])
+		if size > maxFrameSize {
+			return 0, fmt.Errorf("compressed frame of %d bytes is too big", size)
+		}
+		if size == 0 {
+			fr.done = true
+			return 0, io.EOF
+		}
+		fr.remaining = int(size)
+	}
+
+	if len(p) > fr.remaining {
+		p = p[:fr.remaining]
+	}
+	if fr.mh.readTimeout > 0 {
+		fr.mh.conn.SetReadDeadline(time.Now().Add(fr.mh.readTimeout))
+	}
+	n, err := fr.mh.reader.Read(p)
+	fr.remaining -= n
+	return n, err
+}
+
+// sendCompressedData writes the marker, the header and n bytes of r as a compressed stream, writeMu is held
+func (mh *MessageHandler) sendCompressedData(header string, r io.Reader, n int64) error {
+	if mh.writeTimeout > 0 {
+		mh.conn.SetWriteDeadline(time.Now().Add(mh.writeTimeout))
+	}
+	if _, err := io.WriteString(mh.conn, fmt.Sprintf("%s %s\n%s", dataMarker, mh.codec, header)); err != nil {
+		return err
+	}
+
+	fw := &frameWriter{mh: mh}
+	buffered := bufio.NewWriterSize(fw, dataChunkSize)
+	zw, err := mh.codec.newWriter(buffered)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(zw, r, n); err != nil {
+		return err // the peer waits for bytes that never come, the connection is lost anyway
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := buffered.Flush(); err != nil {
+		return err
+	}
+	return fw.end()
+}
+
+// readCompressedData is ReadData for a transfer announced with "~data <codec>"
+func (mh *MessageHandler) readCompressedData(codec Codec, w io.Writer, n int64) error {
+	fr := &frameReader{mh: mh}
+	zr, err := codec.newReader(fr)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	var writeErr error
+	buf := make([]byte, dataChunkSize)
+	for n > 0 {
+		chunk := buf
+		if n < int64(len(chunk)) {
+			chunk = chunk[:n]
+		}
+		read, err := io.ReadFull(zr, chunk)
+		if err != nil {
+			return err
+		}
+		if writeErr == nil {
+			_, writeErr = w.Write(chunk[:read])
+		}
+		n -= int64(read)
+	}
+
+	// the stream must end exactly here, then the empty frame follows
+	if extra, err := io.Copy(io.Discard, zr); err != nil || extra > 0 {
+		return fmt.Errorf("compressed data longer than announced")
+	}
+	if _, err := io.Copy(io.Discard, fr); err != nil {
+		return err
+	}
+	return writeErr
+}
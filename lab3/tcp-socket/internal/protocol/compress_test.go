@@ -0,0 +1,149 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// countingConn counts what is written, to see that compression saves bytes on the wire
+type countingConn struct {
+	net.Conn
+	written *atomic.Int64
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	c.written.Add(int64(len(p)))
+	return c.Conn.Write(p)
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	for _, codec := range []Codec{CodecNone, CodecGzip, CodecDeflate} {
+		t.Run(string(codec), func(t *testing.T) {
+			a, b := net.Pipe()
+			defer a.Close()
+			defer b.Close()
+
+			var written atomic.Int64
+			sender := NewMessageHandler(countingConn{a, &written})
+			sender.SetCompression(codec, 64)
+			receiver := NewMessageHandler(b)
+			receiver.SetCompression(codec, 1<<30) // agreed on, but the receiver itself sends plain
+
+			long := strings.Repeat("a.txt 123 2024-05-01T12:00:00Z | ", 100)
+			data := bytes.Repeat([]byte("some very compressible file content\n"), 5000)
+			small := []byte("tiny")
+
+			go func() {
+				sender.SendMessage(7, RespOK, "short")
+				sender.SendMessage(7, RespOK, long)
+				sender.SendData(7, CmdFile, "data", bytes.NewReader(data), int64(len(data)))
+				sender.SendData(7, CmdFile, "small", bytes.NewReader(small), int64(len(small)))
+				sender.SendMessage(7, RespBye, "done")
+			}()
+
+			expect := func(command CommandType, payload string) {
+				t.Helper()
+				msg, err := receiver.ReadMessage()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if msg.SessionID != 7 || msg.Command != command || msg.Payload != payload {
+					t.Fatalf("got %d %s %.40q, want %s %.40q", msg.SessionID, msg.Command, msg.Payload, command, payload)
+				}
+			}
+			expectData := func(want []byte) {
+				t.Helper()
+				var got bytes.Buffer
+				if err := receiver.ReadData(&got, int64(len(want))); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got.Bytes(), want) {
+					t.Fatalf("data differs, got %d bytes", got.Len())
+				}
+			}
+
+			expect(RespOK, "short")
+			expect(RespOK, long)
+			expect(CmdFile, "data")
+			expectData(data)
+			expect(CmdFile, "small")
+			expectData(small)
+			expect(RespBye, "done")
+
+			plainSize := int64(len(long) + len(data))
+			if codec == CodecNone && written.Load() < plainSize {
+				t.Fatalf("wrote %d bytes without compression, want at least %d", written.Load(), plainSize)
+			}
+			if codec != CodecNone && written.Load() > plainSize/10 {
+				t.Fatalf("wrote %d bytes with %s, want far less than %d", written.Load(), codec, plainSize)
+			}
+		})
+	}
+}
+
+func TestNegotiateCodec(t *testing.T) {
+	supported := []Codec{CodecGzip, CodecDeflate}
+	tests := []struct {
+		offered string
+		want    Codec
+	}{
+		{"gzip,deflate", CodecGzip},
+		{"zstd, Deflate", CodecDeflate},
+		{"zstd", CodecNone},
+		{"", CodecNone},
+	}
+	for _, tt := range tests {
+		if got := NegotiateCodec(ParseCodecs(tt.offered), supported); got != tt.want {
+			t.Errorf("NegotiateCodec(%q) = %s, want %s", tt.offered, got, tt.want)
+		}
+	}
+	if got := NegotiateCodec([]Codec{CodecGzip}, nil); got != CodecNone {
+		t.Errorf("a server without codecs negotiated %s", got)
+	}
+}
+
+func TestBadCompressedLine(t *testing.T) {
+	for _, line := range []string{"~gzip !!!", "~zstd AAAA", "~gzip " + strings.Repeat("A", 8)} {
+		if _, err := decompressLine(line, CodecGzip); err == nil {
+			t.Errorf("decompressLine(%q) succeeded", line)
+		}
+	}
+
+	// under MaxLineSize on the wire, far above it once expanded
+	sender := &MessageHandler{codec: CodecGzip}
+	bomb := sender.compressLine("1_FIND " + strings.Repeat("x", 2*MaxLineSize) + "\n")
+	if len(bomb) >= MaxLineSize {
+		t.Fatalf("compressed line is %d bytes", len(bomb))
+	}
+	if _, err := decompressLine(strings.TrimRight(bomb, "\n"), CodecGzip); !errors.Is(err, ErrInvalidFormat) {
+		t.Fatalf("line expanding beyond MaxLineSize: got %v, want ErrInvalidFormat", err)
+	}
+}
+
+func TestCompressedWithoutAgreedCodec(t *testing.T) {
+	for _, agreed := range []Codec{"", CodecNone, CodecDeflate} {
+		a, b := net.Pipe()
+		sender := NewMessageHandler(a)
+		sender.SetCompression(CodecGzip, 64)
+		receiver := NewMessageHandler(b)
+		receiver.SetCompression(agreed, 64)
+
+		data := bytes.Repeat([]byte("compressible "), 100)
+		go func() {
+			sender.SendMessage(1, RespOK, strings.Repeat("long line ", 100))
+			sender.SendData(1, CmdFile, "data", bytes.NewReader(data), int64(len(data)))
+		}()
+		if _, err := receiver.ReadMessage(); !errors.Is(err, ErrInvalidFormat) {
+			t.Errorf("compressed line with %q agreed: got %v, want ErrInvalidFormat", agreed, err)
+		}
+		if _, err := receiver.ReadMessage(); !errors.Is(err, ErrInvalidFormat) {
+			t.Errorf("compressed data with %q agreed: got %v, want ErrInvalidFormat", agreed, err)
+		}
+		a.Close()
+		b.Close()
+	}
+}
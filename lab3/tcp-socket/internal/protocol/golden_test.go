@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the .golden files from the current parser")
+
+// TestGoldenTranscripts parses every line of testdata/transcripts/*.wire and compares
+// the decoded message and its re-encoding with the matching .golden file
+func TestGoldenTranscripts(t *testing.T) {
+	wireFiles, err := filepath.Glob(filepath.Join("testdata", "transcripts", "*.wire"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(wireFiles) == 0 {
+		t.Fatal("no transcripts found")
+	}
+
+	for _, wireFile := range wireFiles {
+		name := strings.TrimSuffix(filepath.Base(wireFile), ".wire")
+		t.Run(name, func(t *testing.T) {
+			got := decodeTranscript(t, wireFile)
+
+			goldenFile := strings.TrimSuffix(wireFile, ".wire") + ".golden"
+			if *update {
+				if err := os.WriteFile(goldenFile, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s differs from %s, run go test -update if the change is intended\ngot:\n%s", wireFile, goldenFile, got)
+			}
+		})
+	}
+}
+
+// decodeTranscript renders one result line per wire line
+func decodeTranscript(t *testing.T, wireFile string) []byte {
+	t.Helper()
+
+	file, err := os.Open(wireFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var out bytes.Buffer
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if line == "" {
+			break
+		}
+
+		fmt.Fprintf(&out, "wire    %q\n", line)
+		msg, parseErr := ParseMessage(line)
+		if parseErr != nil {
+			fmt.Fprintf(&out, "error   %v\n\n", parseErr)
+		} else {
+			encoded, encodeErr := EncodeMessage(msg.SessionID, msg.Command, msg.Payload)
+			fmt.Fprintf(&out, "message session=%d command=%q payload=%q\n", msg.SessionID, msg.Command, msg.Payload)
+			if encodeErr != nil {
+				fmt.Fprintf(&out, "encode  error %v\n\n", encodeErr)
+			} else {
+				fmt.Fprintf(&out, "encode  %q\n\n", encoded)
+			}
+		}
+
+		if err != nil {
+			break
+		}
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,126 @@
+// Handshake - the server opens every connection with "0_HELLO <version> [feature ...]",
+// the client answers with its own HELLO and gets "OK HELLO <version> [feature ...]" with what both support.
+// Features unknown to a side are ignored, so either side can add some without breaking the other.
+// A client that never says HELLO gets version 1 with binary transfers only, like before the handshake.
+
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Protocol versions this code speaks, a peer outside the range is rejected
+const (
+	ProtocolVersion    = 1
+	MinProtocolVersion = 1
+)
+
+// Feature names as they appear in HELLO
+const (
+	FeatureBinary    = "binary"   // raw bytes after FILE and DATA headers
+	FeatureCompress  = "compress" // "compress=gzip,deflate", codecs in order of preference, see compress.go
+	FeatureRequestID = "reqid"    // "#<id> " before a payload is echoed before the payload of the answers
+	FeatureStartTLS  = "starttls" // the connection can be upgraded to TLS
+)
+
+var ErrUnsupportedVersion = errors.New("Unsupported protocol version")
+
+// Hello is what one side of a connection speaks
+type Hello struct {
+	Version    int
+	Binary     bool
+	Codecs     []Codec // empty = no compression
+	RequestIDs bool
+	StartTLS   bool
+}
+
+// LegacyHello is what a client that skipped the handshake gets
+func LegacyHello() Hello {
+	return Hello{Version: 1, Binary: true}
+}
+
+// ParseHello reads a HELLO payload, unknown features are skipped
+func ParseHello(payload string) (Hello, error) {
+	fields := strings.Fields(payload)
+	if len(fields) == 0 {
+		return Hello{}, fmt.Errorf("%w: HELLO needs a version", ErrInvalidFormat)
+	}
+	version, err := strconv.Atoi(fields[0])
+	if err != nil || version < 1 {
+		return Hello{}, fmt.Errorf("%w: bad protocol version %q", ErrInvalidFormat, fields[0])
+	}
+
+	hello := Hello{Version: version}
+	for _, field := range fields[1:] {
+		name, value, _ := strings.Cut(field, "=")
+		switch strings.ToLower(name) {
+		case FeatureBinary:
+			hello.Binary = true
+		case FeatureCompress:
+			hello.Codecs = ParseCodecs(value)
+		case FeatureRequestID:
+			hello.RequestIDs = true
+		case FeatureStartTLS:
+			hello.StartTLS = true
+		}
+	}
+	return hello, nil
+}
+
+// String is the HELLO payload, e.g. "1 binary compress=gzip,deflate reqid"
+func (h Hello) String() string {
+	fields := []string{strconv.Itoa(h.Version)}
+	if h.Binary {
+		fields = append(fields, FeatureBinary)
+	}
+	if len(h.Codecs) > 0 {
+		names := make([]string, len(h.Codecs))
+		for i, codec := range h.Codecs {
+			names[i] = string(codec)
+		}
+		fields = append(fields, FeatureCompress+"="+strings.Join(names, ","))
+	}
+	if h.RequestIDs {
+		fields = append(fields, FeatureRequestID)
+	}
+	if h.StartTLS {
+		fields = append(fields, FeatureStartTLS)
+	}
+	return strings.Join(fields, " ")
+}
+
+// CheckVersion tells whether we can talk to a peer of the given version
+func CheckVersion(version int) error {
+	if version >= MinProtocolVersion && version <= ProtocolVersion {
+		return nil
+	}
+	if MinProtocolVersion == ProtocolVersion {
+		return fmt.Errorf("%w %d, supported is version %d", ErrUnsupportedVersion, version, ProtocolVersion)
+	}
+	return fmt.Errorf("%w %d, supported are versions %d to %d", ErrUnsupportedVersion, version, MinProtocolVersion, ProtocolVersion)
+}
+
+// Negotiate keeps the features both sides have, with the peer's version and its preferred codec
+func (h Hello) Negotiate(peer Hello) Hello {
+	agreed := Hello{
+		Version:    peer.Version,
+		Binary:     h.Binary && peer.Binary,
+		RequestIDs: h.RequestIDs && peer.RequestIDs,
+		StartTLS:   h.StartTLS && peer.StartTLS,
+	}
+	if codec := NegotiateCodec(peer.Codecs, h.Codecs); codec != CodecNone {
+		agreed.Codecs = []Codec{codec}
+	}
+	return agreed
+}
+
+// Codec is the compression in use after Negotiate
+func (h Hello) Codec() Codec {
+	if len(h.Codecs) == 0 {
+		return CodecNone
+	}
+	return h.Codecs[0]
+}
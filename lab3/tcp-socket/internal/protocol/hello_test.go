@@ -0,0 +1,63 @@
+package protocol
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseHello(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    Hello
+	}{
+		{"1", Hello{Version: 1}},
+		{"1 binary compress=gzip,deflate reqid starttls", Hello{Version: 1, Binary: true, Codecs: []Codec{CodecGzip, CodecDeflate}, RequestIDs: true, StartTLS: true}},
+		{"2 BINARY frames=v2 compress=zstd", Hello{Version: 2, Binary: true}}, // unknown features and codecs are skipped
+	}
+	for _, tt := range tests {
+		got, err := ParseHello(tt.payload)
+		if err != nil {
+			t.Errorf("ParseHello(%q): %v", tt.payload, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseHello(%q) = %+v, want %+v", tt.payload, got, tt.want)
+		}
+	}
+
+	for _, payload := range []string{"", "binary", "0 binary", "-1"} {
+		if _, err := ParseHello(payload); !errors.Is(err, ErrInvalidFormat) {
+			t.Errorf("ParseHello(%q) = %v, want ErrInvalidFormat", payload, err)
+		}
+	}
+
+	full := Hello{Version: 1, Binary: true, Codecs: []Codec{CodecDeflate}, RequestIDs: true, StartTLS: true}
+	if got, _ := ParseHello(full.String()); !reflect.DeepEqual(got, full) {
+		t.Errorf("round trip of %q gave %+v", full.String(), got)
+	}
+}
+
+func TestNegotiateHello(t *testing.T) {
+	server := Hello{Version: ProtocolVersion, Binary: true, Codecs: []Codec{CodecGzip, CodecDeflate}, RequestIDs: true}
+	client := Hello{Version: ProtocolVersion, Binary: true, Codecs: []Codec{CodecDeflate, CodecGzip}, StartTLS: true}
+
+	agreed := server.Negotiate(client)
+	want := Hello{Version: ProtocolVersion, Binary: true, Codecs: []Codec{CodecDeflate}}
+	if !reflect.DeepEqual(agreed, want) {
+		t.Fatalf("Negotiate = %+v, want %+v", agreed, want)
+	}
+	if agreed.String() != "1 binary compress=deflate" || agreed.Codec() != CodecDeflate {
+		t.Fatalf("agreed on %q", agreed.String())
+	}
+	if codec := server.Negotiate(Hello{Version: 1}).Codec(); codec != CodecNone {
+		t.Fatalf("a client without codecs got %s", codec)
+	}
+
+	if err := CheckVersion(ProtocolVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckVersion(ProtocolVersion + 1); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("CheckVersion(%d) = %v", ProtocolVersion+1, err)
+	}
+}
@@ -16,6 +16,9 @@ import (
 	"errors"
 	"strconv"
 	"bufio"
+	"io"
+	"sync"
+	"time"
 )
 
 type CommandType string // Define type of command - just a field/attribute in string 
@@ -27,8 +30,46 @@ const (
 	CmdQuit 		CommandType = "QUIT" // quit the connection
 	CmdStartGame 	CommandType = "START"
 	CmdEndGame 		CommandType = "END"
+	CmdPing 		CommandType = "PING" // heartbeat - the other side must answer with PONG
+	CmdPong 		CommandType = "PONG"
+	CmdUpload 		CommandType = "UPLOAD" // announce a file, the server answers with the offset to resume from
+	CmdData 		CommandType = "DATA" // one chunk of an upload, raw bytes follow the line
+	CmdList 		CommandType = "LIST" // the user's uploaded files
+	CmdLs 			CommandType = "LS" // browse the served files
+	CmdStat 		CommandType = "STAT"
+	CmdFind 		CommandType = "FIND"
+	CmdHello 		CommandType = "HELLO" // version and features, see hello.go
+	CmdStartTLS 	CommandType = "STARTTLS" // upgrade to TLS before AUTH, see tls.go
+	CmdAPIKey 		CommandType = "APIKEY" // create, list and revoke the keys for AUTH TOKEN
+	CmdMFA 			CommandType = "MFA" // TOTP enrollment, the code itself goes in AUTH MFA
+	CmdSessions 	CommandType = "SESSIONS" // the user's logins, where from and since when
+	CmdRevoke 		CommandType = "REVOKE" // end another session, its connection is closed
 )
 
+// Commands the server uses to answer
+const (
+	RespOK 			CommandType = "OK"
+	RespError 		CommandType = "ERROR"
+	RespBye 		CommandType = "BYE"
+	RespServer 		CommandType = "SERVER" // unsolicited notice from the server
+)
+
+// Errors from ParseMessage and EncodeMessage, the connection itself is still fine after them
+var (
+	ErrInvalidFormat 	= errors.New("Format message is not valid")
+	ErrInvalidCommand 	= errors.New("Command must be a single word")
+	ErrInvalidPayload 	= errors.New("Payload must not contain line breaks")
+)
+
+// ErrLineTooLong - the peer sent more than MaxLineSize without a line break, the connection should be closed
+var ErrLineTooLong = fmt.Errorf("Line is longer than %d bytes", MaxLineSize)
+
+// MaxLineSize is the longest line ReadMessage takes, line break included
+const MaxLineSize = 64 * 1024
+
+// ErrUnknownCommand prefixes the ERROR payload for commands the server does not handle
+const ErrUnknownCommand = "UNKNOWN_COMMAND"
+
 // Define format of message - a wrapper
 type Message struct {
 	SessionID 		int 
@@ -36,76 +77,221 @@ type Message struct {
 	Payload 		string // of data wanna send
 }
 
+// MessageConn is what the server and client need from a transport
+// MessageHandler is the TCP one, internal/rudp has the UDP one
+type MessageConn interface {
+	ReadMessage() (*Message, error)
+	SendMessage(sessionID int, command CommandType, payload string) error
+	ReadData(w io.Writer, n int64) error
+	SendData(sessionID int, command CommandType, payload string, r io.Reader, n int64) error
+	SetReadTimeout(d time.Duration)
+	SetWriteTimeout(d time.Duration)
+}
+
 // Method to send and receive message
 type MessageHandler struct {
-	conn net.Conn
+	conn 			net.Conn
+	reader 			*bufio.Reader 	// keep one reader per connection, otherwise buffered bytes get lost between reads
+	pending 		[]byte 			// part of a line read before a deadline hit
+	readTimeout 	time.Duration 	// 0 = no deadline
+	writeTimeout 	time.Duration
+	writeMu 		sync.Mutex 		// heartbeat goroutines write on the same conn
+	codec 			Codec 			// compression for what we send, see compress.go
+	threshold 		int
+	dataCodec 		Codec 			// set by a "~data" line, the next ReadData decompresses
+	tls 			bool 			// upgraded with STARTTLS
 }
 
 // Create a new MessageHandler to new MessageHandler
 func NewMessageHandler(conn net.Conn) *MessageHandler {
 	return &MessageHandler {
 		conn: conn,
+		reader: bufio.NewReader(conn),
 	}
 }
 
+// SetReadTimeout sets how long ReadMessage waits for a full line before giving up
+func (mh *MessageHandler) SetReadTimeout(d time.Duration) {
+	mh.readTimeout = d
+}
+
+// SetWriteTimeout sets how long SendMessage may block on a slow peer
+func (mh *MessageHandler) SetWriteTimeout(d time.Duration) {
+	mh.writeTimeout = d
+}
+
+// IsTimeout reports whether err comes from a read/write deadline
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 // This is a func having receiver in Go, specific is struct MessageHandler
 // It is use to send message through TCP connection
 // (mh *MessageHandler is the receiver of func) => It means func SendMessage is belongs to MessageHandler
 // mh is the presentation variable for object MessageHandler
 // *MessageHandler is the pointer helping func can be able to change data in struct if needed.
 func (mh *MessageHandler) SendMessage(sessionID int, command CommandType, payload string) error {
-	message := ""
-
-	if command == CmdAuth {
-		message = fmt.Sprintf("%s %s\n", command, payload)
-	} else {
-		message = fmt.Sprintf("%d_%s %s\n", sessionID, command, payload)
+	message, err := EncodeMessage(sessionID, command, payload)
+	if err != nil {
+		return err
 	}
 
-	_, err := mh.conn.Write([]byte(message))
+	mh.writeMu.Lock()
+	defer mh.writeMu.Unlock()
+
+	message = mh.compressLine(message)
+	if mh.writeTimeout > 0 {
+		mh.conn.SetWriteDeadline(time.Now().Add(mh.writeTimeout))
+	}
+	_, err = mh.conn.Write([]byte(message))
 	return err
 }
 
-func (mh *MessageHandler) ReadMessage() (*Message, error) {
-	// read message from server
-	reader := bufio.NewReader(mh.conn)
-	line, err := reader.ReadString('\n')
+// chunk size for raw data, deadlines are renewed per chunk so big files do not time out
+const dataChunkSize = 32 * 1024
+
+// SendData sends a message line followed by exactly n raw bytes from r (file transfers)
+// Header and data are written under one lock so a heartbeat cannot land in the middle
+func (mh *MessageHandler) SendData(sessionID int, command CommandType, payload string, r io.Reader, n int64) error {
+	header, err := EncodeMessage(sessionID, command, payload)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	line = strings.TrimSpace(line)
+	mh.writeMu.Lock()
+	defer mh.writeMu.Unlock()
 
-	var sessionID int
-	var commandStr string
-	var payload string
+	if mh.compressing(n) {
+		return mh.sendCompressedData(header, r, n)
+	}
+	if mh.writeTimeout > 0 {
+		mh.conn.SetWriteDeadline(time.Now().Add(mh.writeTimeout))
+	}
+	if _, err := io.WriteString(mh.conn, header); err != nil {
+		return err
+	}
 
-	// check if having the cmdAuth or not ?
-	if strings.HasPrefix(line, string(CmdAuth)) {
-		parts := strings.SplitN(line, " ", 2)
-		if len(parts) < 2 {
-			return nil, errors.New("Format message is not valid")
+	buf := make([]byte, dataChunkSize)
+	for n > 0 {
+		chunk := buf
+		if n < int64(len(chunk)) {
+			chunk = chunk[:n]
 		}
-		commandStr = parts[0]
-		payload = parts[1]
-	} else {
-		// Separate session ID and cmd
-		parts := strings.SplitN(line, "_", 2)
-		if len(parts) < 2 {
-			return nil, errors.New("Format message is not valid")
+		read, err := io.ReadFull(r, chunk)
+		if err != nil {
+			return err
+		}
+		if mh.writeTimeout > 0 {
+			mh.conn.SetWriteDeadline(time.Now().Add(mh.writeTimeout))
+		}
+		if _, err := mh.conn.Write(chunk[:read]); err != nil {
+			return err
+		}
+		n -= int64(read)
+	}
+	return nil
+}
+
+// ReadData copies the n raw bytes that follow a message sent with SendData into w
+// If w fails the rest is still read, otherwise the stream would be out of sync
+func (mh *MessageHandler) ReadData(w io.Writer, n int64) error {
+	if codec := mh.dataCodec; codec != "" {
+		mh.dataCodec = ""
+		return mh.readCompressedData(codec, w, n)
+	}
+	var writeErr error
+	buf := make([]byte, dataChunkSize)
+	for n > 0 {
+		chunk := buf
+		if n < int64(len(chunk)) {
+			chunk = chunk[:n]
+		}
+		if mh.readTimeout > 0 {
+			mh.conn.SetReadDeadline(time.Now().Add(mh.readTimeout))
 		}
-		var err error
-		sessionID, err = strconv.Atoi(parts[0]) // convert string into integer - Atoi
+		read, err := io.ReadFull(mh.reader, chunk)
 		if err != nil {
-			return nil, errors.New("Session ID is not valid")
+			return err
+		}
+		if writeErr == nil {
+			_, writeErr = w.Write(chunk[:read])
 		}
+		n -= int64(read)
+	}
+	return writeErr
+}
 
-		cmdParts := strings.SplitN(parts[1], " ", 2)
-		if len(cmdParts) < 2 {
-			return nil, errors.New("Format message is not valid")
+func (mh *MessageHandler) ReadMessage() (*Message, error) {
+	// read message from server
+	if mh.readTimeout > 0 {
+		mh.conn.SetReadDeadline(time.Now().Add(mh.readTimeout))
+	}
+	// ReadSlice instead of ReadString, a line without an end must not grow forever
+	for {
+		chunk, err := mh.reader.ReadSlice('\n')
+		mh.pending = append(mh.pending, chunk...)
+		if len(mh.pending) > MaxLineSize {
+			mh.pending = nil
+			return nil, ErrLineTooLong
 		}
-		commandStr = cmdParts[0]
-		payload = cmdParts[1]
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			// keep what we got so far, the caller may retry after a timeout
+			return nil, err
+		}
+		break
+	}
+	line := string(mh.pending)
+	mh.pending = nil
+
+	// compressed data follows the next message, the marker itself is no message
+	if strings.HasPrefix(line, dataMarker+" ") {
+		if mh.dataCodec != "" {
+			mh.dataCodec = ""
+			return nil, fmt.Errorf("%w: data marker without data", ErrInvalidFormat)
+		}
+		codec := Codec(strings.TrimSpace(strings.TrimPrefix(line, dataMarker)))
+		if codec == CodecNone || codec != mh.agreedCodec() {
+			return nil, fmt.Errorf("%w: %s compressed data, but no such codec was agreed", ErrInvalidFormat, codec)
+		}
+		mh.dataCodec = codec
+		return mh.ReadMessage()
+	}
+	if strings.HasPrefix(line, compressedPrefix) {
+		plain, err := decompressLine(strings.TrimRight(line, "\r\n"), mh.agreedCodec())
+		if err != nil {
+			return nil, err
+		}
+		line = plain
+	}
+
+	return ParseMessage(line)
+}
+
+// ParseMessage decodes one line: "AUTH <payload>" or "<sessionID>_<COMMAND>[ <payload>]"
+// The payload is optional and kept as is, only the line ending is removed
+func ParseMessage(line string) (*Message, error) {
+	line = strings.TrimRight(line, "\r\n")
+
+	// the first space ends the command, the rest is payload
+	head, payload, _ := strings.Cut(line, " ")
+
+	// check if having the cmdAuth or not ? - AUTH has no session yet
+	if head == string(CmdAuth) {
+		return &Message{Command: CmdAuth, Payload: payload}, nil
+	}
+
+	// Separate session ID and cmd
+	sessionStr, commandStr, found := strings.Cut(head, "_")
+	if !found || commandStr == "" {
+		return nil, ErrInvalidFormat
+	}
+	sessionID, err := strconv.Atoi(sessionStr) // convert string into integer - Atoi
+	if err != nil {
+		return nil, fmt.Errorf("%w: Session ID is not valid", ErrInvalidFormat)
 	}
 
 	// init object Message
@@ -118,3 +304,23 @@ func (mh *MessageHandler) ReadMessage() (*Message, error) {
 	return message, nil
 }
 
+// EncodeMessage is the inverse of ParseMessage, AUTH lines never carry the session ID
+func EncodeMessage(sessionID int, command CommandType, payload string) (string, error) {
+	if command == "" || strings.ContainsAny(string(command), " \r\n") {
+		return "", ErrInvalidCommand
+	}
+	if strings.ContainsAny(payload, "\r\n") {
+		return "", ErrInvalidPayload // would end the line early
+	}
+
+	message := ""
+	if command == CmdAuth {
+		message = string(command)
+	} else {
+		message = fmt.Sprintf("%d_%s", sessionID, command)
+	}
+	if payload != "" {
+		message += " " + payload
+	}
+	return message + "\n", nil
+}
@@ -0,0 +1,28 @@
+package protocol
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadMessageLineLimit(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	receiver := NewMessageHandler(b)
+
+	// just under the limit is fine, reading it takes more than one buffer
+	payload := strings.Repeat("x", MaxLineSize-len("1_FIND \n"))
+	go a.Write([]byte("1_FIND " + payload + "\n"))
+	if msg, err := receiver.ReadMessage(); err != nil || msg.Payload != payload {
+		t.Fatalf("line at the limit: %v", err)
+	}
+
+	// no line break at all, the writer only stops when the pipe closes
+	go a.Write([]byte(strings.Repeat("x", 2*MaxLineSize)))
+	if _, err := receiver.ReadMessage(); !errors.Is(err, ErrLineTooLong) {
+		t.Fatalf("got %v, want ErrLineTooLong", err)
+	}
+}
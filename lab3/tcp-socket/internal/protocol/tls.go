@@ -0,0 +1,69 @@
+// STARTTLS - a plain TCP connection is upgraded in place:
+// the client sends STARTTLS, waits for "OK STARTTLS", then both sides run the TLS handshake on the same socket.
+// Everything before the upgrade is forgotten, the server greets with HELLO again and features are agreed anew.
+
+package protocol
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"time"
+)
+
+// handshakeTimeout bounds the TLS handshake when the connection has no timeouts of its own
+const handshakeTimeout = 10 * time.Second
+
+// ErrPlaintextAfterStartTLS means the peer sent more before the handshake, it could have been injected
+var ErrPlaintextAfterStartTLS = errors.New("plaintext data received after STARTTLS")
+
+// TLSUpgrader is implemented by the transports that can switch to TLS, the TCP MessageHandler
+type TLSUpgrader interface {
+	// StartTLS runs the handshake as server or client, afterwards everything goes through TLS
+	StartTLS(config *tls.Config, server bool) error
+	// TLS reports whether the connection was upgraded
+	TLS() bool
+}
+
+// StartTLS implements TLSUpgrader, it must not run while another goroutine reads
+func (mh *MessageHandler) StartTLS(config *tls.Config, server bool) error {
+	mh.writeMu.Lock()
+	defer mh.writeMu.Unlock()
+
+	if mh.tls {
+		return errors.New("connection already uses TLS")
+	}
+	if mh.reader.Buffered() > 0 || len(mh.pending) > 0 {
+		return ErrPlaintextAfterStartTLS
+	}
+
+	var tlsConn *tls.Conn
+	if server {
+		tlsConn = tls.Server(mh.conn, config)
+	} else {
+		tlsConn = tls.Client(mh.conn, config)
+	}
+	timeout := max(mh.readTimeout, mh.writeTimeout)
+	if timeout <= 0 {
+		timeout = handshakeTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+
+	mh.conn = tlsConn
+	mh.reader = bufio.NewReader(tlsConn)
+	mh.tls = true
+	mh.codec, mh.dataCodec = "", "" // compression is agreed again in the new HELLO
+	return nil
+}
+
+// TLS implements TLSUpgrader
+func (mh *MessageHandler) TLS() bool {
+	mh.writeMu.Lock()
+	defer mh.writeMu.Unlock()
+	return mh.tls
+}
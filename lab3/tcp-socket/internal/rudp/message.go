@@ -0,0 +1,139 @@
+package rudp
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"socket-tcp/internal/protocol"
+)
+
+// First byte of every body: a protocol line or a piece of raw data after SendData
+const (
+	kindMessage = 'M'
+	kindData    = 'D'
+)
+
+// raw data is cut so a datagram stays below a common MTU
+const dataChunkSize = 1024
+
+// MessageConn speaks the line protocol over a Conn, it implements protocol.MessageConn
+type MessageConn struct {
+	conn        *Conn
+	readTimeout time.Duration
+	pending     []*protocol.Message // heartbeats that arrived in the middle of ReadData
+	sendMu      sync.Mutex          // keeps a SendData header and its chunks together
+}
+
+func NewMessageConn(conn *Conn) *MessageConn {
+	return &MessageConn{conn: conn}
+}
+
+// reliable tells which commands need retransmission, a lost heartbeat is just a missed heartbeat
+func reliable(command protocol.CommandType) bool {
+	return command != protocol.CmdPing && command != protocol.CmdPong
+}
+
+func (mc *MessageConn) SetReadTimeout(d time.Duration)  { mc.readTimeout = d }
+func (mc *MessageConn) SetWriteTimeout(d time.Duration) { mc.conn.SetWriteTimeout(d) }
+
+func (mc *MessageConn) Conn() *Conn  { return mc.conn }
+func (mc *MessageConn) Close() error { return mc.conn.Close() }
+
+func (mc *MessageConn) SendMessage(sessionID int, command protocol.CommandType, payload string) error {
+	line, err := protocol.EncodeMessage(sessionID, command, payload)
+	if err != nil {
+		return err
+	}
+	body := append([]byte{kindMessage}, line...)
+
+	if !reliable(command) {
+		return mc.conn.SendUnreliable(body)
+	}
+	mc.sendMu.Lock()
+	defer mc.sendMu.Unlock()
+	return mc.conn.Send(body)
+}
+
+// SendData sends the header line and then n bytes from r in reliable chunks
+func (mc *MessageConn) SendData(sessionID int, command protocol.CommandType, payload string, r io.Reader, n int64) error {
+	line, err := protocol.EncodeMessage(sessionID, command, payload)
+	if err != nil {
+		return err
+	}
+
+	mc.sendMu.Lock()
+	defer mc.sendMu.Unlock()
+
+	if err := mc.conn.Send(append([]byte{kindMessage}, line...)); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 1+dataChunkSize)
+	buf[0] = kindData
+	for n > 0 {
+		chunk := buf[1:]
+		if n < int64(len(chunk)) {
+			chunk = chunk[:n]
+		}
+		read, err := io.ReadFull(r, chunk)
+		if err != nil {
+			return err
+		}
+		if err := mc.conn.Send(buf[:1+read]); err != nil {
+			return err
+		}
+		n -= int64(read)
+	}
+	return nil
+}
+
+func (mc *MessageConn) ReadMessage() (*protocol.Message, error) {
+	if len(mc.pending) > 0 {
+		msg := mc.pending[0]
+		mc.pending = mc.pending[1:]
+		return msg, nil
+	}
+
+	for {
+		body, _, err := mc.conn.Recv(mc.readTimeout)
+		if err != nil {
+			return nil, err
+		}
+		if body[0] != kindMessage {
+			continue // data nobody asked for, the sizes did not match
+		}
+		return protocol.ParseMessage(string(body[1:]))
+	}
+}
+
+// ReadData copies the n bytes that follow a SendData header into w
+// If w fails the rest is still read, like the TCP version
+func (mc *MessageConn) ReadData(w io.Writer, n int64) error {
+	var writeErr error
+	for n > 0 {
+		body, _, err := mc.conn.Recv(mc.readTimeout)
+		if err != nil {
+			return err
+		}
+
+		switch body[0] {
+		case kindMessage:
+			// a heartbeat overtook the data, answer it after the transfer
+			if msg, err := protocol.ParseMessage(string(body[1:])); err == nil {
+				mc.pending = append(mc.pending, msg)
+			}
+		case kindData:
+			data := body[1:]
+			if int64(len(data)) > n {
+				return errors.New("rudp: more data than announced")
+			}
+			if writeErr == nil {
+				_, writeErr = w.Write(data)
+			}
+			n -= int64(len(data))
+		}
+	}
+	return writeErr
+}
@@ -0,0 +1,479 @@
+/* rudp - a small reliability layer on top of UDP, so the same protocol commands can run over datagrams.
+Reliable datagrams carry a sequence number and are sent again until the peer acknowledges them,
+the others (heartbeats) are fire and forget. Only one reliable datagram is in flight per direction
+(stop-and-wait), so the receiver only ever waits for the next sequence number and delivers in order.
+
+Wire format, one per datagram:
+	R <seq> <body>	reliable, the peer answers A <seq>
+	U <body>		unreliable, no answer
+	A <seq>			acknowledgement
+
+An empty reliable datagram with seq 1 opens the association, Dial sends it so the server greets right away.
+*/
+
+package rudp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Errors returned by Send and Recv
+var (
+	ErrClosed          = errors.New("rudp: connection closed")
+	ErrPeerUnreachable = errors.New("rudp: peer did not acknowledge")
+)
+
+// biggest datagram we read, bodies are kept far below it by the message layer
+const maxDatagram = 64 * 1024
+
+// the wait for an ACK doubles on every resend up to this
+const maxBackoff = 2 * time.Second
+
+// a closed association still answers resends this long, in case our last ACK got lost
+const linger = 2 * maxBackoff
+
+// Config tunes the reliability layer, both sides may use different values
+type Config struct {
+	RetransmitTimeout time.Duration // first wait for an ACK before sending again
+	MaxRetries        int           // resends before the peer is considered gone
+	Loss              float64       // probability to drop an outgoing datagram, simulates a bad network
+}
+
+func DefaultConfig() Config {
+	return Config{
+		RetransmitTimeout: 200 * time.Millisecond,
+		MaxRetries:        8,
+	}
+}
+
+// Stats counts datagrams, handy to compare runs with different -loss values
+type Stats struct {
+	Sent        int64 // datagrams written, retransmissions and ACKs included
+	Retransmits int64
+	Received    int64 // reliable and unreliable datagrams with a body
+	Duplicates  int64 // reliable datagrams received again because our ACK got lost
+	Dropped     int64 // outgoing datagrams thrown away by the simulated loss
+}
+
+// timeoutError is what Recv returns when nothing arrived in time, protocol.IsTimeout understands it
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "rudp: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+type packet struct {
+	body     []byte
+	reliable bool
+}
+
+// Conn is one association with a peer, created by Dial or accepted by a Listener
+type Conn struct {
+	pc      net.PacketConn
+	remote  net.Addr
+	cfg     Config
+	owned   bool   // Dial'ed conns have their own socket and close it
+	onClose func() // Listener bookkeeping
+
+	sendMu       sync.Mutex // one reliable datagram in flight
+	nextSeq      uint64
+	acks         chan uint64
+	writeTimeout atomic.Int64 // time.Duration, 0 = only MaxRetries bounds Send
+
+	expected uint64 // next reliable seq to deliver, only the receive goroutine touches it
+	inbox    chan packet
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	sent, retransmits, received, duplicates, dropped atomic.Int64
+}
+
+func newConn(pc net.PacketConn, remote net.Addr, cfg Config) *Conn {
+	return &Conn{
+		pc:       pc,
+		remote:   remote,
+		cfg:      cfg,
+		nextSeq:  1,
+		expected: 1,
+		acks:     make(chan uint64, 16),
+		inbox:    make(chan packet, 256),
+		closed:   make(chan struct{}),
+	}
+}
+
+// Dial opens an association with a server and waits until it answers
+func Dial(addr string, cfg Config) (*Conn, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := newConn(pc, raddr, cfg)
+	c.owned = true
+	go func() {
+		buf := make([]byte, maxDatagram)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				c.Close()
+				return
+			}
+			if from.String() != raddr.String() {
+				continue // not our server
+			}
+			c.handle(buf[:n])
+		}
+	}()
+
+	if err := c.Send(nil); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("rudp: %s does not answer: %w", addr, err)
+	}
+	return c, nil
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.pc.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+// SetWriteTimeout bounds how long Send keeps retrying, on top of MaxRetries
+func (c *Conn) SetWriteTimeout(d time.Duration) {
+	c.writeTimeout.Store(int64(d))
+}
+
+func (c *Conn) Stats() Stats {
+	return Stats{
+		Sent:        c.sent.Load(),
+		Retransmits: c.retransmits.Load(),
+		Received:    c.received.Load(),
+		Duplicates:  c.duplicates.Load(),
+		Dropped:     c.dropped.Load(),
+	}
+}
+
+// Send delivers body reliably and in order, it returns once the peer acknowledged it
+func (c *Conn) Send(body []byte) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	select {
+	case <-c.closed:
+		return ErrClosed
+	default:
+	}
+
+	seq := c.nextSeq
+	c.nextSeq++
+	datagram := append([]byte(fmt.Sprintf("R %d ", seq)), body...)
+
+	var deadline time.Time
+	if d := time.Duration(c.writeTimeout.Load()); d > 0 {
+		deadline = time.Now().Add(d)
+	}
+
+	wait := c.cfg.RetransmitTimeout
+	for attempt := 0; attempt <= c.cfg.MaxRetries; attempt++ {
+		if attempt > 0 {
+			c.retransmits.Add(1)
+		}
+		if err := c.write(datagram); err != nil {
+			return err
+		}
+
+		acked, err := c.waitAck(seq, wait)
+		if err != nil || acked {
+			return err
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return timeoutError{}
+		}
+		wait = min(2*wait, maxBackoff)
+	}
+	return ErrPeerUnreachable
+}
+
+// SendUnreliable sends body once, it may get lost or arrive out of order
+func (c *Conn) SendUnreliable(body []byte) error {
+	select {
+	case <-c.closed:
+		return ErrClosed
+	default:
+	}
+	return c.write(append([]byte("U "), body...))
+}
+
+// Recv returns the next body, reliable ones in the order they were sent
+// timeout 0 waits forever
+func (c *Conn) Recv(timeout time.Duration) (body []byte, reliable bool, err error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case p := <-c.inbox:
+		return p.body, p.reliable, nil
+	case <-c.closed:
+		// hand out what arrived before the close
+		select {
+		case p := <-c.inbox:
+			return p.body, p.reliable, nil
+		default:
+			return nil, false, ErrClosed
+		}
+	case <-expired:
+		return nil, false, timeoutError{}
+	}
+}
+
+// Close ends the association, a Dial'ed conn also closes its socket
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		if c.onClose != nil {
+			c.onClose()
+		}
+		if c.owned {
+			c.pc.Close()
+		}
+	})
+	return nil
+}
+
+func (c *Conn) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Conn) waitAck(seq uint64, wait time.Duration) (bool, error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case ack := <-c.acks:
+			if ack == seq {
+				return true, nil
+			}
+			// late ACK of an earlier resend, keep waiting
+		case <-timer.C:
+			return false, nil
+		case <-c.closed:
+			return false, ErrClosed
+		}
+	}
+}
+
+// write sends one datagram unless the simulated loss eats it
+func (c *Conn) write(datagram []byte) error {
+	if c.cfg.Loss > 0 && rand.Float64() < c.cfg.Loss {
+		c.dropped.Add(1)
+		return nil
+	}
+	c.sent.Add(1)
+	_, err := c.pc.WriteTo(datagram, c.remote)
+	return err
+}
+
+func (c *Conn) ack(seq uint64) {
+	c.write([]byte("A " + strconv.FormatUint(seq, 10)))
+}
+
+// handle processes one incoming datagram, data is only valid during the call
+func (c *Conn) handle(data []byte) {
+	kind, rest, _ := bytes.Cut(data, []byte(" "))
+	switch string(kind) {
+	case "A":
+		seq, err := strconv.ParseUint(string(rest), 10, 64)
+		if err != nil {
+			return
+		}
+		select {
+		case c.acks <- seq:
+		default: // nobody is waiting that long, drop it
+		}
+
+	case "U":
+		if len(rest) == 0 {
+			return
+		}
+		c.received.Add(1)
+		select {
+		case c.inbox <- packet{body: bytes.Clone(rest)}:
+		default: // reader is behind, unreliable data may get lost anyway
+		}
+
+	case "R":
+		seqStr, body, _ := bytes.Cut(rest, []byte(" "))
+		seq, err := strconv.ParseUint(string(seqStr), 10, 64)
+		if err != nil {
+			return
+		}
+		switch {
+		case seq < c.expected:
+			// our ACK got lost, the peer is still waiting for it
+			c.duplicates.Add(1)
+			c.ack(seq)
+			return
+		case seq > c.expected:
+			return // cannot happen with stop-and-wait, unless the peer restarted
+		}
+		if c.isClosed() {
+			return // lingering, only old datagrams are acknowledged
+		}
+
+		if len(body) > 0 {
+			select {
+			case c.inbox <- packet{body: bytes.Clone(body), reliable: true}:
+				c.received.Add(1)
+			default:
+				return // no room, no ACK - the peer sends it again later
+			}
+		}
+		c.expected++
+		c.ack(seq)
+	}
+}
+
+// opensAssociation tells whether a datagram from an unknown address starts a new Conn
+func opensAssociation(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("R 1 "))
+}
+
+// Listener accepts associations on one UDP socket, datagrams are routed by source address
+type Listener struct {
+	pc  *net.UDPConn
+	cfg Config
+
+	mu    sync.Mutex
+	conns map[string]*Conn
+
+	accept    chan *Conn
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func Listen(addr string, cfg Config) (*Listener, error) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Listener{
+		pc:     pc,
+		cfg:    cfg,
+		conns:  make(map[string]*Conn),
+		accept: make(chan *Conn, 64),
+		closed: make(chan struct{}),
+	}
+	go l.readLoop()
+	return l, nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+// Accept waits for the next peer, returns net.ErrClosed after Close like a TCP listener
+func (l *Listener) Accept() (*Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops the listener and ends every association
+func (l *Listener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		err = l.pc.Close()
+
+		l.mu.Lock()
+		conns := make([]*Conn, 0, len(l.conns))
+		for _, c := range l.conns {
+			conns = append(conns, c)
+		}
+		l.mu.Unlock()
+
+		for _, c := range conns {
+			c.Close()
+		}
+	})
+	return err
+}
+
+func (l *Listener) readLoop() {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, from, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if c := l.lookup(from, buf[:n]); c != nil {
+			c.handle(buf[:n])
+		}
+	}
+}
+
+// lookup finds the Conn of a peer, or creates one if the datagram opens an association
+func (l *Listener) lookup(from net.Addr, data []byte) *Conn {
+	key := from.String()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.conns[key]
+	if ok && !(c.isClosed() && opensAssociation(data)) {
+		return c
+	}
+	if !opensAssociation(data) {
+		return nil // leftovers from a closed association
+	}
+
+	c = newConn(l.pc, from, l.cfg)
+	c.onClose = func() {
+		// keep it around a little so resends of what it already got are still acknowledged
+		time.AfterFunc(linger, func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			if l.conns[key] == c {
+				delete(l.conns, key)
+			}
+		})
+	}
+	select {
+	case l.accept <- c:
+		l.conns[key] = c
+		return c
+	default:
+		return nil // backlog full, the peer retries
+	}
+}
@@ -0,0 +1,117 @@
+package rudp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"socket-tcp/internal/protocol"
+)
+
+// lossy has a short RTO so retransmissions do not slow the tests down
+func lossy(loss float64) Config {
+	return Config{RetransmitTimeout: 10 * time.Millisecond, MaxRetries: 30, Loss: loss}
+}
+
+// pair returns both ends of an association over loopback
+func pair(t *testing.T, cfg Config) (client, server *Conn) {
+	t.Helper()
+
+	l, err := Listen("127.0.0.1:0", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	client, err = Dial(l.Addr().String(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	server, err = l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, server
+}
+
+func TestReliableInOrderUnderLoss(t *testing.T) {
+	client, server := pair(t, lossy(0.3))
+
+	const count = 50
+	go func() {
+		for i := 0; i < count; i++ {
+			client.Send([]byte(fmt.Sprint(i)))
+		}
+	}()
+
+	for i := 0; i < count; i++ {
+		body, reliable, err := server.Recv(5 * time.Second)
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if !reliable || string(body) != fmt.Sprint(i) {
+			t.Fatalf("message %d: got %q reliable=%v", i, body, reliable)
+		}
+	}
+
+	if st := client.Stats(); st.Retransmits == 0 || st.Dropped == 0 {
+		t.Errorf("expected the simulated loss to cause retransmissions, got %+v", st)
+	}
+}
+
+func TestDialUnreachable(t *testing.T) {
+	// a plain UDP socket never acknowledges anything
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	_, err = Dial(silent.LocalAddr().String(), Config{RetransmitTimeout: 5 * time.Millisecond, MaxRetries: 3})
+	if !errors.Is(err, ErrPeerUnreachable) {
+		t.Fatalf("got %v, want ErrPeerUnreachable", err)
+	}
+}
+
+func TestRecvTimeout(t *testing.T) {
+	_, server := pair(t, DefaultConfig())
+
+	_, _, err := server.Recv(20 * time.Millisecond)
+	if !protocol.IsTimeout(err) {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+}
+
+func TestMessageConnData(t *testing.T) {
+	client, server := pair(t, lossy(0.2))
+	cm, sm := NewMessageConn(client), NewMessageConn(server)
+	cm.SetReadTimeout(5 * time.Second)
+
+	data := bytes.Repeat([]byte("0123456789"), 500) // several chunks
+	go func() {
+		sm.SendData(7, protocol.CmdFile, fmt.Sprintf("%d test.bin", len(data)), bytes.NewReader(data), int64(len(data)))
+		sm.SendMessage(7, protocol.RespOK, "after")
+	}()
+
+	msg, err := cm.ReadMessage()
+	if err != nil || msg.Command != protocol.CmdFile {
+		t.Fatalf("header: %+v %v", msg, err)
+	}
+	var got bytes.Buffer
+	if err := cm.ReadData(&got, int64(len(data))); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Fatal("file data differs")
+	}
+
+	msg, err = cm.ReadMessage()
+	if err != nil || msg.Payload != "after" {
+		t.Fatalf("message after data: %+v %v", msg, err)
+	}
+}
@@ -0,0 +1,509 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"socket-tcp/internal/audit"
+	"socket-tcp/internal/auth"
+	"socket-tcp/internal/game"
+	"socket-tcp/internal/model"
+	"socket-tcp/pkg/util"
+)
+
+// the REST API described for integrators, served on GET /openapi.json
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// request bodies are tiny JSON documents
+const maxAPIBody = 64 * 1024
+
+// maxAPISessionsPerUser caps the REST logins of one user, each holds one of the session IDs
+const maxAPISessionsPerUser = 10
+
+var errTooManyAPISessions = fmt.Errorf("Too many REST logins, at most %d per user, log out of one first", maxAPISessionsPerUser)
+
+// apiToken is one REST login, it owns an AuthManager session like a TCP connection does
+type apiToken struct {
+	sessionID int
+	user      *model.User
+	lastUsed  time.Time
+}
+
+// apiTokens maps bearer tokens to sessions, idle tokens expire after ttl
+type apiTokens struct {
+	mu     sync.Mutex
+	tokens map[string]*apiToken
+	ttl    time.Duration
+}
+
+func newAPITokens(ttl time.Duration) *apiTokens {
+	return &apiTokens{tokens: make(map[string]*apiToken), ttl: ttl}
+}
+
+// add fails with errTooManyAPISessions when the user has maxAPISessionsPerUser tokens already
+func (at *apiTokens) add(sessionID int, user *model.User) (string, error) {
+	token, err := util.GenerateRandomString(32, util.AlphaNumeric)
+	if err != nil {
+		return "", err
+	}
+	at.mu.Lock()
+	defer at.mu.Unlock()
+
+	count := 0
+	for _, t := range at.tokens {
+		if t.user.Username == user.Username {
+			count++
+		}
+	}
+	if count >= maxAPISessionsPerUser {
+		return "", errTooManyAPISessions
+	}
+	at.tokens[token] = &apiToken{sessionID: sessionID, user: user, lastUsed: time.Now()}
+	return token, nil
+}
+
+// get returns a copy of the token's state and renews it, expired tokens are returned in expired
+func (at *apiTokens) get(token string) (tok apiToken, ok bool, expired []*apiToken) {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+
+	expired = at.expireLocked()
+	t, ok := at.tokens[token]
+	if !ok {
+		return apiToken{}, false, expired
+	}
+	t.lastUsed = time.Now()
+	return *t, true, expired
+}
+
+// expire drops the tokens idle for longer than ttl and returns them, their sessions are the caller's to end
+func (at *apiTokens) expire() []*apiToken {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	return at.expireLocked()
+}
+
+func (at *apiTokens) expireLocked() []*apiToken {
+	if at.ttl <= 0 {
+		return nil
+	}
+	var expired []*apiToken
+	now := time.Now()
+	for key, t := range at.tokens {
+		if now.Sub(t.lastUsed) > at.ttl {
+			delete(at.tokens, key)
+			expired = append(expired, t)
+		}
+	}
+	return expired
+}
+
+func (at *apiTokens) remove(token string) {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	delete(at.tokens, token)
+}
+
+// removeSession drops every token of a revoked session
+func (at *apiTokens) removeSession(sessionID int) {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+	for key, t := range at.tokens {
+		if t.sessionID == sessionID {
+			delete(at.tokens, key)
+		}
+	}
+}
+
+// apiHandlerFunc is a handler behind bearer authentication
+type apiHandlerFunc func(w http.ResponseWriter, r *http.Request, tok apiToken)
+
+// APIHandler serves the REST API, it shares users, sessions and games with the TCP clients
+// A game's id is the session id of its player, the game package keeps one game per session
+func (s *Server) APIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPIDocument)
+	})
+	mux.HandleFunc("POST /login", s.apiLogin)
+	mux.HandleFunc("POST /logout", s.apiAuth(s.apiLogout))
+	mux.HandleFunc("GET /me", s.apiAuth(s.apiGetMe))
+	mux.HandleFunc("PUT /me", s.apiAuth(s.apiPutMe))
+	mux.HandleFunc("POST /games", s.apiAuth(s.apiStartGame))
+	mux.HandleFunc("POST /games/{id}/guesses", s.apiAuth(s.apiGuess))
+	mux.HandleFunc("DELETE /games/{id}", s.apiAuth(s.apiEndGame))
+	mux.HandleFunc("GET /files/{name...}", s.apiAuth(s.apiFile))
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+// readJSON decodes a small body, fields the endpoint does not know are an error
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid JSON body: %v", err)
+		return false
+	}
+	return true
+}
+
+// apiAuth checks the bearer token and drops sessions whose token expired on the way
+func (s *Server) apiAuth(next apiHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		tok, ok, expired := s.apiTokens.get(strings.TrimSpace(token))
+		for _, t := range expired {
+			s.endAPISession(t, r.RemoteAddr)
+		}
+		if !found || !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, http.StatusUnauthorized, "Not authenticated")
+			return
+		}
+		tok.user = s.auth.User(tok.sessionID) // UpdateUser may have swapped it
+		if tok.user == nil {
+			writeAPIError(w, http.StatusUnauthorized, "Session expired")
+			return
+		}
+		next(w, r, tok)
+	}
+}
+
+// endAPISession is endSession for a REST login
+func (s *Server) endAPISession(t *apiToken, remote string) {
+	if s.games.HasActiveGame(t.sessionID) {
+		s.games.EndGame(t.sessionID)
+		s.metrics.gameOutcomes.Inc("abandoned")
+	}
+	s.auth.RemoveSession(t.sessionID)
+	s.audit.Record(audit.EventLogout, "user", t.user.Username, "session", t.sessionID, "remote", remote)
+}
+
+func (s *Server) apiLogin(w http.ResponseWriter, r *http.Request) {
+	// the same rule as AUTH, no password or code over plain HTTP
+	if s.cfg.RequireTLS && r.TLS == nil {
+		writeAPIError(w, http.StatusForbidden, "TLS is required, log in over HTTPS")
+		return
+	}
+	if !s.apiLoginLimiter.allow(hostOfRemote(r.RemoteAddr)) {
+		s.metrics.throttledTotal.Inc()
+		writeAPIError(w, http.StatusTooManyRequests, "Rate limit exceeded, please slow down")
+		return
+	}
+
+	// expired logins would otherwise hold their session IDs until some token is used
+	for _, t := range s.apiTokens.expire() {
+		s.endAPISession(t, r.RemoteAddr)
+	}
+
+	var creds struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Code     string `json:"code"` // TOTP or recovery code, for users with MFA
+	}
+	if !readJSON(w, r, &creds) {
+		return
+	}
+
+	// without connection state the password comes along with the code every time
+	sessionID, err := s.auth.AuthenticateUser(creds.Username, creds.Password)
+	if errors.Is(err, auth.ErrMFARequired) && creds.Code != "" {
+		var usedRecovery bool
+		sessionID, usedRecovery, err = s.auth.AuthenticateMFA(creds.Username, creds.Code)
+		if usedRecovery {
+			s.audit.Record(audit.EventRecoveryCodeUsed, "user", creds.Username, "session", sessionID, "remote", r.RemoteAddr)
+			if err := s.saveUsers(); err != nil {
+				s.log.Error("Failed to save users", "err", err)
+			}
+		}
+	}
+	if errors.Is(err, auth.ErrNoFreeSession) {
+		s.log.Warn("No free session for a login", "user", creds.Username, "remote", r.RemoteAddr, "api", true)
+		writeAPIError(w, http.StatusServiceUnavailable, "%v", err)
+		return
+	}
+	if err != nil {
+		s.log.Info("Authentication failed", "user", creds.Username, "remote", r.RemoteAddr, "api", true, "err", err)
+		s.metrics.authFailures.Inc()
+		s.audit.Record(audit.EventLoginFailed, "user", creds.Username, "remote", r.RemoteAddr, "reason", err.Error())
+		writeAPIError(w, http.StatusUnauthorized, "Authentication Failed: %v", err)
+		return
+	}
+
+	s.auth.SetRemote(sessionID, r.RemoteAddr)
+	user := s.auth.User(sessionID)
+	token, err := s.apiTokens.add(sessionID, user)
+	if errors.Is(err, errTooManyAPISessions) {
+		s.auth.RemoveSession(sessionID)
+		writeAPIError(w, http.StatusTooManyRequests, "%v", err)
+		return
+	}
+	if err != nil {
+		s.auth.RemoveSession(sessionID)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to create token")
+		return
+	}
+	s.audit.Record(audit.EventLogin, "user", user.Username, "session", sessionID, "remote", r.RemoteAddr)
+	s.log.Info("Client authenticated", "user", user.Username, "session", sessionID, "remote", r.RemoteAddr, "api", true)
+
+	answer := map[string]any{
+		"token":      token,
+		"token_type": "Bearer",
+		"session_id": sessionID,
+		"expires_in": int(s.cfg.APITokenTTL.Seconds()), // seconds of inactivity
+	}
+	if state, resumed := s.resumeGame(sessionID, user.Username); resumed {
+		answer["game_resumed"] = true // game id = session_id as always
+		answer["guess_count"] = state.GuessCount
+	}
+	writeJSON(w, http.StatusOK, answer)
+}
+
+func (s *Server) apiLogout(w http.ResponseWriter, r *http.Request, tok apiToken) {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	s.apiTokens.remove(strings.TrimSpace(token))
+	s.endAPISession(&tok, r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiProfile is the public part of model.User, the password never leaves the server
+type apiProfile struct {
+	Username  string          `json:"username"`
+	Fullname  string          `json:"fullname"`
+	Emails    []string        `json:"emails"`
+	Addresses []model.Address `json:"addresses"`
+	Role      string          `json:"role"`
+}
+
+func profileOf(u *model.User) apiProfile {
+	role := u.Role
+	if role == "" {
+		role = model.RoleUser
+	}
+	return apiProfile{
+		Username:  u.Username,
+		Fullname:  u.Fullname,
+		Emails:    u.Emails,
+		Addresses: u.Addresses,
+		Role:      role,
+	}
+}
+
+func (s *Server) apiGetMe(w http.ResponseWriter, r *http.Request, tok apiToken) {
+	writeJSON(w, http.StatusOK, profileOf(tok.user))
+}
+
+// apiPutMe replaces fullname, emails and addresses; username and role may be sent back but not changed
+func (s *Server) apiPutMe(w http.ResponseWriter, r *http.Request, tok apiToken) {
+	var profile apiProfile
+	if !readJSON(w, r, &profile) {
+		return
+	}
+	current := profileOf(tok.user)
+	if profile.Username != "" && profile.Username != current.Username {
+		writeAPIError(w, http.StatusBadRequest, "username cannot be changed")
+		return
+	}
+	if profile.Role != "" && profile.Role != current.Role {
+		writeAPIError(w, http.StatusForbidden, "role cannot be changed")
+		return
+	}
+
+	// check a copy first, a profile is changed completely or not at all
+	candidate := *tok.user
+	candidate.Fullname, candidate.Emails, candidate.Addresses = profile.Fullname, profile.Emails, profile.Addresses
+	candidate.Normalize()
+	var invalid model.ValidationErrors
+	if err := candidate.Validate(); errors.As(err, &invalid) {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Invalid profile: " + invalid.Error(), "fields": invalid.Fields()})
+		return
+	}
+
+	updated, err := s.auth.UpdateUser(tok.user.Username, func(u *model.User) {
+		u.Fullname = candidate.Fullname
+		u.Emails = candidate.Emails
+		u.Addresses = candidate.Addresses
+	})
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "%v", err)
+		return
+	}
+
+	if err := s.saveUsers(); err != nil {
+		writeAPIError(w, http.StatusInternalServerError, "Profile updated but not saved: %v", err)
+		return
+	}
+	s.log.Info("Profile updated", "user", updated.Username, "session", tok.sessionID, "api", true)
+	writeJSON(w, http.StatusOK, profileOf(updated))
+}
+
+// gameID checks the {id} in the path is the caller's game, other players' games do not exist for them
+func gameID(w http.ResponseWriter, r *http.Request, tok apiToken) bool {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id != tok.sessionID {
+		writeAPIError(w, http.StatusNotFound, "Game not found")
+		return false
+	}
+	return true
+}
+
+// apiStartGame takes the START options as JSON, no body is the classic game
+func (s *Server) apiStartGame(w http.ResponseWriter, r *http.Request, tok apiToken) {
+	var body struct {
+		Variant    string `json:"variant"`
+		Min        int    `json:"min"`
+		Max        int    `json:"max"`
+		Digits     int    `json:"digits"`
+		MaxGuesses int    `json:"max_guesses"`
+		TimeLimit  int    `json:"time_limit"` // seconds
+	}
+	if r.ContentLength != 0 && !readJSON(w, r, &body) {
+		return
+	}
+	opts := model.GameOptions{
+		Variant:    body.Variant,
+		Min:        body.Min,
+		Max:        body.Max,
+		Digits:     body.Digits,
+		MaxGuesses: body.MaxGuesses,
+		TimeLimit:  time.Duration(body.TimeLimit) * time.Second,
+	}
+
+	text, err := s.games.StartGame(tok.sessionID, opts)
+	switch {
+	case errors.Is(err, game.ErrGameInProgress):
+		writeAPIError(w, http.StatusConflict, "%v", err)
+		return
+	case errors.Is(err, game.ErrInvalidOptions):
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	case err != nil:
+		writeAPIError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	result := map[string]any{"id": tok.sessionID, "message": text}
+	if state, ok := s.games.Game(tok.sessionID); ok {
+		result["variant"] = state.Options.Variant
+		if state.Options.Max > 0 {
+			result["min"], result["max"] = state.Options.Min, state.Options.Max
+		}
+		if state.Options.Digits > 0 {
+			result["digits"] = state.Options.Digits
+		}
+		if state.Options.MaxGuesses > 0 {
+			result["max_guesses"] = state.Options.MaxGuesses
+		}
+		if !state.Deadline.IsZero() {
+			result["deadline"] = state.Deadline
+		}
+	}
+	w.Header().Set("Location", fmt.Sprintf("/games/%d", tok.sessionID))
+	writeJSON(w, http.StatusCreated, result)
+}
+
+// apiGuess takes the guess as a number or, for codes with leading zeros, a string
+func (s *Server) apiGuess(w http.ResponseWriter, r *http.Request, tok apiToken) {
+	if !gameID(w, r, tok) {
+		return
+	}
+	var body struct {
+		Guess json.RawMessage `json:"guess"`
+	}
+	if !readJSON(w, r, &body) {
+		return
+	}
+	var guess string
+	if len(body.Guess) == 0 || string(body.Guess) == "null" {
+		writeAPIError(w, http.StatusBadRequest, "Missing guess")
+		return
+	}
+	if json.Unmarshal(body.Guess, &guess) != nil {
+		var number json.Number
+		if err := json.Unmarshal(body.Guess, &number); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "The guess must be a number or a string")
+			return
+		}
+		guess = number.String()
+	}
+
+	text, outcome, err := s.games.MakeGuess(tok.sessionID, guess)
+	switch {
+	case errors.Is(err, game.ErrInvalidGuess):
+		writeAPIError(w, http.StatusBadRequest, "%v", err)
+		return
+	case err != nil:
+		writeAPIError(w, http.StatusNotFound, "%v", err)
+		return
+	}
+	if outcome != game.Playing {
+		s.metrics.gameOutcomes.Inc(outcome.String())
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"message": text, "won": outcome == game.Won, "outcome": outcome.String()})
+}
+
+func (s *Server) apiEndGame(w http.ResponseWriter, r *http.Request, tok apiToken) {
+	if !gameID(w, r, tok) {
+		return
+	}
+	text, err := s.games.EndGame(tok.sessionID)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "%v", err)
+		return
+	}
+	s.metrics.gameOutcomes.Inc("ended")
+	writeJSON(w, http.StatusOK, map[string]any{"message": text})
+}
+
+// apiFile serves the same files as the FILE command, ServeContent adds Range and caching headers
+func (s *Server) apiFile(w http.ResponseWriter, r *http.Request, tok apiToken) {
+	name, ok := s.resolvePath(r.PathValue("name"))
+	if !ok || name == "" {
+		writeAPIError(w, http.StatusBadRequest, "Invalid file name")
+		return
+	}
+
+	fsPath, err := s.fsPath(name)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "File not found: %s", name)
+		return
+	}
+	file, err := os.Open(fsPath)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "File not found: %s", name)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		writeAPIError(w, http.StatusNotFound, "File not found: %s", name)
+		return
+	}
+
+	s.audit.Record(audit.EventFileDownload, "user", tok.user.Username, "session", tok.sessionID, "file", name, "size", info.Size())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", path.Base(name)))
+	http.ServeContent(w, r, name, info.ModTime(), file)
+}
@@ -0,0 +1,298 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"socket-tcp/internal/game"
+	"socket-tcp/internal/protocol"
+	"socket-tcp/internal/server"
+)
+
+// apiClient calls the REST API of a test server
+type apiClient struct {
+	t      *testing.T
+	url    string
+	token  string
+	client *http.Client // nil = http.DefaultClient
+}
+
+func (ts *testServer) api(t *testing.T) *apiClient {
+	t.Helper()
+	httpServer := httptest.NewServer(ts.APIHandler())
+	t.Cleanup(httpServer.Close)
+	return &apiClient{t: t, url: httpServer.URL}
+}
+
+// do sends body as JSON and decodes the answer into out when it is not nil
+func (ac *apiClient) do(method, path string, body any, wantStatus int, out any) *http.Response {
+	ac.t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, _ := json.Marshal(body)
+		reader = bytes.NewReader(data)
+	}
+	req, _ := http.NewRequest(method, ac.url+path, reader)
+	if ac.token != "" {
+		req.Header.Set("Authorization", "Bearer "+ac.token)
+	}
+	client := ac.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		ac.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != wantStatus {
+		ac.t.Fatalf("%s %s: got %d %s, want %d", method, path, resp.StatusCode, data, wantStatus)
+	}
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			ac.t.Fatalf("%s %s: %v in %s", method, path, err, data)
+		}
+	}
+	return resp
+}
+
+func (ac *apiClient) login(username, password string) int {
+	ac.t.Helper()
+	var token struct {
+		Token     string `json:"token"`
+		SessionID int    `json:"session_id"`
+	}
+	ac.do("POST", "/login", map[string]string{"username": username, "password": password}, http.StatusOK, &token)
+	ac.token = token.Token
+	return token.SessionID
+}
+
+func TestAPILoginAndProfile(t *testing.T) {
+	ts := startServer(t)
+	ac := ts.api(t)
+
+	ac.do("GET", "/me", nil, http.StatusUnauthorized, nil)
+	ac.do("POST", "/login", map[string]string{"username": "user1", "password": "wrong"}, http.StatusUnauthorized, nil)
+	ac.do("POST", "/login", map[string]string{"username": "user1", "password": "user123", "role": "admin"}, http.StatusBadRequest, nil)
+
+	sessionID := ac.login("user1", "user123")
+	if !ts.auth.ValidateSession(sessionID) {
+		t.Fatal("a REST login should be a session of the shared AuthManager")
+	}
+
+	var profile map[string]any
+	ac.do("GET", "/me", nil, http.StatusOK, &profile)
+	if profile["username"] != "user1" || profile["role"] != "user" || profile["password"] != nil {
+		t.Fatalf("unexpected profile %v", profile)
+	}
+
+	profile["fullname"] = "Renamed User"
+	ac.do("PUT", "/me", profile, http.StatusOK, &profile)
+	if profile["fullname"] != "Renamed User" {
+		t.Fatalf("fullname not updated: %v", profile)
+	}
+	if got := ts.auth.User(sessionID).Fullname; got != "Renamed User" {
+		t.Fatalf("AuthManager still has %q", got)
+	}
+
+	profile["role"] = "admin"
+	ac.do("PUT", "/me", profile, http.StatusForbidden, nil)
+
+	ac.do("POST", "/logout", nil, http.StatusNoContent, nil)
+	ac.do("GET", "/me", nil, http.StatusUnauthorized, nil)
+	if ts.auth.ValidateSession(sessionID) {
+		t.Fatal("logout should remove the session")
+	}
+}
+
+func TestAPISessionLimits(t *testing.T) {
+	ts := startServer(t, func(cfg *server.Config) { cfg.APITokenTTL = 100 * time.Millisecond })
+	creds := map[string]string{"username": "user1", "password": "user123"}
+	for i := 0; i < 10; i++ {
+		ts.api(t).login("user1", "user123")
+	}
+	ts.api(t).do("POST", "/login", creds, http.StatusTooManyRequests, nil)
+	if got := len(ts.auth.SessionsOf("user1")); got != 10 {
+		t.Fatalf("%d sessions after a refused login, want 10", got)
+	}
+
+	// nobody uses the tokens, the next login ends their sessions
+	time.Sleep(150 * time.Millisecond)
+	sessionID := ts.api(t).login("user1", "user123")
+	if got := ts.auth.SessionsOf("user1"); len(got) != 1 || got[0].SessionID != sessionID {
+		t.Fatalf("expired REST sessions kept: %v", got)
+	}
+}
+
+func TestAPIProfileValidation(t *testing.T) {
+	ts := startServer(t)
+	ac := ts.api(t)
+	ac.login("user1", "user123")
+
+	var invalid struct {
+		Error  string            `json:"error"`
+		Fields map[string]string `json:"fields"`
+	}
+	ac.do("PUT", "/me", map[string]any{
+		"fullname":  "User One",
+		"emails":    []string{"user1@example.com", "nope"},
+		"addresses": []map[string]string{{"type": "home", "details": "1 Main St"}, {"type": "castle", "details": "On a hill"}},
+	}, http.StatusBadRequest, &invalid)
+	if len(invalid.Fields) != 2 || invalid.Fields["emails[1]"] == "" || invalid.Fields["addresses[1].type"] == "" {
+		t.Fatalf("want errors for emails[1] and addresses[1].type, got %+v", invalid)
+	}
+	if got := ts.auth.Users()[1]; got.Fullname == "User One" {
+		t.Fatal("an invalid profile was partly applied")
+	}
+
+	var profile map[string]any
+	ac.do("PUT", "/me", map[string]any{
+		"fullname":  " User One ",
+		"emails":    []string{"User One <user1@EXAMPLE.com>", "user1@example.com"},
+		"addresses": []map[string]string{{"type": "Work", "details": "2 Side St"}},
+	}, http.StatusOK, &profile)
+	emails, _ := profile["emails"].([]any)
+	addresses, _ := profile["addresses"].([]any)
+	if profile["fullname"] != "User One" || len(emails) != 1 || emails[0] != "user1@example.com" ||
+		addresses[0].(map[string]any)["type"] != "work" {
+		t.Fatalf("profile not normalized: %v", profile)
+	}
+}
+
+func TestAPIGame(t *testing.T) {
+	ts := startServer(t)
+	ac := ts.api(t)
+	sessionID := ac.login("user1", "user123")
+	gamePath := fmt.Sprintf("/games/%d", sessionID)
+
+	ac.do("POST", gamePath+"/guesses", map[string]int{"guess": 50}, http.StatusNotFound, nil)
+
+	var created struct {
+		ID int `json:"id"`
+	}
+	resp := ac.do("POST", "/games", nil, http.StatusCreated, &created)
+	if created.ID != sessionID || resp.Header.Get("Location") != gamePath {
+		t.Fatalf("game id %d location %q, want %d", created.ID, resp.Header.Get("Location"), sessionID)
+	}
+	ac.do("POST", "/games", nil, http.StatusConflict, nil)
+	ac.do("POST", "/games/1/guesses", map[string]int{"guess": 50}, http.StatusNotFound, nil)
+	ac.do("POST", gamePath+"/guesses", map[string]string{"guess": "abc"}, http.StatusBadRequest, nil)
+
+	low, high := game.MinNumber, game.MaxNumber
+	for i := 0; ; i++ {
+		if i == 7 {
+			t.Fatal("number not found after 7 guesses")
+		}
+		guess := (low + high) / 2
+		var result struct {
+			Message string `json:"message"`
+			Won     bool   `json:"won"`
+		}
+		ac.do("POST", gamePath+"/guesses", map[string]int{"guess": guess}, http.StatusOK, &result)
+		if result.Won {
+			break
+		}
+		if strings.Contains(result.Message, "too low") {
+			low = guess + 1
+		} else {
+			high = guess - 1
+		}
+	}
+
+	ac.do("DELETE", gamePath, nil, http.StatusNotFound, nil)
+	ac.do("POST", "/games", nil, http.StatusCreated, nil)
+	ac.do("DELETE", gamePath, nil, http.StatusOK, nil)
+}
+
+func TestAPIFileAndSharedSessions(t *testing.T) {
+	ts := startServer(t)
+	if err := os.WriteFile(filepath.Join(ts.fileRoot, "sample.txt"), []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ac := ts.api(t)
+	ac.do("GET", "/files/sample.txt", nil, http.StatusUnauthorized, nil)
+	ac.login("admin", "123")
+	ac.do("GET", "/files/missing.txt", nil, http.StatusNotFound, nil)
+	ac.do("GET", "/files/.hidden", nil, http.StatusBadRequest, nil)
+
+	req, _ := http.NewRequest("GET", ac.url+"/files/sample.txt", nil)
+	req.Header.Set("Authorization", "Bearer "+ac.token)
+	req.Header.Set("Range", "bytes=2-4")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "234" {
+		t.Fatalf("range request got %d %q", resp.StatusCode, body)
+	}
+
+	// the TCP and REST logins of the same user are separate sessions of one AuthManager
+	tc := ts.dial(t)
+	tc.login("admin", "123")
+	tc.run([]step{{protocol.CmdStartGame, "", protocol.RespOK, "Game started"}})
+	if ts.auth.SessionCount() != 2 {
+		t.Fatalf("got %d sessions, want 2", ts.auth.SessionCount())
+	}
+}
+
+func TestAPILoginRequiresTLS(t *testing.T) {
+	ts := startServer(t, func(cfg *server.Config) { cfg.RequireTLS = true })
+
+	ac := ts.api(t)
+	ac.do("POST", "/login", map[string]string{"username": "user1", "password": "user123"}, http.StatusForbidden, nil)
+	if sessions := ts.auth.SessionsOf("user1"); len(sessions) != 0 {
+		t.Fatalf("plain HTTP login created sessions %v", sessions)
+	}
+
+	httpsServer := httptest.NewTLSServer(ts.APIHandler())
+	t.Cleanup(httpsServer.Close)
+	secure := &apiClient{t: t, url: httpsServer.URL, client: httpsServer.Client()}
+	secure.login("user1", "user123")
+	secure.do("GET", "/me", nil, http.StatusOK, nil)
+}
+
+func TestAPILoginLimitSurvivesManyHosts(t *testing.T) {
+	ts := startServer(t, func(cfg *server.Config) {
+		cfg.AuthRate = 0.01
+		cfg.AuthBurst = 2
+	})
+	handler := ts.APIHandler()
+	login := func(remote string) int {
+		req := httptest.NewRequest("POST", "/login", strings.NewReader("{}"))
+		req.RemoteAddr = remote
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// the attacked host uses up its attempts
+	for i := 0; i < 2; i++ {
+		login("10.0.0.1:1000")
+	}
+	if code := login("10.0.0.1:1000"); code != http.StatusTooManyRequests {
+		t.Fatalf("third attempt got %d, want 429", code)
+	}
+
+	// lots of other addresses must not reset it
+	for i := 0; i < 10050; i++ {
+		login(fmt.Sprintf("[2001:db8::%x]:1000", i))
+	}
+	if code := login("10.0.0.1:1000"); code != http.StatusTooManyRequests {
+		t.Fatalf("attempt after a flood of hosts got %d, want 429", code)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"socket-tcp/internal/audit"
+	"socket-tcp/internal/protocol"
+)
+
+// authToken is the AUTH "username" that means a token or API key follows
+const authToken = "TOKEN"
+
+// maxAPIKeyName keeps names short enough for a LIST line
+const maxAPIKeyName = 32
+
+// handleAPIKey manages the user's API keys: APIKEY CREATE <name> | LIST | REVOKE <id or name>
+func (s *Server) handleAPIKey(c *client, msg *protocol.Message) error {
+	action, arg, _ := strings.Cut(strings.TrimSpace(msg.Payload), " ")
+	arg = strings.TrimSpace(arg)
+	username := c.user.Username
+
+	switch strings.ToUpper(action) {
+	case "CREATE":
+		if arg == "" || len(arg) > maxAPIKeyName || strings.ContainsAny(arg, " |") {
+			return c.replyError("Invalid key name, use APIKEY CREATE <name> with up to %d characters and no spaces", maxAPIKeyName)
+		}
+		key, info, err := s.auth.CreateAPIKey(username, arg)
+		if err != nil {
+			return c.replyError("%v", err)
+		}
+		s.audit.Record(audit.EventAPIKeyCreated, "user", username, "session", c.sessionID, "key", info.ID, "name", info.Name)
+		c.log.Info("API key created", "key", info.ID, "name", info.Name)
+		if err := s.saveUsers(); err != nil {
+			return c.replyError("API key created but not saved, it will be gone after a restart: %v", err)
+		}
+		return c.reply(protocol.RespOK, fmt.Sprintf("API key %s created, use AUTH TOKEN %s - it is shown only this once", info.Name, key))
+
+	case "LIST":
+		keys, err := s.auth.APIKeys(username)
+		if err != nil {
+			return c.replyError("%v", err)
+		}
+		if len(keys) == 0 {
+			return c.reply(protocol.RespOK, "No API keys")
+		}
+		entries := make([]string, len(keys))
+		for i, key := range keys {
+			entries[i] = fmt.Sprintf("%s %s created %s", key.ID, key.Name, key.Created.UTC().Format(time.RFC3339))
+		}
+		return c.reply(protocol.RespOK, fmt.Sprintf("%d API keys: %s", len(keys), strings.Join(entries, " | ")))
+
+	case "REVOKE":
+		if arg == "" {
+			return c.replyError("Missing key, use APIKEY REVOKE <id or name>")
+		}
+		info, err := s.auth.RevokeAPIKey(username, arg)
+		if err != nil {
+			return c.replyError("%v: %s", err, arg)
+		}
+		s.audit.Record(audit.EventAPIKeyRevoked, "user", username, "session", c.sessionID, "key", info.ID, "name", info.Name)
+		c.log.Info("API key revoked", "key", info.ID, "name", info.Name)
+		if err := s.saveUsers(); err != nil {
+			return c.replyError("API key revoked but not saved, it comes back after a restart: %v", err)
+		}
+		return c.reply(protocol.RespOK, fmt.Sprintf("API key %s (%s) revoked", info.ID, info.Name))
+	}
+	return c.replyError("Unknown action, use APIKEY CREATE <name>, APIKEY LIST or APIKEY REVOKE <id or name>")
+}
@@ -0,0 +1,94 @@
+package server_test
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"socket-tcp/internal/protocol"
+)
+
+// loginToken logs in with a password and returns the access token from the answer
+func loginToken(t *testing.T, tc *testClient, username, password string) string {
+	t.Helper()
+	tc.send(protocol.CmdAuth, username+" "+password)
+	msg := tc.expect(protocol.RespOK, "Authentication Successful")
+	_, rest, found := strings.Cut(msg.Payload, " token ")
+	token, _, _ := strings.Cut(rest, " ")
+	if !found || strings.Count(token, ".") != 2 {
+		t.Fatalf("no token in %q", msg.Payload)
+	}
+	tc.sessionID = msg.SessionID
+	return token
+}
+
+func TestTokenLogin(t *testing.T) {
+	ts := startServer(t)
+	token := loginToken(t, ts.dial(t), "user1", "user123")
+
+	tc := ts.dial(t)
+	tc.send(protocol.CmdAuth, "TOKEN "+token)
+	msg := tc.expect(protocol.RespOK, "logged in as user1")
+	tc.sessionID = msg.SessionID
+	tc.run([]step{{protocol.CmdStartGame, "", protocol.RespOK, "Game started"}})
+
+	header, rest, _ := strings.Cut(token, ".")
+	claims, _, _ := strings.Cut(rest, ".")
+	admin := base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"socket-tcp","sub":"admin","iat":1,"exp":4102444800}`))
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	bad := ts.dial(t)
+	bad.run([]step{
+		{protocol.CmdAuth, "TOKEN " + token[:len(token)-2] + "xx", protocol.RespError, "Invalid token"},
+		{protocol.CmdAuth, "TOKEN " + header + "." + admin + "." + strings.Split(token, ".")[2], protocol.RespError, "Invalid token"},
+		{protocol.CmdAuth, "TOKEN " + none + "." + claims + ".", protocol.RespError, "Invalid token"},
+		{protocol.CmdAuth, "TOKEN garbage", protocol.RespError, "Invalid token"},
+	})
+
+	// tokens of an old key die with it, expired ones too
+	ts.auth.SetTokenKey([]byte(strings.Repeat("k", 32)), -time.Second)
+	bad.run([]step{{protocol.CmdAuth, "TOKEN " + token, protocol.RespError, "Invalid token"}})
+	expired := loginToken(t, ts.dial(t), "user1", "user123")
+	bad.run([]step{{protocol.CmdAuth, "TOKEN " + expired, protocol.RespError, "Token expired"}})
+}
+
+func TestAPIKeys(t *testing.T) {
+	ts := startServer(t)
+	tc := ts.dial(t)
+	tc.run([]step{{protocol.CmdAPIKey, "LIST", protocol.RespError, "Not authenticated"}})
+	tc.login("user1", "user123")
+	tc.run([]step{
+		{protocol.CmdAPIKey, "LIST", protocol.RespOK, "No API keys"},
+		{protocol.CmdAPIKey, "CREATE", protocol.RespError, "Invalid key name"},
+		{protocol.CmdAPIKey, "ROTATE x", protocol.RespError, "Unknown action"},
+	})
+
+	tc.send(protocol.CmdAPIKey, "CREATE backup")
+	msg := tc.expect(protocol.RespOK, "API key backup created, use AUTH TOKEN sk_")
+	_, rest, _ := strings.Cut(msg.Payload, "AUTH TOKEN ")
+	key, _, _ := strings.Cut(rest, " ")
+	id := strings.Split(key, "_")[1]
+	tc.run([]step{
+		{protocol.CmdAPIKey, "create backup", protocol.RespError, "already exists"},
+		{protocol.CmdAPIKey, "LIST", protocol.RespOK, "1 API keys: " + id + " backup created "},
+	})
+
+	script := ts.dial(t)
+	script.run([]step{
+		{protocol.CmdAuth, "TOKEN " + key[:len(key)-1] + "x", protocol.RespError, "Invalid token"},
+		{protocol.CmdAuth, "TOKEN " + key, protocol.RespOK, "logged in as user1"},
+	})
+
+	// someone else cannot see or revoke it, its owner can
+	other := ts.dial(t)
+	other.login("admin", "123")
+	other.run([]step{
+		{protocol.CmdAPIKey, "LIST", protocol.RespOK, "No API keys"},
+		{protocol.CmdAPIKey, "REVOKE " + id, protocol.RespError, "API key not found"},
+	})
+	tc.run([]step{
+		{protocol.CmdAPIKey, "REVOKE backup", protocol.RespOK, "API key " + id + " (backup) revoked"},
+		{protocol.CmdAPIKey, "LIST", protocol.RespOK, "No API keys"},
+	})
+	ts.dial(t).run([]step{{protocol.CmdAuth, "TOKEN " + key, protocol.RespError, "Invalid token"}})
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// checksumCache remembers file hashes until the file's size or mtime changes,
+// resumed downloads of a big file would otherwise hash it again every time
+type checksumCache struct {
+	mu      sync.Mutex
+	entries map[string]checksumEntry // path -> hash
+}
+
+type checksumEntry struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+func newChecksumCache() *checksumCache {
+	return &checksumCache{entries: make(map[string]checksumEntry)}
+}
+
+// sum returns the hex SHA-256 of the open file, info must be its Stat
+func (cc *checksumCache) sum(path string, file *os.File, info os.FileInfo) (string, error) {
+	cc.mu.Lock()
+	entry, ok := cc.entries[path]
+	cc.mu.Unlock()
+	if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+		return entry.sum, nil
+	}
+
+	// hashed without the lock, two clients may hash the same file once each
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.NewSectionReader(file, 0, info.Size())); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	cc.mu.Lock()
+	cc.entries[path] = checksumEntry{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	cc.mu.Unlock()
+	return sum, nil
+}
+
+// parseRange reads "bytes=a-b" (inclusive), "bytes=a-" or "bytes=-k" (the last k bytes)
+// like an HTTP Range header, and returns where to start and how many bytes to send
+func parseRange(spec string, total int64) (offset, n int64, err error) {
+	first, last, found := strings.Cut(strings.TrimPrefix(spec, "bytes="), "-")
+	if !strings.HasPrefix(spec, "bytes=") || !found || first == "" && last == "" {
+		return 0, 0, errors.New("Invalid range, use bytes=start-end, bytes=start- or bytes=-length")
+	}
+
+	if first == "" {
+		k, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || k < 0 {
+			return 0, 0, errors.New("Invalid range")
+		}
+		k = min(k, total)
+		return total - k, k, nil
+	}
+
+	offset, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, 0, errors.New("Invalid range")
+	}
+	end := total - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < offset {
+			return 0, 0, errors.New("Invalid range")
+		}
+		end = min(end, total-1)
+	}
+	// resuming a complete file asks for bytes=<total>-, that is an empty range and fine
+	if offset > total || offset == total && last != "" {
+		return 0, 0, fmt.Errorf("Range not satisfiable, the file has %d bytes", total)
+	}
+	return offset, end - offset + 1, nil
+}
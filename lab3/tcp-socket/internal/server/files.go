@@ -0,0 +1,280 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"socket-tcp/internal/protocol"
+)
+
+// maxFindResults caps FIND on a huge tree, the reply says when it was cut
+const maxFindResults = 1000
+
+// resolvePath checks a slash separated path below FileRoot, "" is the root itself
+// ok is false for anything that leaves the root or touches a hidden or excluded name
+func (s *Server) resolvePath(rel string) (clean string, ok bool) {
+	rel = strings.Trim(strings.TrimSpace(rel), "/")
+	if rel == "" || rel == "." {
+		return "", true
+	}
+	if strings.Contains(rel, `\`) {
+		return "", false
+	}
+	for _, part := range strings.Split(rel, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", false
+		}
+	}
+	if s.excluded(rel) {
+		return "", false
+	}
+	return rel, true
+}
+
+// excluded reports whether any part of rel is a dot file or matches Config.ExcludePatterns,
+// a pattern is matched against each name and against the path up to that name
+func (s *Server) excluded(rel string) bool {
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+		prefix := strings.Join(parts[:i+1], "/")
+		for _, pattern := range s.cfg.ExcludePatterns {
+			if matched, _ := path.Match(pattern, part); matched {
+				return true
+			}
+			if matched, _ := path.Match(pattern, prefix); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// errOutsideRoot is a path whose symlinks lead out of FileRoot or to a hidden or excluded name
+var errOutsideRoot = errors.New("path leaves the file root")
+
+// fsPath turns a resolved path into one on disk. resolvePath only looks at the name, so symlinks
+// are resolved here and the target has to pass the same checks, below FileRoot and not excluded.
+func (s *Server) fsPath(rel string) (string, error) {
+	root, err := filepath.EvalSymlinks(s.cfg.FileRoot)
+	if err != nil {
+		return "", err
+	}
+	target, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(rel)))
+	if err != nil {
+		return "", err
+	}
+	inside, err := filepath.Rel(root, target)
+	if err != nil || inside == ".." || strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+		return "", errOutsideRoot
+	}
+	if inside != "." && s.excluded(filepath.ToSlash(inside)) {
+		return "", errOutsideRoot
+	}
+	return target, nil
+}
+
+// splitPage takes a trailing "page=N" off the payload, page 1 if there is none
+func splitPage(payload string) (rest string, page int, err error) {
+	rest = strings.TrimSpace(payload)
+	i := strings.LastIndex(rest, " ")
+	last := rest[i+1:]
+	if !strings.HasPrefix(last, "page=") {
+		return rest, 1, nil
+	}
+	page, err = strconv.Atoi(strings.TrimPrefix(last, "page="))
+	if err != nil || page < 1 {
+		return "", 0, errors.New("Invalid page, use page=N with N >= 1")
+	}
+	if i < 0 {
+		return "", page, nil
+	}
+	return strings.TrimSpace(rest[:i]), page, nil
+}
+
+// paginate picks one page of entries, the header says where it is in the whole list
+func (s *Server) paginate(entries []string, page int, what string) (string, error) {
+	size := s.cfg.ListPageSize
+	if size <= 0 {
+		size = len(entries)
+	}
+	pages := max(1, (len(entries)+size-1)/max(size, 1))
+	if page > pages {
+		return "", fmt.Errorf("Page %d out of range, %s has %d pages", page, what, pages)
+	}
+	if len(entries) == 0 {
+		return what + ": no entries", nil
+	}
+	start := (page - 1) * size
+	end := min(start+size, len(entries))
+	return fmt.Sprintf("%s: %d entries, page %d of %d: %s", what, len(entries), page, pages, strings.Join(entries[start:end], " | ")), nil
+}
+
+// listEntry is "name size mtime" for a file and "name/ mtime" for a directory
+func listEntry(name string, info fs.FileInfo) string {
+	modified := info.ModTime().UTC().Format(time.RFC3339)
+	if info.IsDir() {
+		return name + "/ " + modified
+	}
+	return fmt.Sprintf("%s %d %s", name, info.Size(), modified)
+}
+
+// displayPath shows the root as "/"
+func displayPath(rel string) string {
+	return "/" + rel
+}
+
+// handleLs lists one directory below the file root: LS [path] [page=N]
+func (s *Server) handleLs(c *client, msg *protocol.Message) error {
+	payload, page, err := splitPage(msg.Payload)
+	if err != nil {
+		return c.replyError("%v", err)
+	}
+	rel, ok := s.resolvePath(payload)
+	if !ok {
+		return c.replyError("Invalid path: %s", payload)
+	}
+
+	dir, err := s.fsPath(rel)
+	if err != nil {
+		return c.replyError("No such directory: %s", displayPath(rel))
+	}
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return c.replyError("No such directory: %s", displayPath(rel))
+	}
+
+	var entries []string
+	for _, entry := range dirEntries {
+		if s.excluded(path.Join(rel, entry.Name())) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.IsDir() && !info.Mode().IsRegular() {
+			continue
+		}
+		entries = append(entries, listEntry(entry.Name(), info))
+	}
+
+	text, err := s.paginate(entries, page, displayPath(rel))
+	if err != nil {
+		return c.replyError("%v", err)
+	}
+	return c.reply(protocol.RespOK, text)
+}
+
+// handleStat describes one file or directory, files with their SHA-256
+func (s *Server) handleStat(c *client, msg *protocol.Message) error {
+	rel, ok := s.resolvePath(msg.Payload)
+	if strings.TrimSpace(msg.Payload) == "" {
+		return c.replyError("Missing path, use STAT path")
+	}
+	if !ok {
+		return c.replyError("Invalid path: %s", strings.TrimSpace(msg.Payload))
+	}
+
+	fsPath, err := s.fsPath(rel)
+	if err != nil {
+		return c.replyError("No such file: %s", displayPath(rel))
+	}
+	file, err := os.Open(fsPath)
+	if err != nil {
+		return c.replyError("No such file: %s", displayPath(rel))
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return c.replyError("No such file: %s", displayPath(rel))
+	}
+	modified := info.ModTime().UTC().Format(time.RFC3339)
+
+	switch {
+	case info.IsDir():
+		names, _ := file.Readdirnames(-1)
+		count := 0
+		for _, name := range names {
+			if !s.excluded(path.Join(rel, name)) {
+				count++
+			}
+		}
+		return c.reply(protocol.RespOK, fmt.Sprintf("%s: directory, %d entries, modified %s", displayPath(rel), count, modified))
+	case info.Mode().IsRegular():
+		sum, err := s.checksums.sum(fsPath, file, info)
+		if err != nil {
+			c.log.Error("Failed to hash file", "file", rel, "err", err)
+			return c.replyError("Failed to read file: %s", displayPath(rel))
+		}
+		return c.reply(protocol.RespOK, fmt.Sprintf("%s: file, %d bytes, modified %s, sha256 %s", displayPath(rel), info.Size(), modified, sum))
+	}
+	return c.replyError("No such file: %s", displayPath(rel))
+}
+
+// handleFind searches the whole file root: FIND pattern [page=N]
+// A pattern with a slash is matched against the path, otherwise against the name
+func (s *Server) handleFind(c *client, msg *protocol.Message) error {
+	pattern, page, err := splitPage(msg.Payload)
+	if err != nil {
+		return c.replyError("%v", err)
+	}
+	if pattern == "" {
+		return c.replyError("Missing pattern, use FIND pattern, e.g. FIND *.txt")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return c.replyError("Invalid pattern: %s", pattern)
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var matches []string
+	truncated := false
+	root := os.DirFS(s.cfg.FileRoot)
+	fs.WalkDir(root, ".", func(rel string, entry fs.DirEntry, err error) error {
+		if err != nil || rel == "." {
+			return nil // unreadable parts are skipped, not fatal
+		}
+		if s.excluded(rel) {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+
+		subject := entry.Name()
+		if strings.Contains(pattern, "/") {
+			subject = rel
+		}
+		if matched, _ := path.Match(pattern, subject); !matched {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		if len(matches) == maxFindResults {
+			truncated = true
+			return fs.SkipAll
+		}
+		matches = append(matches, listEntry(rel, info))
+		return nil
+	})
+	sort.Strings(matches)
+
+	what := "Matches for " + pattern
+	if truncated {
+		what += fmt.Sprintf(" (first %d)", maxFindResults)
+	}
+	text, err := s.paginate(matches, page, what)
+	if err != nil {
+		return c.replyError("%v", err)
+	}
+	return c.reply(protocol.RespOK, text)
+}
@@ -0,0 +1,132 @@
+package server_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"socket-tcp/internal/protocol"
+	"socket-tcp/internal/server"
+)
+
+// fileTree writes files below root and gives them all the same mtime
+func fileTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, modified, modified)
+	}
+}
+
+func TestBrowseFiles(t *testing.T) {
+	ts := startServer(t, func(cfg *server.Config) {
+		cfg.ExcludePatterns = []string{"uploads", "*.key", "docs/private"}
+		cfg.ListPageSize = 2
+	})
+	fileTree(t, ts.fileRoot, map[string]string{
+		"a.txt":               "aaa",
+		"b.txt":               "bbbb",
+		"server.key":          "secret",
+		".hidden":             "secret",
+		"docs/c.txt":          "c",
+		"docs/deep/d.log":     "ddddd",
+		"docs/private/e.txt":  "secret",
+		"uploads/user1/f.txt": "secret",
+	})
+
+	tc := ts.dial(t)
+	tc.run([]step{{protocol.CmdLs, "", protocol.RespError, "Not authenticated"}})
+	tc.login("user1", "user123")
+
+	tc.run([]step{
+		// a.txt, b.txt and docs/ are left after the exclusions, two per page
+		{protocol.CmdLs, "", protocol.RespOK, "/: 3 entries, page 1 of 2: a.txt 3 2024-05-01T12:00:00Z | b.txt 4 2024-05-01T12:00:00Z"},
+		{protocol.CmdLs, "page=2", protocol.RespOK, "/: 3 entries, page 2 of 2: docs/ "},
+		{protocol.CmdLs, "page=3", protocol.RespError, "Page 3 out of range"},
+		{protocol.CmdLs, "/docs", protocol.RespOK, "/docs: 2 entries, page 1 of 1: c.txt 1 2024-05-01T12:00:00Z | deep/ "},
+		{protocol.CmdLs, "docs/deep/", protocol.RespOK, "d.log 5"},
+		{protocol.CmdLs, "docs/private", protocol.RespError, "Invalid path"},
+		{protocol.CmdLs, "uploads", protocol.RespError, "Invalid path"},
+		{protocol.CmdLs, "../", protocol.RespError, "Invalid path"},
+		{protocol.CmdLs, "missing", protocol.RespError, "No such directory"},
+		{protocol.CmdLs, "docs page=x", protocol.RespError, "Invalid page"},
+
+		{protocol.CmdStat, "docs", protocol.RespOK, "/docs: directory, 2 entries, modified "},
+		{protocol.CmdStat, "server.key", protocol.RespError, "Invalid path"},
+		{protocol.CmdStat, ".hidden", protocol.RespError, "Invalid path"},
+		{protocol.CmdStat, "nope.txt", protocol.RespError, "No such file"},
+		{protocol.CmdStat, "", protocol.RespError, "Missing path"},
+
+		{protocol.CmdFind, "*.txt", protocol.RespOK, "Matches for *.txt: 3 entries, page 1 of 2: a.txt 3 2024-05-01T12:00:00Z | b.txt 4 "},
+		{protocol.CmdFind, "*.txt page=2", protocol.RespOK, "page 2 of 2: docs/c.txt 1 "},
+		{protocol.CmdFind, "docs/*/*.log", protocol.RespOK, "1 entries, page 1 of 1: docs/deep/d.log 5 "},
+		{protocol.CmdFind, "*.key", protocol.RespOK, "Matches for *.key: no entries"},
+		{protocol.CmdFind, "[", protocol.RespError, "Invalid pattern"},
+
+		// FILE serves paths but nothing excluded
+		{protocol.CmdFile, "docs/private/e.txt", protocol.RespError, "Invalid file name"},
+		{protocol.CmdFile, "uploads/user1/f.txt", protocol.RespError, "Invalid file name"},
+	})
+
+	sum := sha256.Sum256([]byte("ddddd"))
+	tc.run([]step{{protocol.CmdStat, "docs/deep/d.log", protocol.RespOK, fmt.Sprintf("/docs/deep/d.log: file, 5 bytes, modified 2024-05-01T12:00:00Z, sha256 %x", sum)}})
+
+	tc.send(protocol.CmdFile, "docs/deep/d.log")
+	tc.expect(protocol.CmdFile, fmt.Sprintf("5 0 5 %x docs/deep/d.log", sum))
+	var got bytes.Buffer
+	if err := tc.msgHandler.ReadData(&got, 5); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "ddddd" {
+		t.Fatalf("downloaded %q", got.String())
+	}
+}
+
+func TestSymlinksStayInRoot(t *testing.T) {
+	ts := startServer(t)
+	outside := t.TempDir()
+	fileTree(t, outside, map[string]string{"secret.txt": "secret"})
+	fileTree(t, ts.fileRoot, map[string]string{"pub/a.txt": "aaa", "uploads/user1/f.txt": "private"})
+	for link, target := range map[string]string{
+		"out":      outside,
+		"out.txt":  filepath.Join(outside, "secret.txt"),
+		"mine":     filepath.Join(ts.fileRoot, "uploads", "user1"), // excluded by name, not by place
+		"docs":     filepath.Join(ts.fileRoot, "pub"),
+		"docs.txt": filepath.Join(ts.fileRoot, "pub", "a.txt"),
+	} {
+		if err := os.Symlink(target, filepath.Join(ts.fileRoot, link)); err != nil {
+			t.Skipf("no symlinks here: %v", err)
+		}
+	}
+
+	tc := ts.dial(t)
+	tc.login("user1", "user123")
+	tc.run([]step{
+		{protocol.CmdLs, "out", protocol.RespError, "No such directory"},
+		{protocol.CmdLs, "mine", protocol.RespError, "No such directory"},
+		{protocol.CmdStat, "out/secret.txt", protocol.RespError, "No such file"},
+		{protocol.CmdStat, "out.txt", protocol.RespError, "No such file"},
+		{protocol.CmdStat, "mine/f.txt", protocol.RespError, "No such file"},
+		{protocol.CmdFile, "out.txt", protocol.RespError, "File not found"},
+		// links that stay inside are fine
+		{protocol.CmdLs, "docs", protocol.RespOK, "/docs: 1 entries"},
+		{protocol.CmdStat, "docs.txt", protocol.RespOK, "/docs.txt: file, 3 bytes"},
+	})
+
+	ac := ts.api(t)
+	ac.login("user1", "user123")
+	ac.do("GET", "/files/out.txt", nil, http.StatusNotFound, nil)
+	ac.do("GET", "/files/docs.txt", nil, http.StatusOK, nil)
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"socket-tcp/internal/audit"
+	"socket-tcp/internal/auth"
+	"socket-tcp/internal/game"
+	"socket-tcp/internal/protocol"
+)
+
+// routes registers every command the server understands
+func (s *Server) routes() *Router {
+	router := NewRouter()
+	router.Use(
+		requestIDMiddleware,
+		recoverMiddleware,
+		s.loggingMiddleware,
+		s.rateLimitMiddleware(map[string]rateLimit{
+			rateDefault: {rate: s.cfg.CommandRate, burst: s.cfg.CommandBurst},
+			rateAuth:    {rate: s.cfg.AuthRate, burst: s.cfg.AuthBurst},
+		}),
+		authMiddleware,
+		binaryMiddleware,
+		s.sessionMiddleware,
+	)
+
+	router.Handle(Route{Command: protocol.CmdPing, Handler: handlePing, RateClass: rateNone, AnySession: true})
+	router.Handle(Route{Command: protocol.CmdPong, Handler: handlePong, RateClass: rateNone, AnySession: true})
+	router.Handle(Route{Command: protocol.CmdAuth, Handler: s.handleAuth, RateClass: rateAuth, AnySession: true})
+	router.Handle(Route{Command: protocol.CmdQuit, Handler: handleQuit})
+	router.Handle(Route{Command: protocol.CmdHello, Handler: s.handleHello, AnySession: true})
+	router.Handle(Route{Command: protocol.CmdStartTLS, Handler: s.handleStartTLS, AnySession: true})
+	router.Handle(Route{Command: protocol.CmdAPIKey, Handler: s.handleAPIKey, RequiresAuth: true})
+	router.Handle(Route{Command: protocol.CmdMFA, Handler: s.handleMFA, RequiresAuth: true})
+	router.Handle(Route{Command: protocol.CmdSessions, Handler: s.handleSessions, RequiresAuth: true})
+	router.Handle(Route{Command: protocol.CmdRevoke, Handler: s.handleRevoke, RequiresAuth: true})
+	router.Handle(Route{Command: protocol.CmdStartGame, Handler: s.handleStartGame, RequiresAuth: true})
+	router.Handle(Route{Command: protocol.CmdGuess, Handler: s.handleGuess, RequiresAuth: true})
+	router.Handle(Route{Command: protocol.CmdEndGame, Handler: s.handleEndGame, RequiresAuth: true})
+	router.Handle(Route{Command: protocol.CmdFile, Handler: s.handleFile, RequiresAuth: true, Binary: true})
+	router.Handle(Route{Command: protocol.CmdUpload, Handler: s.handleUpload, RequiresAuth: true, Binary: true})
+	router.Handle(Route{Command: protocol.CmdList, Handler: s.handleList, RequiresAuth: true})
+	router.Handle(Route{Command: protocol.CmdLs, Handler: s.handleLs, RequiresAuth: true})
+	router.Handle(Route{Command: protocol.CmdStat, Handler: s.handleStat, RequiresAuth: true})
+	router.Handle(Route{Command: protocol.CmdFind, Handler: s.handleFind, RequiresAuth: true})
+	// DATA checks login and session itself, its bytes must be drained even when it is refused
+	router.Handle(Route{Command: protocol.CmdData, Handler: s.handleData, RateClass: rateNone, AnySession: true})
+
+	return router
+}
+
+// answer heartbeats even before AUTH
+func handlePing(c *client, msg *protocol.Message) error {
+	return c.reply(protocol.CmdPong, msg.Payload)
+}
+
+// nothing to do, the read loop already reset the missed counter
+func handlePong(c *client, msg *protocol.Message) error {
+	return nil
+}
+
+func (s *Server) handleAuth(c *client, msg *protocol.Message) error {
+	if c.authenticated {
+		return c.replyError("Already authenticated")
+	}
+	if s.cfg.RequireTLS && !usesTLS(c.msgHandler) {
+		if s.canStartTLS(c.msgHandler) {
+			return c.replyError("TLS is required, send STARTTLS before AUTH")
+		}
+		return c.replyError("TLS is required and this transport cannot use it, connect over TCP")
+	}
+
+	parts := strings.SplitN(msg.Payload, " ", 2)
+	if len(parts) != 2 {
+		return c.replyError("Invalid auth format")
+	}
+	// AUTH TOKEN <token> logs in with an access token or an API key instead of a password
+	// AUTH MFA <code> is the second step after the password of a user with MFA
+	withToken := parts[0] == authToken
+	username, password := parts[0], parts[1]
+
+	var sessionID int
+	var err error
+	switch username {
+	case authToken:
+		username = ""
+		sessionID, err = s.auth.AuthenticateToken(strings.TrimSpace(password))
+	case authMFA:
+		username = ""
+		if c.mfa != nil {
+			username = c.mfa.username
+		}
+		sessionID, err = s.authMFA(c, password)
+	default:
+		c.mfa = nil // a new password starts over
+		sessionID, err = s.auth.AuthenticateUser(username, password)
+		if errors.Is(err, auth.ErrMFARequired) {
+			c.mfa = &pendingMFA{username: username, expires: time.Now().Add(mfaTimeout)}
+			c.log.Info("Password accepted, waiting for the MFA code", "user", username)
+			return c.reply(protocol.RespOK, "MFA required, password accepted. Send AUTH MFA <code> with the code of your authenticator app or a recovery code")
+		}
+	}
+	if err != nil {
+		c.log.Info("Authentication failed", "user", username, "token", withToken, "err", err)
+		s.metrics.authFailures.Inc()
+		s.audit.Record(audit.EventLoginFailed, "user", username, "token", withToken, "remote", c.remote, "reason", err.Error())
+		return c.replyError("Authentication Failed: %v", err)
+	}
+
+	c.sessionID = sessionID
+	c.user = s.auth.User(sessionID)
+	c.authenticated = true
+	s.auth.SetRemote(sessionID, c.remote)
+	s.register(c)
+	username = c.user.Username
+	c.log = c.log.With("session", sessionID, "user", username)
+	s.audit.Record(audit.EventLogin, "user", username, "session", sessionID, "token", withToken, "remote", c.remote)
+	c.log.Info("Client authenticated", "token", withToken)
+
+	text := fmt.Sprintf("Authentication Successful. Your session ID is %d", sessionID)
+	if withToken {
+		text += ", logged in as " + username
+	} else if token, expires, err := s.auth.IssueToken(username); err != nil {
+		c.log.Error("Failed to issue token", "err", err)
+	} else {
+		// a password login also gets an access token, for AUTH TOKEN on the next connection
+		text += fmt.Sprintf(", token %s valid until %s", token, expires.UTC().Format(time.RFC3339))
+	}
+	if state, resumed := s.resumeGame(sessionID, username); resumed {
+		text += fmt.Sprintf(", game resumed after %d guesses", state.GuessCount)
+	}
+	return c.reply(protocol.RespOK, text)
+}
+
+// hello is what the server offers on a transport, sent as the first line of every connection
+func (s *Server) hello(msgHandler protocol.MessageConn) protocol.Hello {
+	hello := protocol.Hello{Version: protocol.ProtocolVersion, Binary: true, RequestIDs: true}
+	if _, ok := msgHandler.(protocol.Compressor); ok {
+		hello.Codecs = s.cfg.Compression
+	}
+	hello.StartTLS = s.canStartTLS(msgHandler)
+	return hello
+}
+
+// canStartTLS tells whether STARTTLS would work on this connection now
+func (s *Server) canStartTLS(msgHandler protocol.MessageConn) bool {
+	upgrader, ok := msgHandler.(protocol.TLSUpgrader)
+	return ok && s.cfg.TLSConfig != nil && !upgrader.TLS()
+}
+
+func usesTLS(msgHandler protocol.MessageConn) bool {
+	upgrader, ok := msgHandler.(protocol.TLSUpgrader)
+	return ok && upgrader.TLS()
+}
+
+// handleStartTLS upgrades the connection, the client starts its handshake once it has the OK
+// Inside TLS the connection starts over: a new HELLO and features agreed again
+func (s *Server) handleStartTLS(c *client, msg *protocol.Message) error {
+	switch {
+	case usesTLS(c.msgHandler):
+		return c.replyError("Already using TLS")
+	case !s.canStartTLS(c.msgHandler):
+		return c.replyError("STARTTLS is not available")
+	case c.authenticated:
+		return c.replyError("STARTTLS must come before AUTH")
+	}
+
+	if err := c.reply(protocol.RespOK, fmt.Sprintf("%s Ready, start the TLS handshake", protocol.CmdStartTLS)); err != nil {
+		return err
+	}
+	if err := c.msgHandler.(protocol.TLSUpgrader).StartTLS(s.cfg.TLSConfig, true); err != nil {
+		c.log.Warn("TLS handshake failed", "err", err)
+		return errCloseConnection // half a handshake leaves nothing usable
+	}
+	c.features, c.helloDone = protocol.LegacyHello(), false
+	c.log.Info("Connection upgraded to TLS")
+	return c.msgHandler.SendMessage(0, protocol.CmdHello, s.hello(c.msgHandler).String())
+}
+
+// handleHello agrees on the features both sides have, a version we cannot speak ends the connection
+// The answer still goes out uncompressed, compression starts with the next message
+func (s *Server) handleHello(c *client, msg *protocol.Message) error {
+	if c.helloDone {
+		return c.replyError("HELLO was already done")
+	}
+	peer, err := protocol.ParseHello(msg.Payload)
+	if err != nil {
+		return c.replyError("%v, use HELLO <version> [feature ...]", err)
+	}
+	if err := protocol.CheckVersion(peer.Version); err != nil {
+		c.log.Info("Incompatible protocol version", "version", peer.Version)
+		c.replyError("%v", err)
+		return errCloseConnection
+	}
+
+	c.features = s.hello(c.msgHandler).Negotiate(peer)
+	c.helloDone = true
+	if err := c.reply(protocol.RespOK, fmt.Sprintf("%s %s", protocol.CmdHello, c.features)); err != nil {
+		return err
+	}
+	if compressor, ok := c.msgHandler.(protocol.Compressor); ok {
+		compressor.SetCompression(c.features.Codec(), s.cfg.CompressThreshold)
+	}
+	c.log.Debug("Handshake done", "features", c.features.String())
+	return nil
+}
+
+func handleQuit(c *client, msg *protocol.Message) error {
+	if err := c.reply(protocol.RespBye, "Goodbye!"); err != nil {
+		c.log.Warn("Failed to send goodbye message", "err", err)
+	}
+	c.log.Info("Client quit")
+	return errCloseConnection
+}
+
+// handleStartGame takes the options of game.ParseOptions, e.g. "START hotcold range=1-500 guesses=10"
+func (s *Server) handleStartGame(c *client, msg *protocol.Message) error {
+	opts, err := game.ParseOptions(msg.Payload)
+	if err != nil {
+		return c.replyError("%v", err)
+	}
+	text, err := s.games.StartGame(c.sessionID, opts)
+	if err != nil {
+		return c.replyError("%v", err)
+	}
+	return c.reply(protocol.RespOK, text)
+}
+
+// handleGuess leaves reading the guess to the game's variant, a number or a code
+func (s *Server) handleGuess(c *client, msg *protocol.Message) error {
+	text, outcome, err := s.games.MakeGuess(c.sessionID, msg.Payload)
+	if err != nil {
+		return c.replyError("%v", err)
+	}
+	if outcome != game.Playing {
+		s.metrics.gameOutcomes.Inc(outcome.String())
+	}
+	return c.reply(protocol.RespOK, text)
+}
+
+func (s *Server) handleEndGame(c *client, msg *protocol.Message) error {
+	text, err := s.games.EndGame(c.sessionID)
+	if err != nil {
+		return c.replyError("%v", err)
+	}
+	s.metrics.gameOutcomes.Inc("ended")
+	return c.reply(protocol.RespOK, text)
+}
+
+// handleFile sends "FILE <n> <offset> <total> <sha256> <name>" followed by n raw bytes
+// "FILE name bytes=<range>" asks for part of the file, e.g. to resume a broken download
+func (s *Server) handleFile(c *client, msg *protocol.Message) error {
+	payload, rangeSpec := strings.TrimSpace(msg.Payload), ""
+	if i := strings.LastIndex(payload, " "); i >= 0 && strings.HasPrefix(payload[i+1:], "bytes=") {
+		payload, rangeSpec = payload[:i], payload[i+1:]
+	}
+	if payload == "" {
+		return c.replyError("Missing file name, use FILE filename")
+	}
+	name, ok := s.resolvePath(payload)
+	if !ok || name == "" {
+		return c.replyError("Invalid file name")
+	}
+
+	path, err := s.fsPath(name)
+	if err != nil {
+		return c.replyError("File not found: %s", name)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return c.replyError("File not found: %s", name)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return c.replyError("File not found: %s", name)
+	}
+
+	offset, n := int64(0), info.Size()
+	if rangeSpec != "" {
+		if offset, n, err = parseRange(rangeSpec, info.Size()); err != nil {
+			return c.replyError("%v", err)
+		}
+	}
+	sum, err := s.checksums.sum(path, file, info)
+	if err != nil {
+		c.log.Error("Failed to hash file", "file", name, "err", err)
+		return c.replyError("Failed to read file: %s", name)
+	}
+
+	header := fmt.Sprintf("%d %d %d %s %s", n, offset, info.Size(), sum, name)
+	if err := c.msgHandler.SendData(c.sessionID, protocol.CmdFile, header, io.NewSectionReader(file, offset, n), n); err != nil {
+		return err // the stream is broken halfway, the connection cannot be reused
+	}
+	s.audit.Record(audit.EventFileDownload, "user", c.user.Username, "session", c.sessionID, "file", name, "size", n, "offset", offset)
+	c.log.Info("File sent", "file", name, "size", n, "offset", offset)
+	return nil
+}
+
+// cleanFileName only accepts a plain name, no paths or hidden files (uploads, user directories)
+func cleanFileName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("Missing file name")
+	}
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return "", errors.New("Invalid file name")
+	}
+	return name, nil
+}
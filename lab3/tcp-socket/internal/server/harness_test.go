@@ -0,0 +1,207 @@
+package server_test
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"socket-tcp/internal/auth"
+	"socket-tcp/internal/model"
+	"socket-tcp/internal/protocol"
+	"socket-tcp/internal/rudp"
+	"socket-tcp/internal/server"
+	"socket-tcp/internal/websocket"
+)
+
+// testUsers are the accounts every test server knows
+func testUsers() []*model.User {
+	return []*model.User{
+		{Username: "admin", Password: auth.EncryptPassword("123"), Role: model.RoleAdmin},
+		{Username: "user1", Password: auth.EncryptPassword("user123")},
+	}
+}
+
+// testServer is a running server on 127.0.0.1:0 plus what tests want to inspect
+type testServer struct {
+	*server.Server
+	auth     *auth.AuthManager
+	fileRoot string
+}
+
+// startServer runs a server for the duration of the test, configure can tweak the defaults
+func startServer(t *testing.T, configure ...func(*server.Config)) *testServer {
+	t.Helper()
+	return runServer(t, false, configure)
+}
+
+// startUDPServer is startServer for cmd/udpserver, connect with dialUDP
+func startUDPServer(t *testing.T, configure ...func(*server.Config)) *testServer {
+	t.Helper()
+	return runServer(t, true, configure)
+}
+
+func runServer(t *testing.T, udp bool, configure []func(*server.Config)) *testServer {
+	t.Helper()
+
+	cfg := server.DefaultConfig()
+	cfg.Addr = "127.0.0.1:0"
+	cfg.FileRoot = t.TempDir()
+	cfg.CommandRate = 0 // tests send faster than any human
+	cfg.AuthRate = 0
+	cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	for _, fn := range configure {
+		fn(&cfg)
+	}
+
+	authManager, err := auth.NewAuthManager(testUsers())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := server.New(cfg, authManager)
+	listen, serve := srv.Listen, srv.Serve
+	if udp {
+		listen, serve = srv.ListenUDP, srv.ServeUDP
+	}
+	if err := listen(); err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- serve() }()
+	t.Cleanup(func() {
+		srv.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve returned %v", err)
+		}
+	})
+
+	return &testServer{Server: srv, auth: authManager, fileRoot: cfg.FileRoot}
+}
+
+// testClient speaks the protocol over a real TCP connection, or UDP/WebSocket after dialUDP/dialWS
+type testClient struct {
+	t          *testing.T
+	conn       net.Conn // nil over UDP and WebSocket
+	msgHandler protocol.MessageConn
+	sessionID  int
+}
+
+// dial connects and consumes the server's HELLO, the client never answers it and keeps the legacy features
+func (ts *testServer) dial(t *testing.T) *testClient {
+	t.Helper()
+	tc := ts.dialRaw(t)
+	tc.expect(protocol.CmdHello, "1 binary compress=gzip,deflate reqid")
+	return tc
+}
+
+// dialRaw connects without reading anything
+func (ts *testServer) dialRaw(t *testing.T) *testClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", ts.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	msgHandler := protocol.NewMessageHandler(conn)
+	msgHandler.SetReadTimeout(5 * time.Second) // a hung test fails instead of blocking forever
+
+	return &testClient{t: t, conn: conn, msgHandler: msgHandler}
+}
+
+// dialUDP associates over rudp and consumes the server's HELLO
+func (ts *testServer) dialUDP(t *testing.T, cfg rudp.Config) *testClient {
+	t.Helper()
+
+	conn, err := rudp.Dial(ts.Addr().String(), cfg)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	msgHandler := rudp.NewMessageConn(conn)
+	msgHandler.SetReadTimeout(5 * time.Second)
+
+	tc := &testClient{t: t, msgHandler: msgHandler}
+	tc.expect(protocol.CmdHello, "1 binary reqid")
+	return tc
+}
+
+// dialWS connects like a browser through the WebSocket gateway and consumes the server's HELLO
+func (ts *testServer) dialWS(t *testing.T) *testClient {
+	t.Helper()
+
+	gateway := httptest.NewServer(ts.WebSocketHandler())
+	t.Cleanup(gateway.Close)
+
+	conn, err := websocket.Dial(strings.Replace(gateway.URL, "http://", "ws://", 1)+"/ws", gateway.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	msgHandler := websocket.NewMessageConn(conn)
+	msgHandler.SetReadTimeout(5 * time.Second)
+
+	tc := &testClient{t: t, msgHandler: msgHandler}
+	tc.expect(protocol.CmdHello, "1 binary reqid")
+	return tc
+}
+
+func (tc *testClient) send(command protocol.CommandType, payload string) {
+	tc.t.Helper()
+	if err := tc.msgHandler.SendMessage(tc.sessionID, command, payload); err != nil {
+		tc.t.Fatalf("send %s: %v", command, err)
+	}
+}
+
+// expect reads the next message and checks its command and that the payload contains a substring
+func (tc *testClient) expect(command protocol.CommandType, contains string) *protocol.Message {
+	tc.t.Helper()
+
+	msg, err := tc.msgHandler.ReadMessage()
+	if err != nil {
+		tc.t.Fatalf("waiting for %s %q: %v", command, contains, err)
+	}
+	if msg.Command != command || !strings.Contains(msg.Payload, contains) {
+		tc.t.Fatalf("got %s %q, want %s containing %q", msg.Command, msg.Payload, command, contains)
+	}
+	return msg
+}
+
+// expectClosed checks the server hung up
+func (tc *testClient) expectClosed() {
+	tc.t.Helper()
+	if msg, err := tc.msgHandler.ReadMessage(); err == nil {
+		tc.t.Fatalf("expected the connection to be closed, got %s %q", msg.Command, msg.Payload)
+	}
+}
+
+func (tc *testClient) login(username, password string) {
+	tc.t.Helper()
+	tc.send(protocol.CmdAuth, username+" "+password)
+	msg := tc.expect(protocol.RespOK, "Authentication Successful")
+	tc.sessionID = msg.SessionID
+}
+
+// step is one line of a scripted conversation
+type step struct {
+	command  protocol.CommandType
+	payload  string
+	want     protocol.CommandType
+	contains string
+}
+
+// run plays a script, each step is a request and the response it must get
+func (tc *testClient) run(script []step) {
+	tc.t.Helper()
+	for _, st := range script {
+		tc.send(st.command, st.payload)
+		tc.expect(st.want, st.contains)
+	}
+}
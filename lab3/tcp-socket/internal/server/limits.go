@@ -0,0 +1,176 @@
+package server
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// connLimiter caps how many connections the server holds at once, in total and per client IP
+type connLimiter struct {
+	maxTotal int
+	maxPerIP int
+	total    int
+	perIP    map[string]int // IP -> open connections
+	mu       sync.Mutex
+}
+
+func newConnLimiter(maxTotal, maxPerIP int) *connLimiter {
+	return &connLimiter{
+		maxTotal: maxTotal,
+		maxPerIP: maxPerIP,
+		perIP:    make(map[string]int),
+	}
+}
+
+// acquire reserves a slot for the address, returns a reason when the client must be turned away
+func (cl *connLimiter) acquire(addr net.Addr) (string, bool) {
+	ip := hostOf(addr)
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.maxTotal > 0 && cl.total >= cl.maxTotal {
+		return "Server busy, please try again later", false
+	}
+	if cl.maxPerIP > 0 && cl.perIP[ip] >= cl.maxPerIP {
+		return "Too many connections from your address", false
+	}
+	cl.total++
+	cl.perIP[ip]++
+	return "", true
+}
+
+// release gives the slot back when the connection is closed
+func (cl *connLimiter) release(addr net.Addr) {
+	ip := hostOf(addr)
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.total--
+	if cl.perIP[ip]--; cl.perIP[ip] <= 0 {
+		delete(cl.perIP, ip)
+	}
+}
+
+// hostOf strips the port so all connections from one machine share a counter
+func hostOf(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// rateLimit configures the buckets of one rate-limit class
+type rateLimit struct {
+	rate  float64
+	burst int
+}
+
+// tokenBucket allows `rate` commands per second with bursts up to `burst`
+// One bucket belongs to one connection, so there is no locking
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst), // start full so a fresh client is not throttled
+		last:   time.Now(),
+	}
+}
+
+// allow takes one token if there is one
+func (tb *tokenBucket) allow() bool {
+	if tb.rate <= 0 {
+		return true // rate limiting disabled
+	}
+
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+// full reports whether the bucket has refilled by now, then it is no different from a new one
+func (tb *tokenBucket) full(now time.Time) bool {
+	return tb.tokens+now.Sub(tb.last).Seconds()*tb.rate >= tb.burst
+}
+
+// hostLimiter keeps one token bucket per client host, for requests that have no connection
+// to hang the bucket on (REST logins)
+type hostLimiter struct {
+	limit     rateLimit
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	overflow  *tokenBucket // shared by new hosts while every tracked host is still busy
+	lastSweep time.Time
+}
+
+// at most this many hosts get a bucket of their own, a flood of addresses must not grow the map forever
+const maxLimitedHosts = 10000
+
+// a full map is swept at most this often, not on every new host
+const hostSweepInterval = time.Second
+
+func newHostLimiter(rate float64, burst int) *hostLimiter {
+	return &hostLimiter{
+		limit:    rateLimit{rate: rate, burst: burst},
+		buckets:  make(map[string]*tokenBucket),
+		overflow: newTokenBucket(rate, burst),
+	}
+}
+
+func (hl *hostLimiter) allow(host string) bool {
+	hl.mu.Lock()
+	defer hl.mu.Unlock()
+
+	bucket, ok := hl.buckets[host]
+	if !ok {
+		now := time.Now()
+		if len(hl.buckets) >= maxLimitedHosts && now.Sub(hl.lastSweep) >= hostSweepInterval {
+			hl.sweep(now)
+		}
+		if len(hl.buckets) >= maxLimitedHosts {
+			// dropping a busy bucket would give its host a fresh burst, the newcomers share one instead
+			return hl.overflow.allow()
+		}
+		bucket = newTokenBucket(hl.limit.rate, hl.limit.burst)
+		hl.buckets[host] = bucket
+	}
+	return bucket.allow()
+}
+
+// sweep forgets the hosts whose buckets have refilled, nothing is lost by starting them over
+func (hl *hostLimiter) sweep(now time.Time) {
+	hl.lastSweep = now
+	for host, bucket := range hl.buckets {
+		if bucket.full(now) {
+			delete(hl.buckets, host)
+		}
+	}
+}
+
+// hostOfRemote is hostOf for the "host:port" strings net/http hands out
+func hostOfRemote(remote string) string {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return remote
+	}
+	return host
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"strings"
+
+	"socket-tcp/internal/protocol"
+)
+
+// redactPayload hides credentials before a payload is written to any log
+func redactPayload(command protocol.CommandType, payload string) string {
+	switch command {
+	case protocol.CmdAuth, protocol.CmdMFA:
+		// keep the username or subcommand so failed logins can still be traced,
+		// what follows is a password, a key or a TOTP or recovery code
+		first, _, found := strings.Cut(payload, " ")
+		if !found {
+			return payload
+		}
+		return first + " [REDACTED]"
+	}
+	return payload
+}
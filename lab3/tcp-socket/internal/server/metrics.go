@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"socket-tcp/internal/auth"
+	"socket-tcp/internal/metrics"
+	"socket-tcp/internal/protocol"
+)
+
+// serverMetrics are the counters of one Server, each server has its own registry
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	activeConns     *metrics.Gauge
+	rejectedConns   *metrics.Counter
+	commandsTotal   *metrics.CounterVec
+	throttledTotal  *metrics.Counter
+	authFailures    *metrics.Counter
+	bytesReceived   *metrics.Counter
+	bytesSent       *metrics.Counter
+	gameOutcomes    *metrics.CounterVec
+	handlerDuration *metrics.HistogramVec
+}
+
+func newServerMetrics(authManager *auth.AuthManager) *serverMetrics {
+	registry := metrics.NewRegistry()
+	m := &serverMetrics{
+		registry:        registry,
+		activeConns:     registry.NewGauge("tcp_server_active_connections", "Connections currently open."),
+		rejectedConns:   registry.NewCounter("tcp_server_rejected_connections_total", "Connections turned away by connection limits."),
+		commandsTotal:   registry.NewCounterVec("tcp_server_commands_total", "Commands received, by command.", "command"),
+		throttledTotal:  registry.NewCounter("tcp_server_throttled_commands_total", "Commands rejected by the rate limiter."),
+		authFailures:    registry.NewCounter("tcp_server_auth_failures_total", "Failed AUTH attempts."),
+		bytesReceived:   registry.NewCounter("tcp_server_received_bytes_total", "Bytes read from clients."),
+		bytesSent:       registry.NewCounter("tcp_server_sent_bytes_total", "Bytes written to clients."),
+		gameOutcomes:    registry.NewCounterVec("tcp_server_game_outcomes_total", "Finished guessing games, by outcome.", "outcome"),
+		handlerDuration: registry.NewHistogramVec("tcp_server_handler_duration_seconds", "Time spent handling a command.", "command", metrics.DefaultBuckets),
+	}
+	registry.NewGaugeFunc("tcp_server_active_sessions", "Authenticated sessions.", func() float64 {
+		return float64(authManager.SessionCount())
+	})
+	return m
+}
+
+// commandLabel keeps the label set small, anything a client makes up is "unknown"
+func commandLabel(command protocol.CommandType) string {
+	switch command {
+	case protocol.CmdAuth, protocol.CmdFile, protocol.CmdGuess, protocol.CmdQuit,
+		protocol.CmdStartGame, protocol.CmdEndGame, protocol.CmdPing, protocol.CmdPong,
+		protocol.CmdUpload, protocol.CmdData, protocol.CmdList,
+		protocol.CmdLs, protocol.CmdStat, protocol.CmdFind, protocol.CmdHello, protocol.CmdStartTLS, protocol.CmdAPIKey, protocol.CmdMFA,
+		protocol.CmdSessions, protocol.CmdRevoke:
+		return string(command)
+	}
+	return "unknown"
+}
+
+// countingConn counts bytes in both directions for the metrics
+type countingConn struct {
+	net.Conn
+	metrics *serverMetrics
+}
+
+func (c countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.metrics.bytesReceived.Add(uint64(n))
+	return n, err
+}
+
+func (c countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.metrics.bytesSent.Add(uint64(n))
+	return n, err
+}
+
+// AdminHandler serves /metrics, /healthz, /readyz and /sessions for the admin HTTP port
+// Nothing here asks for a login and /sessions names every user and address, keep the port private
+func (s *Server) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := s.metrics.registry.WriteText(w); err != nil {
+			s.log.Debug("Failed to write metrics", "err", err)
+		}
+	})
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !s.ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ready\n"))
+	})
+	mux.HandleFunc("/sessions", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.auth.Sessions())
+	})
+	return mux
+}
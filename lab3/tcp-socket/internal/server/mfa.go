@@ -0,0 +1,123 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"socket-tcp/internal/audit"
+	"socket-tcp/internal/protocol"
+)
+
+// authMFA is the AUTH "username" of the second login step: AUTH MFA <code>
+const authMFA = "MFA"
+
+const (
+	mfaTimeout  = 2 * time.Minute // from the right password to the code
+	maxMFATries = 3               // wrong codes before the password is needed again
+)
+
+var errNoMFALogin = errors.New("No login waiting for a code, send AUTH username password first")
+
+// pendingMFA is a login whose password was right, it becomes a session with AUTH MFA <code>
+type pendingMFA struct {
+	username string
+	tries    int
+	expires  time.Time
+}
+
+// authMFA finishes the login started with the password, a recovery code spent is saved right away
+func (s *Server) authMFA(c *client, code string) (int, error) {
+	pending := c.mfa
+	if pending == nil {
+		return 0, errNoMFALogin
+	}
+	if time.Now().After(pending.expires) {
+		c.mfa = nil
+		return 0, errors.New("Code not sent in time, send AUTH username password again")
+	}
+
+	sessionID, usedRecovery, err := s.auth.AuthenticateMFA(pending.username, code)
+	if err != nil {
+		if pending.tries++; pending.tries >= maxMFATries {
+			c.mfa = nil
+			return 0, fmt.Errorf("%w, too many attempts, send AUTH username password again", err)
+		}
+		return 0, err
+	}
+	c.mfa = nil
+
+	if usedRecovery {
+		_, left, _ := s.auth.MFAStatus(pending.username)
+		s.audit.Record(audit.EventRecoveryCodeUsed, "user", pending.username, "session", sessionID, "remote", c.remote, "left", left)
+		c.log.Info("Recovery code used", "user", pending.username, "left", left)
+		if err := s.saveUsers(); err != nil {
+			c.log.Error("Failed to save users", "err", err) // the code works again after a restart
+		}
+	}
+	return sessionID, nil
+}
+
+// handleMFA manages the user's MFA: MFA STATUS | SETUP | ENABLE <code> | DISABLE <code> | CODES <code>
+// Everything but STATUS and SETUP needs a code from the app, or for DISABLE and CODES a recovery code
+func (s *Server) handleMFA(c *client, msg *protocol.Message) error {
+	action, code, _ := strings.Cut(strings.TrimSpace(msg.Payload), " ")
+	username := c.user.Username
+
+	switch strings.ToUpper(action) {
+	case "", "STATUS":
+		enabled, left, err := s.auth.MFAStatus(username)
+		if err != nil {
+			return c.replyError("%v", err)
+		}
+		if !enabled {
+			return c.reply(protocol.RespOK, "MFA disabled, turn it on with MFA SETUP")
+		}
+		return c.reply(protocol.RespOK, fmt.Sprintf("MFA enabled, %d recovery codes left", left))
+
+	case "SETUP":
+		secret, uri, err := s.auth.BeginMFA(username)
+		if err != nil {
+			return c.replyError("%v", err)
+		}
+		return c.reply(protocol.RespOK, fmt.Sprintf("MFA secret %s, add it to your authenticator app or scan %s as a QR code, then send MFA ENABLE <code>", secret, uri))
+
+	case "ENABLE":
+		codes, err := s.auth.EnableMFA(username, code)
+		if err != nil {
+			return c.replyError("%v", err)
+		}
+		s.audit.Record(audit.EventMFAEnabled, "user", username, "session", c.sessionID)
+		c.log.Info("MFA enabled")
+		if err := s.saveUsers(); err != nil {
+			return c.replyError("MFA enabled but not saved, it is off again after a restart: %v", err)
+		}
+		return c.reply(protocol.RespOK, "MFA enabled, your API keys are revoked and older access tokens no longer work. "+
+			"Keep these recovery codes, each works once and they are shown only now: "+strings.Join(codes, " "))
+
+	case "DISABLE":
+		if err := s.auth.DisableMFA(username, code); err != nil {
+			return c.replyError("%v", err)
+		}
+		s.audit.Record(audit.EventMFADisabled, "user", username, "session", c.sessionID)
+		c.log.Info("MFA disabled")
+		if err := s.saveUsers(); err != nil {
+			return c.replyError("MFA disabled but not saved, it is on again after a restart: %v", err)
+		}
+		return c.reply(protocol.RespOK, "MFA disabled")
+
+	case "CODES":
+		codes, err := s.auth.NewRecoveryCodes(username, code)
+		if err != nil {
+			return c.replyError("%v", err)
+		}
+		s.audit.Record(audit.EventRecoveryCodesRenewed, "user", username, "session", c.sessionID)
+		c.log.Info("Recovery codes renewed")
+		if err := s.saveUsers(); err != nil {
+			return c.replyError("New recovery codes not saved, the old ones work again after a restart: %v", err)
+		}
+		return c.reply(protocol.RespOK, fmt.Sprintf("New recovery codes, the old ones no longer work: %s", strings.Join(codes, " ")))
+	}
+	return c.replyError("Unknown action, use MFA STATUS, MFA SETUP, MFA ENABLE <code>, MFA DISABLE <code> or MFA CODES <code>")
+}
@@ -0,0 +1,180 @@
+package server_test
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"socket-tcp/internal/auth"
+	"socket-tcp/internal/protocol"
+	"socket-tcp/internal/server"
+)
+
+// totp is what the authenticator app would show, periods ahead of now
+func totp(t *testing.T, secret string, periods int) string {
+	t.Helper()
+	code, err := auth.TOTPCode(secret, time.Now().Add(time.Duration(periods)*30*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// enableMFA runs the enrollment for a logged in client and returns the secret and recovery codes
+func enableMFA(t *testing.T, tc *testClient) (string, []string) {
+	t.Helper()
+	tc.send(protocol.CmdMFA, "SETUP")
+	msg := tc.expect(protocol.RespOK, "otpauth://totp/socket-tcp:")
+	secret, _, _ := strings.Cut(strings.TrimPrefix(msg.Payload, "MFA secret "), ",")
+
+	tc.run([]step{{protocol.CmdMFA, "ENABLE 000000x", protocol.RespError, "Invalid code"}})
+	tc.send(protocol.CmdMFA, "ENABLE "+totp(t, secret, 0))
+	msg = tc.expect(protocol.RespOK, "MFA enabled")
+	_, list, _ := strings.Cut(msg.Payload, ": ")
+	codes := strings.Fields(list)
+	if len(codes) != auth.RecoveryCodeCount {
+		t.Fatalf("want %d recovery codes, got %q", auth.RecoveryCodeCount, msg.Payload)
+	}
+	return secret, codes
+}
+
+func TestMFA(t *testing.T) {
+	ts := startServer(t)
+	tc := ts.dial(t)
+	tc.login("admin", "123")
+	tc.run([]step{
+		{protocol.CmdMFA, "", protocol.RespOK, "MFA disabled"},
+		{protocol.CmdMFA, "ENABLE 123456", protocol.RespError, "No MFA setup in progress"},
+		{protocol.CmdMFA, "DISABLE 123456", protocol.RespError, "MFA is not enabled"},
+	})
+	secret, codes := enableMFA(t, tc)
+	tc.run([]step{
+		{protocol.CmdMFA, "STATUS", protocol.RespOK, "MFA enabled, 10 recovery codes left"},
+		{protocol.CmdMFA, "SETUP", protocol.RespError, "MFA is already enabled"},
+	})
+	stored := ts.auth.Users()[0]
+	if stored.Username != "admin" || stored.TOTPSecret != secret || strings.Contains(strings.Join(stored.RecoveryCodes, ""), codes[0]) {
+		t.Fatalf("recovery codes must be stored hashed, got %+v", stored)
+	}
+
+	// the password alone is not enough any more
+	login := ts.dial(t)
+	login.run([]step{
+		{protocol.CmdAuth, "MFA 123456", protocol.RespError, "No login waiting for a code"},
+		{protocol.CmdAuth, "admin 123", protocol.RespOK, "MFA required"},
+		{protocol.CmdStartGame, "", protocol.RespError, "Not authenticated"},
+		{protocol.CmdAuth, "MFA 000000", protocol.RespError, "Invalid code"},
+	})
+	code := totp(t, secret, 1) // the current one was spent by ENABLE
+	login.send(protocol.CmdAuth, "MFA "+code)
+	msg := login.expect(protocol.RespOK, "Authentication Successful")
+	login.sessionID = msg.SessionID
+	login.run([]step{{protocol.CmdStartGame, "", protocol.RespOK, "Game started"}})
+
+	// a code seen on the wire cannot be used again
+	replay := ts.dial(t)
+	replay.run([]step{
+		{protocol.CmdAuth, "admin 123", protocol.RespOK, "MFA required"},
+		{protocol.CmdAuth, "MFA " + code, protocol.RespError, "Invalid code"},
+	})
+
+	// recovery codes work once, in any case and without the dash
+	recovery := ts.dial(t)
+	recovery.run([]step{
+		{protocol.CmdAuth, "admin 123", protocol.RespOK, "MFA required"},
+		{protocol.CmdAuth, "MFA " + strings.ToUpper(strings.ReplaceAll(codes[0], "-", "")), protocol.RespOK, "Authentication Successful"},
+	})
+	ts.dial(t).run([]step{
+		{protocol.CmdAuth, "admin 123", protocol.RespOK, "MFA required"},
+		{protocol.CmdAuth, "MFA " + codes[0], protocol.RespError, "Invalid code"},
+		{protocol.CmdAuth, "MFA 1", protocol.RespError, "Invalid code"},
+		{protocol.CmdAuth, "MFA 2", protocol.RespError, "too many attempts"},
+		{protocol.CmdAuth, "MFA " + codes[1], protocol.RespError, "No login waiting for a code"},
+	})
+	tc.run([]step{{protocol.CmdMFA, "STATUS", protocol.RespOK, "9 recovery codes left"}})
+
+	// REST logins send the code along with the password
+	ac := ts.api(t)
+	ac.do("POST", "/login", map[string]string{"username": "admin", "password": "123"}, http.StatusUnauthorized, nil)
+	ac.do("POST", "/login", map[string]string{"username": "admin", "password": "123", "code": codes[1]}, http.StatusOK, nil)
+
+	tc.send(protocol.CmdMFA, "CODES "+codes[2])
+	msg = tc.expect(protocol.RespOK, "New recovery codes")
+	fresh := strings.Fields(strings.SplitN(msg.Payload, ": ", 2)[1])
+	tc.run([]step{
+		{protocol.CmdMFA, "DISABLE " + codes[3], protocol.RespError, "Invalid code"},
+		{protocol.CmdMFA, "DISABLE " + fresh[0], protocol.RespOK, "MFA disabled"},
+	})
+	ts.dial(t).login("admin", "123")
+}
+
+// logBuffer collects the server log, connections write to it concurrently
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestMFACodesNotLogged(t *testing.T) {
+	var logged logBuffer
+	ts := startServer(t, func(cfg *server.Config) {
+		cfg.Logger = slog.New(slog.NewTextHandler(&logged, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	})
+	tc := ts.dial(t)
+	tc.login("user1", "user123")
+	secret, codes := enableMFA(t, tc)
+	tc.send(protocol.CmdMFA, "CODES "+codes[0])
+	msg := tc.expect(protocol.RespOK, "New recovery codes")
+	fresh := strings.Fields(strings.SplitN(msg.Payload, ": ", 2)[1])
+	tc.run([]step{{protocol.CmdMFA, "DISABLE " + fresh[0], protocol.RespOK, "MFA disabled"}})
+
+	log := logged.String()
+	if !strings.Contains(log, "payload=\"ENABLE [REDACTED]\"") {
+		t.Fatalf("MFA commands are not logged redacted:\n%s", log)
+	}
+	for _, secretText := range []string{"user123", totp(t, secret, 0), codes[0], fresh[0]} {
+		if strings.Contains(log, secretText) {
+			t.Errorf("log contains %q", secretText)
+		}
+	}
+}
+
+func TestMFARevokesTokensAndKeys(t *testing.T) {
+	ts := startServer(t)
+	tc := ts.dial(t)
+	token := loginToken(t, tc, "user1", "user123")
+	tc.send(protocol.CmdAPIKey, "CREATE script")
+	msg := tc.expect(protocol.RespOK, "AUTH TOKEN sk_")
+	_, rest, _ := strings.Cut(msg.Payload, "AUTH TOKEN ")
+	key, _, _ := strings.Cut(rest, " ")
+
+	// whoever logged in with the password before MFA must not keep a way in
+	enableMFA(t, tc)
+	tc.run([]step{{protocol.CmdAPIKey, "LIST", protocol.RespOK, "No API keys"}})
+	ts.dial(t).run([]step{
+		{protocol.CmdAuth, "TOKEN " + token, protocol.RespError, "Token was revoked"},
+		{protocol.CmdAuth, "TOKEN " + key, protocol.RespError, "Invalid token"},
+	})
+
+	// keys made afterwards work as usual
+	tc.send(protocol.CmdAPIKey, "CREATE later")
+	msg = tc.expect(protocol.RespOK, "AUTH TOKEN sk_")
+	_, rest, _ = strings.Cut(msg.Payload, "AUTH TOKEN ")
+	key, _, _ = strings.Cut(rest, " ")
+	ts.dial(t).run([]step{{protocol.CmdAuth, "TOKEN " + key, protocol.RespOK, "logged in as user1"}})
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"socket-tcp/internal/model"
+	"socket-tcp/internal/protocol"
+)
+
+// errCloseConnection is returned by a handler (QUIT) to end the connection on purpose
+var errCloseConnection = errors.New("connection closed by command")
+
+// Rate-limit classes a route can belong to
+const (
+	rateDefault = "default"
+	rateAuth    = "auth" // stricter, slows down password guessing
+	rateNone    = "none" // heartbeats are never throttled
+)
+
+// client is the per-connection state every handler works on
+type client struct {
+	msgHandler protocol.MessageConn // TCP or UDP
+	conn       io.Closer            // the transport under msgHandler
+	log        *slog.Logger
+	remote     string
+
+	sessionID     int // 0 until AUTH succeeds
+	user          *model.User
+	authenticated bool
+	mfa           *pendingMFA    // set by a right password when a code is due, until AUTH MFA
+	upload        *pendingUpload // set by UPLOAD until the last DATA chunk
+
+	features  protocol.Hello // agreed in HELLO, protocol.LegacyHello without one
+	helloDone bool
+	requestID string // "#<id>" of the command being handled, echoed in every reply to it
+
+	buckets map[string]*tokenBucket // rate-limit class -> bucket
+}
+
+// reply sends a message tagged with the client's session, and the request ID if there is one
+func (c *client) reply(command protocol.CommandType, payload string) error {
+	if c.requestID != "" {
+		payload = strings.TrimSuffix(c.requestID+" "+payload, " ")
+	}
+	return c.msgHandler.SendMessage(c.sessionID, command, payload)
+}
+
+// replyError sends an ERROR, only a broken connection is reported back
+func (c *client) replyError(format string, args ...any) error {
+	return c.reply(protocol.RespError, fmt.Sprintf(format, args...))
+}
+
+// HandlerFunc handles one command; a returned error closes the connection
+type HandlerFunc func(c *client, msg *protocol.Message) error
+
+// Route is a handler plus what the middleware needs to know about it
+type Route struct {
+	Command      protocol.CommandType
+	Handler      HandlerFunc
+	RequiresAuth bool
+	Role         string // required model.User role, empty for anyone
+	RateClass    string // empty means rateDefault
+	AnySession   bool   // skip the session ID check (heartbeats, AUTH)
+	Binary       bool   // raw bytes follow the command or its answer, needs the binary feature
+}
+
+// Middleware wraps a handler, it gets the route so it can read the metadata
+type Middleware func(route *Route, next HandlerFunc) HandlerFunc
+
+// Router dispatches messages to the route registered for their command
+type Router struct {
+	routes     map[protocol.CommandType]*Route
+	middleware []Middleware
+}
+
+func NewRouter() *Router {
+	return &Router{
+		routes: make(map[protocol.CommandType]*Route),
+	}
+}
+
+// Handle registers a route, registering the same command twice is a programming error
+func (r *Router) Handle(route Route) {
+	if _, exists := r.routes[route.Command]; exists {
+		panic("router: duplicate route for " + string(route.Command))
+	}
+	if route.RateClass == "" {
+		route.RateClass = rateDefault
+	}
+	r.routes[route.Command] = &route
+}
+
+// Use appends middleware, the first one added is the outermost
+func (r *Router) Use(mw ...Middleware) {
+	r.middleware = append(r.middleware, mw...)
+}
+
+// Dispatch runs the message through the middleware chain and its handler
+func (r *Router) Dispatch(c *client, msg *protocol.Message) error {
+	route, ok := r.routes[msg.Command]
+	if !ok {
+		route = &Route{Command: msg.Command, Handler: unknownCommand, RateClass: rateDefault, AnySession: true}
+	}
+
+	handler := route.Handler
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](route, handler)
+	}
+	return handler(c, msg)
+}
+
+func unknownCommand(c *client, msg *protocol.Message) error {
+	return c.replyError("%s %s", protocol.ErrUnknownCommand, msg.Command)
+}
+
+// maxRequestID keeps a client from making every answer huge
+const maxRequestID = 64
+
+// requestIDMiddleware takes a leading "#<id>" off the payload when request IDs were agreed in HELLO,
+// replies then carry it so a client can match answers to commands
+func requestIDMiddleware(route *Route, next HandlerFunc) HandlerFunc {
+	return func(c *client, msg *protocol.Message) error {
+		if !c.features.RequestIDs || !strings.HasPrefix(msg.Payload, "#") {
+			return next(c, msg)
+		}
+		id, payload, _ := strings.Cut(msg.Payload, " ")
+		if len(id) < 2 || len(id) > maxRequestID {
+			return c.replyError("Invalid request ID, use #<id> with up to %d characters", maxRequestID-1)
+		}
+		c.requestID = id
+		defer func() { c.requestID = "" }()
+		msg.Payload = payload
+		return next(c, msg)
+	}
+}
+
+// recoverMiddleware turns a panicking handler into an ERROR instead of killing the connection
+func recoverMiddleware(route *Route, next HandlerFunc) HandlerFunc {
+	return func(c *client, msg *protocol.Message) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				c.log.Error("Panic in handler", "command", msg.Command, "panic", r, "stack", string(debug.Stack()))
+				err = c.replyError("Internal server error")
+			}
+		}()
+		return next(c, msg)
+	}
+}
+
+// loggingMiddleware logs each command (credentials redacted) and records the metrics
+func (s *Server) loggingMiddleware(route *Route, next HandlerFunc) HandlerFunc {
+	return func(c *client, msg *protocol.Message) error {
+		label := commandLabel(msg.Command)
+		s.metrics.commandsTotal.Inc(label)
+		c.log.Debug("Received message", "command", msg.Command, "msg_session", msg.SessionID, "payload", redactPayload(msg.Command, msg.Payload))
+
+		start := time.Now()
+		err := next(c, msg)
+		s.metrics.handlerDuration.Observe(label, time.Since(start).Seconds())
+		return err
+	}
+}
+
+// rateLimitMiddleware takes a token from the bucket of the route's class
+func (s *Server) rateLimitMiddleware(classes map[string]rateLimit) Middleware {
+	return func(route *Route, next HandlerFunc) HandlerFunc {
+		limit, limited := classes[route.RateClass]
+		return func(c *client, msg *protocol.Message) error {
+			if !limited {
+				return next(c, msg)
+			}
+			bucket, ok := c.buckets[route.RateClass]
+			if !ok {
+				bucket = newTokenBucket(limit.rate, limit.burst)
+				c.buckets[route.RateClass] = bucket
+			}
+			if !bucket.allow() {
+				s.metrics.throttledTotal.Inc()
+				return c.replyError("Rate limit exceeded, please slow down")
+			}
+			return next(c, msg)
+		}
+	}
+}
+
+// authMiddleware rejects commands that need a login or a role the user does not have
+// Admin rights that depend on the arguments (SESSIONS ALL, REVOKE of someone else's session) are checked by the handlers
+func authMiddleware(route *Route, next HandlerFunc) HandlerFunc {
+	if !route.RequiresAuth && route.Role == "" {
+		return next
+	}
+	return func(c *client, msg *protocol.Message) error {
+		if !c.authenticated {
+			return c.replyError("Not authenticated")
+		}
+		if !c.user.HasRole(route.Role) {
+			return c.replyError("Permission denied: %s requires role %s", route.Command, route.Role)
+		}
+		return next(c, msg)
+	}
+}
+
+// binaryMiddleware refuses transfers to a client that did not agree to raw bytes in HELLO
+func binaryMiddleware(route *Route, next HandlerFunc) HandlerFunc {
+	if !route.Binary {
+		return next
+	}
+	return func(c *client, msg *protocol.Message) error {
+		if !c.features.Binary {
+			return c.replyError("%s needs the %s feature, it was not agreed in HELLO", route.Command, protocol.FeatureBinary)
+		}
+		return next(c, msg)
+	}
+}
+
+// sessionMiddleware checks the message carries the session ID handed out at AUTH and that the session
+// still exists. A revoke on another server sharing the AuthManager cannot close this connection, it ends here.
+func (s *Server) sessionMiddleware(route *Route, next HandlerFunc) HandlerFunc {
+	return func(c *client, msg *protocol.Message) error {
+		if c.authenticated && !route.AnySession && msg.SessionID != c.sessionID {
+			return c.replyError("Invalid session ID")
+		}
+		if c.authenticated && route.RequiresAuth && s.auth.User(c.sessionID) == nil {
+			c.log.Info("Session ended elsewhere, closing the connection")
+			c.reply(protocol.RespServer, "Session ended, it was revoked or replaced by another login")
+			return errCloseConnection
+		}
+		return next(c, msg)
+	}
+}
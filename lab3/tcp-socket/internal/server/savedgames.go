@@ -0,0 +1,105 @@
+package server
+
+import (
+	"sort"
+	"time"
+
+	"socket-tcp/internal/model"
+)
+
+// maxSavedGameAge drops saved games nobody came back for
+const maxSavedGameAge = 24 * time.Hour
+
+// loadGames reads the games saved by the last run, they wait for their users to log in again
+// A broken file is logged and ignored, losing games beats not starting
+func (s *Server) loadGames() {
+	if s.cfg.Games == nil {
+		return
+	}
+	games, err := s.cfg.Games.LoadGames()
+	if err != nil {
+		s.log.Error("Failed to load saved games", "err", err)
+		return
+	}
+
+	s.savedGamesMu.Lock()
+	defer s.savedGamesMu.Unlock()
+	for _, game := range games {
+		if time.Since(game.Saved) > maxSavedGameAge || !game.State.InProgress {
+			continue
+		}
+		s.savedGames[game.Username] = game
+	}
+	if len(s.savedGames) > 0 {
+		s.log.Info("Loaded saved games", "count", len(s.savedGames))
+	}
+}
+
+// saveGames writes every running game and the saved ones not resumed yet
+// A user playing in two sessions keeps one game, sessions do not survive the restart anyway
+func (s *Server) saveGames() error {
+	if s.cfg.Games == nil {
+		return nil
+	}
+
+	byUser := make(map[string]model.SavedGame)
+	s.savedGamesMu.Lock()
+	for username, game := range s.savedGames {
+		byUser[username] = game
+	}
+	s.savedGamesMu.Unlock()
+
+	now := time.Now().UTC()
+	for sessionID, state := range s.games.Snapshot() {
+		if user := s.auth.User(sessionID); user != nil {
+			byUser[user.Username] = model.SavedGame{Username: user.Username, State: state, Saved: now}
+		}
+	}
+
+	games := make([]model.SavedGame, 0, len(byUser))
+	for _, game := range byUser {
+		games = append(games, game)
+	}
+	sort.Slice(games, func(i, j int) bool {
+		return games[i].Username < games[j].Username
+	})
+	if err := s.cfg.Games.SaveGames(games); err != nil {
+		s.log.Error("Failed to save games", "err", err)
+		return err
+	}
+	s.log.Debug("Games saved", "count", len(games))
+	return nil
+}
+
+// runGameSaver saves the games every GameSaveInterval until Close, so a crash loses little
+func (s *Server) runGameSaver() {
+	defer close(s.saverDone)
+	ticker := time.NewTicker(s.cfg.GameSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.saveGames()
+		case <-s.stopSaver:
+			return
+		}
+	}
+}
+
+// resumeGame hands the user's saved game to the new session, the first login after the restart gets it
+func (s *Server) resumeGame(sessionID int, username string) (model.GameState, bool) {
+	s.savedGamesMu.Lock()
+	game, exists := s.savedGames[username]
+	delete(s.savedGames, username)
+	s.savedGamesMu.Unlock()
+	if !exists {
+		return model.GameState{}, false
+	}
+
+	if err := s.games.Restore(sessionID, game.State); err != nil {
+		s.log.Warn("Failed to resume saved game", "user", username, "session", sessionID, "err", err)
+		return model.GameState{}, false
+	}
+	s.log.Info("Game resumed", "user", username, "session", sessionID, "guesses", game.State.GuessCount)
+	return game.State, true
+}
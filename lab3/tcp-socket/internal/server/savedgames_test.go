@@ -0,0 +1,81 @@
+package server_test
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"socket-tcp/internal/protocol"
+	"socket-tcp/internal/server"
+	"socket-tcp/internal/storage"
+)
+
+func TestGamesSurviveRestart(t *testing.T) {
+	games := storage.NewGameStorage(filepath.Join(t.TempDir(), "games.json"))
+	withGames := func(cfg *server.Config) { cfg.Games = games }
+
+	before := startServer(t, withGames)
+	tc := before.dial(t)
+	tc.login("user1", "user123")
+	// two wrong guesses, unless 50 is the number
+	for {
+		tc.run([]step{{protocol.CmdStartGame, "", protocol.RespOK, "Game started"}})
+		tc.send(protocol.CmdGuess, "50")
+		if msg := tc.expect(protocol.RespOK, ""); !strings.Contains(msg.Payload, "Correct") {
+			break
+		}
+	}
+	tc.run([]step{{protocol.CmdGuess, "50", protocol.RespOK, " is too "}})
+
+	ac := before.api(t)
+	ac.login("admin", "123")
+	ac.do("POST", "/games", nil, http.StatusCreated, nil)
+	before.Close() // a graceful shutdown, hanging up would end the games
+
+	saved, err := games.LoadGames()
+	if err != nil || len(saved) != 2 || saved[0].Username != "admin" || saved[1].Username != "user1" || saved[1].State.GuessCount != 2 {
+		t.Fatalf("saved games %+v, %v", saved, err)
+	}
+	target := saved[1].State.Target
+
+	after := startServer(t, withGames)
+	resumed := after.dial(t)
+	resumed.send(protocol.CmdAuth, "user1 user123")
+	msg := resumed.expect(protocol.RespOK, "game resumed after 2 guesses")
+	resumed.sessionID = msg.SessionID
+	resumed.run([]step{{protocol.CmdGuess, fmt.Sprint(target), protocol.RespOK, fmt.Sprintf("The number was %d, found in 3 guesses", target)}})
+
+	// only the first login after the restart gets the game
+	after.dial(t).login("user1", "user123")
+
+	var login map[string]any
+	ac = after.api(t)
+	ac.do("POST", "/login", map[string]string{"username": "admin", "password": "123"}, http.StatusOK, &login)
+	if login["game_resumed"] != true || login["guess_count"] != float64(0) {
+		t.Fatalf("REST login did not resume the game: %v", login)
+	}
+}
+
+func TestGamesSavedPeriodically(t *testing.T) {
+	games := storage.NewGameStorage(filepath.Join(t.TempDir(), "games.json"))
+	ts := startServer(t, func(cfg *server.Config) {
+		cfg.Games = games
+		cfg.GameSaveInterval = 10 * time.Millisecond
+	})
+	tc := ts.dial(t)
+	tc.login("user1", "user123")
+	tc.run([]step{{protocol.CmdStartGame, "", protocol.RespOK, "Game started"}})
+
+	waitFor(t, func() bool {
+		saved, _ := games.LoadGames()
+		return len(saved) == 1 && saved[0].Username == "user1" && saved[0].State.InProgress
+	})
+	tc.run([]step{{protocol.CmdEndGame, "", protocol.RespOK, "Game ended"}})
+	waitFor(t, func() bool {
+		saved, _ := games.LoadGames()
+		return len(saved) == 0
+	})
+}
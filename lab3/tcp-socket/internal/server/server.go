@@ -0,0 +1,406 @@
+// Server - the TCP socket server as an embeddable type, cmd/server only parses flags and runs it.
+// Tests start one on 127.0.0.1:0 and talk to it over real connections.
+
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"socket-tcp/internal/audit"
+	"socket-tcp/internal/auth"
+	"socket-tcp/internal/game"
+	"socket-tcp/internal/model"
+	"socket-tcp/internal/protocol"
+	"socket-tcp/internal/rudp"
+	"socket-tcp/internal/storage"
+)
+
+// Config holds everything that used to be a flag read directly by the handlers
+type Config struct {
+	Addr     string // e.g. ":8080" or "127.0.0.1:0"
+	FileRoot string // directory served by the FILE command, uploads go to FileRoot/uploads/<user>
+
+	ExcludePatterns []string // path.Match patterns LS, FIND, STAT and FILE act as if did not exist, dot files always
+	ListPageSize    int      // entries per LS/FIND page, 0 = everything at once
+
+	MaxUploadSize int64 // bytes per uploaded file, 0 = unlimited
+	UploadQuota   int64 // bytes per user unless model.User.UploadQuota says otherwise, 0 = unlimited
+
+	ReadTimeout  time.Duration // idle time before the server sends a heartbeat PING
+	WriteTimeout time.Duration // max time to block writing to a client
+	MaxMissed    int           // heartbeats a client may miss before it is disconnected
+
+	MaxConns      int // 0 = unlimited
+	MaxConnsPerIP int // 0 = unlimited
+
+	CommandRate  float64 // commands per second per session, 0 = unlimited
+	CommandBurst int
+	AuthRate     float64 // AUTH attempts per second per connection, 0 = unlimited
+	AuthBurst    int
+
+	Compression       []protocol.Codec // codecs a client may pick in HELLO, empty = never compress
+	CompressThreshold int              // smaller lines and transfers are sent as they are
+
+	TLSConfig  *tls.Config // certificate for STARTTLS, nil = not offered
+	RequireTLS bool        // refuse AUTH on connections that did not upgrade and REST logins over plain HTTP, UDP and WebSocket clients included
+
+	UDP rudp.Config // reliability settings for ListenUDP
+
+	APITokenTTL time.Duration        // idle time before a REST token expires, 0 = never
+	Users       *storage.UserStorage // where PUT /me saves profiles, nil = changes stay in memory
+
+	Games            *storage.GameStorage // where running games survive a restart, nil = they end with the process
+	GameSaveInterval time.Duration        // how often they are saved besides at Close, 0 = only at Close
+
+	Logger *slog.Logger // nil = slog.Default()
+	Audit  *audit.Log   // nil = no audit trail
+}
+
+// DefaultConfig returns the values cmd/server uses when no flag is given
+func DefaultConfig() Config {
+	return Config{
+		Addr:              ":8080",
+		FileRoot:          "files",
+		ExcludePatterns:   []string{"uploads"}, // they belong to their users, see LIST
+		ListPageSize:      50,
+		MaxUploadSize:     100 << 20,
+		UploadQuota:       500 << 20,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		MaxMissed:         2,
+		MaxConns:          100,
+		MaxConnsPerIP:     10,
+		CommandRate:       5,
+		CommandBurst:      10,
+		AuthRate:          0.5,
+		AuthBurst:         5,
+		Compression:       []protocol.Codec{protocol.CodecGzip, protocol.CodecDeflate},
+		CompressThreshold: protocol.DefaultCompressThreshold,
+		UDP:               rudp.DefaultConfig(),
+		APITokenTTL:       30 * time.Minute,
+		GameSaveInterval:  30 * time.Second,
+	}
+}
+
+// Server accepts connections and runs every command through its Router
+type Server struct {
+	cfg     Config
+	auth    *auth.AuthManager
+	games   *game.GuessingGame
+	router  *Router
+	limiter *connLimiter
+	metrics *serverMetrics
+	log     *slog.Logger
+	audit   *audit.Log
+
+	apiTokens       *apiTokens   // REST logins
+	apiLoginLimiter *hostLimiter // POST /login attempts per host
+	saveMu          sync.Mutex   // one users file write at a time
+
+	savedGames   map[string]model.SavedGame // username -> game from before the restart, until the user logs in
+	savedGamesMu sync.Mutex
+	stopSaver    chan struct{} // closed by Close, nil without Config.Games
+	saverDone    chan struct{}
+
+	checksums *checksumCache            // SHA-256 of served files
+	uploads   map[string]*pendingUpload // partial files being written, one writer per file, their sizes are reserved
+	uploadsMu sync.Mutex
+
+	listener    net.Listener
+	udpListener *rudp.Listener
+	ready       atomic.Bool  // set once the listener is accepting
+	connCounter atomic.Int64 // gives each connection an id to correlate its log lines
+
+	conns     map[io.Closer]struct{} // open connections, closed on shutdown
+	sessions  map[int]*client        // logged in connections by session ID, for REVOKE
+	closed    bool
+	closeOnce sync.Once
+	mu        sync.Mutex
+	wg        sync.WaitGroup
+}
+
+// New creates a server sharing the given AuthManager
+func New(cfg Config, authManager *auth.AuthManager) *Server {
+	s := &Server{
+		cfg:      cfg,
+		auth:     authManager,
+		games:    game.NewGuessingGame(),
+		limiter:  newConnLimiter(cfg.MaxConns, cfg.MaxConnsPerIP),
+		log:      cfg.Logger,
+		audit:    cfg.Audit,
+		conns:    make(map[io.Closer]struct{}),
+		sessions: make(map[int]*client),
+
+		savedGames: make(map[string]model.SavedGame),
+
+		checksums: newChecksumCache(),
+		uploads:   make(map[string]*pendingUpload),
+
+		apiTokens:       newAPITokens(cfg.APITokenTTL),
+		apiLoginLimiter: newHostLimiter(cfg.AuthRate, cfg.AuthBurst),
+	}
+	if s.log == nil {
+		s.log = slog.Default()
+	}
+	if s.audit == nil {
+		s.audit = audit.Discard()
+	}
+	s.metrics = newServerMetrics(authManager)
+	s.router = s.routes()
+	authManager.OnEvict(s.evicted)
+
+	s.loadGames()
+	if cfg.Games != nil && cfg.GameSaveInterval > 0 {
+		s.stopSaver, s.saverDone = make(chan struct{}), make(chan struct{})
+		go s.runGameSaver()
+	}
+	return s
+}
+
+// Listen binds the configured address, Addr tells which port was picked for ":0"
+func (s *Server) Listen() error {
+	listener, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.ready.Store(true)
+	s.log.Info("Server TCP is running", "addr", listener.Addr().String())
+	return nil
+}
+
+// Addr returns the listening address, nil before Listen or ListenUDP
+func (s *Server) Addr() net.Addr {
+	switch {
+	case s.listener != nil:
+		return s.listener.Addr()
+	case s.udpListener != nil:
+		return s.udpListener.Addr()
+	}
+	return nil
+}
+
+// ListenAndServe is Listen followed by Serve
+func (s *Server) ListenAndServe() error {
+	if err := s.Listen(); err != nil {
+		return err
+	}
+	return s.Serve()
+}
+
+// Serve accepts connections until Close is called
+func (s *Server) Serve() error {
+	if s.listener == nil {
+		return errors.New("server is not listening")
+	}
+
+	// Accept and Handle Connecting
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			s.log.Warn("Failed to accept connection", "err", err)
+			continue
+		}
+
+		if reason, ok := s.limiter.acquire(conn.RemoteAddr()); !ok {
+			s.log.Warn("Rejecting connection", "remote", conn.RemoteAddr().String(), "reason", reason)
+			s.metrics.rejectedConns.Inc()
+			go s.rejectConnection(conn, reason)
+			continue
+		}
+
+		if !s.track(conn) {
+			conn.Close()
+			s.limiter.release(conn.RemoteAddr())
+			return nil
+		}
+
+		// handle connect in each goroutine
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			defer s.limiter.release(conn.RemoteAddr())
+			s.handleConnection(conn)
+		}()
+	}
+}
+
+// Close saves the games, stops accepting, hangs up every client and waits for the handlers to return
+// The games are saved first: hanging up ends them, and main may return as soon as Serve does
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		if s.stopSaver != nil {
+			close(s.stopSaver)
+			<-s.saverDone
+		}
+		s.saveGames()
+	})
+
+	s.mu.Lock()
+	s.closed = true
+	s.ready.Store(false)
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	if s.udpListener != nil {
+		err = errors.Join(err, s.udpListener.Close())
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) track(conn io.Closer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrack(conn io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+}
+
+// rejectConnection tells the client why it cannot be served and hangs up
+func (s *Server) rejectConnection(conn net.Conn, reason string) {
+	defer conn.Close()
+
+	msgHandler := protocol.NewMessageHandler(conn)
+	msgHandler.SetWriteTimeout(s.cfg.WriteTimeout)
+	if err := msgHandler.SendMessage(0, protocol.RespError, reason); err != nil {
+		s.log.Debug("Failed to send rejection", "remote", conn.RemoteAddr().String(), "err", err)
+	}
+}
+
+func (s *Server) handleConnection(conn net.Conn) {
+	defer conn.Close() // close connect when this function ending to avoid resource leakage
+
+	conn = countingConn{conn, s.metrics}
+	s.serveClient(protocol.NewMessageHandler(conn), conn, conn.RemoteAddr().String())
+}
+
+// serveClient runs the command loop of one client, whatever transport it came over
+// conn is closed to hang up on the client from another goroutine, see REVOKE
+func (s *Server) serveClient(msgHandler protocol.MessageConn, conn io.Closer, clientAddr string) {
+	s.metrics.activeConns.Inc()
+	defer s.metrics.activeConns.Dec()
+
+	// every line about this connection carries conn + remote, session/user are added after AUTH
+	connLog := s.log.With("conn", s.connCounter.Add(1), "remote", clientAddr)
+	connLog.Info("New connection")
+
+	msgHandler.SetReadTimeout(s.cfg.ReadTimeout)
+	msgHandler.SetWriteTimeout(s.cfg.WriteTimeout)
+	if err := msgHandler.SendMessage(0, protocol.CmdHello, s.hello(msgHandler).String()); err != nil {
+		connLog.Warn("Failed to send HELLO", "err", err)
+		return
+	}
+
+	c := &client{
+		msgHandler: msgHandler,
+		conn:       conn,
+		log:        connLog,
+		remote:     clientAddr,
+		buckets:    make(map[string]*tokenBucket),
+		features:   protocol.LegacyHello(),
+	}
+	missed := 0 // heartbeats sent without hearing back
+
+	// free the session whatever way the connection ends
+	defer s.endSession(c)
+
+	// [process to handle receive message from client]
+	for {
+		msg, err := msgHandler.ReadMessage()
+		if err != nil {
+			if protocol.IsTimeout(err) {
+				// idle too long - ping the client, drop it if it keeps silent
+				missed++
+				if missed > s.cfg.MaxMissed {
+					c.log.Info("Client missed heartbeats, disconnecting", "missed", s.cfg.MaxMissed)
+					return
+				}
+				if err := c.reply(protocol.CmdPing, fmt.Sprint(time.Now().UnixNano())); err != nil {
+					c.log.Warn("Failed to send heartbeat", "err", err)
+					return
+				}
+				continue
+			}
+			if errors.Is(err, protocol.ErrInvalidFormat) {
+				// a bad line is the client's mistake, the connection is still usable
+				missed = 0
+				if err := c.replyError("%v", err); err != nil {
+					return
+				}
+				continue
+			}
+			if errors.Is(err, protocol.ErrLineTooLong) {
+				// the rest of the line is still on the way, there is no getting back in sync
+				c.log.Warn("Line too long, disconnecting", "max", protocol.MaxLineSize)
+				c.replyError("%v", err)
+				return
+			}
+			c.log.Debug("Read failed", "err", err)
+			return
+		}
+		missed = 0 // any message proves the client is alive
+
+		// Process message based on commamd
+		if err := s.router.Dispatch(c, msg); err != nil {
+			if err != errCloseConnection {
+				c.log.Warn("Failed to handle command", "command", msg.Command, "err", err)
+			}
+			return
+		}
+	}
+}
+
+// saveUsers writes every user to Config.Users after a change, without storage changes stay in memory
+func (s *Server) saveUsers() error {
+	if s.cfg.Users == nil {
+		return nil
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	if err := s.cfg.Users.SaveUsers(s.auth.Users()); err != nil {
+		s.log.Error("Failed to save users", "err", err)
+		return err
+	}
+	return nil
+}
+
+// endSession cleans up after a connection, however it ended
+func (s *Server) endSession(c *client) {
+	s.releaseUpload(c)                      // the partial file stays, the client can resume it later
+	if c.authenticated && s.unregister(c) { // a revoked session was cleaned up by whoever revoked it
+		if s.games.HasActiveGame(c.sessionID) {
+			s.games.EndGame(c.sessionID)
+			s.metrics.gameOutcomes.Inc("abandoned")
+		}
+		s.auth.RemoveSession(c.sessionID)
+		s.audit.Record(audit.EventLogout, "user", c.user.Username, "session", c.sessionID, "remote", c.remote)
+	}
+	c.log.Info("Connection closed")
+}
@@ -0,0 +1,375 @@
+package server_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"socket-tcp/internal/game"
+	"socket-tcp/internal/protocol"
+	"socket-tcp/internal/server"
+)
+
+func TestAuthFlow(t *testing.T) {
+	ts := startServer(t)
+	tc := ts.dial(t)
+
+	tc.run([]step{
+		{protocol.CmdStartGame, "", protocol.RespError, "Not authenticated"},
+		{protocol.CmdAuth, "admin", protocol.RespError, "Invalid auth format"},
+		{protocol.CmdAuth, "nobody 123", protocol.RespError, "User not found"},
+		{protocol.CmdAuth, "admin wrong", protocol.RespError, "Invalid Password"},
+	})
+
+	tc.login("admin", "123")
+	if !ts.auth.ValidateSession(tc.sessionID) {
+		t.Fatalf("session %d is not registered in AuthManager", tc.sessionID)
+	}
+
+	tc.run([]step{
+		{protocol.CmdAuth, "admin 123", protocol.RespError, "Already authenticated"},
+		{protocol.CmdPing, "42", protocol.CmdPong, "42"},
+	})
+
+	// a message with somebody else's session ID is refused
+	tc.sessionID++
+	tc.run([]step{{protocol.CmdStartGame, "", protocol.RespError, "Invalid session ID"}})
+}
+
+func TestUnknownCommand(t *testing.T) {
+	ts := startServer(t)
+	tc := ts.dial(t)
+
+	tc.run([]step{{"GREET", "hello", protocol.RespError, protocol.ErrUnknownCommand}})
+	tc.login("user1", "user123")
+	tc.run([]step{{"DANCE", "now", protocol.RespError, protocol.ErrUnknownCommand + " DANCE"}})
+}
+
+func TestMalformedLineKeepsConnection(t *testing.T) {
+	ts := startServer(t)
+	tc := ts.dial(t)
+
+	for _, line := range []string{"hello\n", "abc_QUIT\n", "_QUIT\n"} {
+		if _, err := tc.conn.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		tc.expect(protocol.RespError, "Format message is not valid")
+	}
+	tc.run([]step{{protocol.CmdQuit, "", protocol.RespBye, "Goodbye"}})
+}
+
+func TestGameFlow(t *testing.T) {
+	ts := startServer(t)
+	tc := ts.dial(t)
+	tc.login("user1", "user123")
+
+	tc.run([]step{
+		{protocol.CmdGuess, "50", protocol.RespError, "No active game"},
+		{protocol.CmdEndGame, "", protocol.RespError, "No active game"},
+		{protocol.CmdStartGame, "", protocol.RespOK, "Game started"},
+		{protocol.CmdStartGame, "", protocol.RespError, "already in progress"},
+		{protocol.CmdGuess, "abc", protocol.RespError, "Invalid guess"},
+	})
+
+	// binary search must find the number within 7 guesses
+	low, high := game.MinNumber, game.MaxNumber
+	for i := 0; ; i++ {
+		if i == 7 {
+			t.Fatal("number not found after 7 guesses")
+		}
+		guess := (low + high) / 2
+		tc.send(protocol.CmdGuess, strconv.Itoa(guess))
+		msg := tc.expect(protocol.RespOK, "")
+		if strings.Contains(msg.Payload, "Correct") {
+			break
+		}
+		if strings.Contains(msg.Payload, "too low") {
+			low = guess + 1
+		} else {
+			high = guess - 1
+		}
+	}
+
+	tc.run([]step{
+		{protocol.CmdGuess, "1", protocol.RespError, "No active game"},
+		{protocol.CmdStartGame, "", protocol.RespOK, "Game started"},
+		{protocol.CmdEndGame, "", protocol.RespOK, "Game ended"},
+	})
+}
+
+func TestFileDownload(t *testing.T) {
+	ts := startServer(t)
+	content := bytes.Repeat([]byte("lorem ipsum\n"), 10000) // bigger than one transfer chunk
+	if err := os.WriteFile(filepath.Join(ts.fileRoot, "sample.txt"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tc := ts.dial(t)
+	tc.run([]step{{protocol.CmdFile, "sample.txt", protocol.RespError, "Not authenticated"}})
+	tc.login("user1", "user123")
+
+	tc.send(protocol.CmdFile, "sample.txt")
+	msg := tc.expect(protocol.CmdFile, "sample.txt")
+	sum := sha256.Sum256(content)
+	if want := fmt.Sprintf("%d 0 %d %x sample.txt", len(content), len(content), sum); msg.Payload != want {
+		t.Fatalf("header %q, want %q", msg.Payload, want)
+	}
+	var got bytes.Buffer
+	if err := tc.msgHandler.ReadData(&got, int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), content) {
+		t.Fatal("downloaded content differs from the file")
+	}
+
+	// the connection is still in sync after the raw bytes
+	tc.run([]step{
+		{protocol.CmdFile, "missing.txt", protocol.RespError, "File not found"},
+		{protocol.CmdFile, "../server_test.go", protocol.RespError, "Invalid file name"},
+		{protocol.CmdFile, ".hidden", protocol.RespError, "Invalid file name"},
+	})
+}
+
+func TestFileRange(t *testing.T) {
+	ts := startServer(t)
+	content := []byte("0123456789abcdefghij")
+	if err := os.WriteFile(filepath.Join(ts.fileRoot, "range.txt"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := fmt.Sprintf("%x", sha256.Sum256(content))
+
+	tc := ts.dial(t)
+	tc.login("user1", "user123")
+
+	for _, tt := range []struct {
+		spec   string
+		offset int
+		want   string
+	}{
+		{"bytes=5-9", 5, "56789"},
+		{"bytes=15-", 15, "fghij"},
+		{"bytes=-3", 17, "hij"},
+		{"bytes=18-100", 18, "ij"},
+		{"bytes=20-", 20, ""}, // resuming a complete download
+	} {
+		tc.send(protocol.CmdFile, "range.txt "+tt.spec)
+		msg := tc.expect(protocol.CmdFile, "range.txt")
+		if want := fmt.Sprintf("%d %d %d %s range.txt", len(tt.want), tt.offset, len(content), sum); msg.Payload != want {
+			t.Fatalf("%s: header %q, want %q", tt.spec, msg.Payload, want)
+		}
+		var got bytes.Buffer
+		if err := tc.msgHandler.ReadData(&got, int64(len(tt.want))); err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != tt.want {
+			t.Fatalf("%s: got %q, want %q", tt.spec, got.String(), tt.want)
+		}
+	}
+
+	tc.run([]step{
+		{protocol.CmdFile, "range.txt bytes=21-", protocol.RespError, "Range not satisfiable"},
+		{protocol.CmdFile, "range.txt bytes=9-5", protocol.RespError, "Invalid range"},
+		{protocol.CmdFile, "range.txt bytes=x-", protocol.RespError, "Invalid range"},
+		{protocol.CmdFile, "range.txt bytes=-", protocol.RespError, "Invalid range"},
+	})
+}
+
+func TestCompression(t *testing.T) {
+	ts := startServer(t)
+	content := bytes.Repeat([]byte("compress me please\n"), 20000)
+	if err := os.WriteFile(filepath.Join(ts.fileRoot, "text.txt"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// count what arrives to see the file was compressed on the wire
+	tc := ts.dialRaw(t)
+	received := &countingConn{Conn: tc.conn}
+	tc.msgHandler = protocol.NewMessageHandler(received)
+	tc.msgHandler.SetReadTimeout(5 * time.Second)
+	tc.expect(protocol.CmdHello, "compress=gzip,deflate")
+	tc.run([]step{
+		{protocol.CmdHello, "1 binary compress=zstd,deflate", protocol.RespOK, "HELLO 1 binary compress=deflate"},
+	})
+	tc.msgHandler.(protocol.Compressor).SetCompression(protocol.CodecDeflate, 64)
+	tc.login("user1", "user123")
+
+	before := received.read
+	tc.send(protocol.CmdFile, "text.txt")
+	tc.expect(protocol.CmdFile, "text.txt")
+	var got bytes.Buffer
+	if err := tc.msgHandler.ReadData(&got, int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), content) {
+		t.Fatal("downloaded content differs from the file")
+	}
+	if sent := received.read - before; sent > int64(len(content))/10 {
+		t.Fatalf("sent %d bytes for a %d byte file, want it compressed", sent, len(content))
+	}
+
+	// a compressed upload and long answers still work both ways
+	data := bytes.Repeat([]byte("upload "), 10000)
+	tc.run([]step{{protocol.CmdUpload, fmt.Sprintf("%d up.txt", len(data)), protocol.RespOK, "RESUME 0"}})
+	tc.sendChunk(0, data)
+	tc.expect(protocol.RespOK, "Uploaded up.txt")
+	tc.run([]step{
+		{protocol.CmdFind, "*.txt", protocol.RespOK, "text.txt"},
+		{protocol.CmdHello, "1", protocol.RespError, "HELLO was already done"},
+	})
+}
+
+// countingConn counts the bytes read from the server
+type countingConn struct {
+	net.Conn
+	read int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read += int64(n)
+	return n, err
+}
+
+func TestHello(t *testing.T) {
+	ts := startServer(t)
+
+	// only what both sides have is switched on, this client cannot take raw bytes
+	tc := ts.dial(t)
+	tc.run([]step{
+		{protocol.CmdHello, "x", protocol.RespError, "bad protocol version"},
+		{protocol.CmdHello, "1 reqid starttls", protocol.RespOK, "HELLO 1 reqid"},
+		{protocol.CmdStartGame, "#a1", protocol.RespError, "#a1 Not authenticated"},
+		{protocol.CmdStartGame, "#", protocol.RespError, "Invalid request ID"},
+	})
+	tc.login("user1", "user123")
+	tc.run([]step{
+		{protocol.CmdFile, "#2 sample.txt", protocol.RespError, "#2 FILE needs the binary feature"},
+		{protocol.CmdStartGame, "#3", protocol.RespOK, "#3 Game started"},
+	})
+	tc.send(protocol.CmdEndGame, "")
+	if msg := tc.expect(protocol.RespOK, "Game ended"); strings.HasPrefix(msg.Payload, "#") {
+		t.Fatalf("untagged command got a tagged answer %q", msg.Payload)
+	}
+
+	// a version the server cannot speak ends the connection
+	newer := ts.dial(t)
+	newer.run([]step{{protocol.CmdHello, fmt.Sprintf("%d binary", protocol.ProtocolVersion+1), protocol.RespError, "Unsupported protocol version"}})
+	newer.expectClosed()
+}
+
+func TestQuit(t *testing.T) {
+	ts := startServer(t)
+
+	anonymous := ts.dial(t)
+	anonymous.run([]step{{protocol.CmdQuit, "", protocol.RespBye, "Goodbye"}})
+	anonymous.expectClosed()
+
+	tc := ts.dial(t)
+	tc.login("admin", "123")
+	tc.run([]step{{protocol.CmdQuit, "", protocol.RespBye, "Goodbye"}})
+	tc.expectClosed()
+
+	waitFor(t, func() bool { return !ts.auth.ValidateSession(tc.sessionID) })
+}
+
+func TestLineTooLongDisconnects(t *testing.T) {
+	ts := startServer(t)
+	tc := ts.dial(t)
+	go tc.conn.Write(bytes.Repeat([]byte("x"), 2*protocol.MaxLineSize))
+
+	// the ERROR may be lost to a reset, the server still having unread bytes when it closes
+	for {
+		msg, err := tc.msgHandler.ReadMessage()
+		if protocol.IsTimeout(err) {
+			t.Fatal("the server did not hang up")
+		}
+		if err != nil {
+			break
+		}
+		if msg.Command != protocol.RespError || !strings.Contains(msg.Payload, "Line is longer") {
+			t.Fatalf("got %s %q", msg.Command, msg.Payload)
+		}
+	}
+	ts.dial(t).run([]step{{protocol.CmdAuth, "admin 123", protocol.RespOK, "Authentication Successful"}})
+}
+
+func TestHeartbeatDisconnectsSilentClient(t *testing.T) {
+	ts := startServer(t, func(cfg *server.Config) {
+		cfg.ReadTimeout = 50 * time.Millisecond
+		cfg.MaxMissed = 1
+	})
+	tc := ts.dial(t)
+	tc.login("user1", "user123")
+
+	// the client never answers the PING
+	tc.expect(protocol.CmdPing, "")
+	tc.expectClosed()
+	waitFor(t, func() bool { return !ts.auth.ValidateSession(tc.sessionID) })
+}
+
+func TestRateLimit(t *testing.T) {
+	ts := startServer(t, func(cfg *server.Config) {
+		cfg.CommandRate = 0.001
+		cfg.CommandBurst = 2
+	})
+	tc := ts.dial(t)
+
+	tc.run([]step{
+		{"GREET", "1", protocol.RespError, protocol.ErrUnknownCommand},
+		{"GREET", "2", protocol.RespError, protocol.ErrUnknownCommand},
+		{"GREET", "3", protocol.RespError, "Rate limit exceeded"},
+		{protocol.CmdPing, "4", protocol.CmdPong, "4"}, // heartbeats are never throttled
+	})
+}
+
+func TestConnectionLimit(t *testing.T) {
+	ts := startServer(t, func(cfg *server.Config) {
+		cfg.MaxConnsPerIP = 1
+	})
+	ts.dial(t)
+
+	rejected := ts.dialRaw(t)
+	rejected.expect(protocol.RespError, "Too many connections")
+	rejected.expectClosed()
+}
+
+func TestConcurrentClients(t *testing.T) {
+	ts := startServer(t)
+
+	// the group returns once every parallel subtest is done
+	t.Run("clients", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			t.Run(strconv.Itoa(i), func(t *testing.T) {
+				t.Parallel()
+				tc := ts.dial(t)
+				tc.login("user1", "user123")
+				tc.run([]step{
+					{protocol.CmdStartGame, "", protocol.RespOK, "Game started"},
+					{protocol.CmdGuess, "50", protocol.RespOK, ""},
+					{protocol.CmdQuit, "", protocol.RespBye, "Goodbye"},
+				})
+			})
+		}
+	})
+
+	waitFor(t, func() bool { return ts.auth.SessionCount() == 0 })
+}
+
+// waitFor polls a condition the server updates after the client already saw the answer
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"socket-tcp/internal/audit"
+	"socket-tcp/internal/auth"
+	"socket-tcp/internal/model"
+	"socket-tcp/internal/protocol"
+)
+
+// register makes a logged in connection reachable for REVOKE
+func (s *Server) register(c *client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[c.sessionID] = c
+}
+
+// unregister forgets the connection, false means the session was revoked and is not its to end any more
+func (s *Server) unregister(c *client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions[c.sessionID] != c {
+		return false
+	}
+	delete(s.sessions, c.sessionID)
+	return true
+}
+
+// dropSession cleans up a session taken away from its owner: the game, REST tokens, and the connection
+// which is told why and closed. The AuthManager side is the caller's business.
+func (s *Server) dropSession(sessionID int, notice string) {
+	s.mu.Lock()
+	c := s.sessions[sessionID]
+	delete(s.sessions, sessionID)
+	s.mu.Unlock()
+
+	if s.games.HasActiveGame(sessionID) {
+		s.games.EndGame(sessionID)
+		s.metrics.gameOutcomes.Inc("abandoned")
+	}
+	s.apiTokens.removeSession(sessionID)
+	if c == nil {
+		return // a REST login, or one on another server sharing the AuthManager, which closes it at its next command
+	}
+
+	// not in the caller's goroutine, the connection may be in the middle of a download
+	go func() {
+		if err := c.msgHandler.SendMessage(sessionID, protocol.RespServer, notice); err != nil {
+			c.log.Debug("Failed to send revoke notice", "err", err)
+		}
+		c.conn.Close()
+	}()
+}
+
+// evicted is called by the AuthManager for the sessions a login ended under auth.SessionPolicyKick
+func (s *Server) evicted(info auth.SessionInfo) {
+	s.audit.Record(audit.EventSessionRevoked, "user", info.Username, "session", info.SessionID, "remote", info.Remote, "reason", "new login")
+	s.log.Info("Session ended by a new login", "user", info.Username, "session", info.SessionID)
+	s.dropSession(info.SessionID, "Session ended, you logged in somewhere else")
+}
+
+// handleSessions lists the user's sessions, admins see everyone's with SESSIONS ALL
+func (s *Server) handleSessions(c *client, msg *protocol.Message) error {
+	var sessions []auth.SessionInfo
+	switch arg := strings.ToUpper(strings.TrimSpace(msg.Payload)); {
+	case arg == "":
+		sessions = s.auth.SessionsOf(c.user.Username)
+	case arg == "ALL" && c.user.HasRole(model.RoleAdmin):
+		sessions = s.auth.Sessions()
+		s.audit.Record(audit.EventAdminAction, "user", c.user.Username, "session", c.sessionID, "action", "sessions_all")
+	case arg == "ALL":
+		return c.replyError("Permission denied: SESSIONS ALL requires role %s", model.RoleAdmin)
+	default:
+		return c.replyError("Use SESSIONS, or SESSIONS ALL as admin")
+	}
+
+	entries := make([]string, len(sessions))
+	for i, info := range sessions {
+		remote := info.Remote
+		if remote == "" {
+			remote = "unknown"
+		}
+		entries[i] = fmt.Sprintf("%d %s from %s since %s", info.SessionID, info.Username, remote, info.LoginTime.UTC().Format(time.RFC3339))
+		if info.SessionID == c.sessionID {
+			entries[i] += " (this one)"
+		}
+	}
+	return c.reply(protocol.RespOK, fmt.Sprintf("%d sessions: %s", len(sessions), strings.Join(entries, " | ")))
+}
+
+// handleRevoke ends one of the user's other sessions, admins may end anyone's
+func (s *Server) handleRevoke(c *client, msg *protocol.Message) error {
+	sessionID, err := strconv.Atoi(strings.TrimSpace(msg.Payload))
+	if err != nil {
+		return c.replyError("Invalid session ID, use REVOKE <id> with an ID from SESSIONS")
+	}
+	if sessionID == c.sessionID {
+		return c.replyError("That is this session, use QUIT to log out")
+	}
+	// someone else's session looks like a missing one, IDs are not to be probed
+	info, exists := s.auth.Session(sessionID)
+	if !exists || (info.Username != c.user.Username && !c.user.HasRole(model.RoleAdmin)) {
+		return c.replyError("Session not found: %d", sessionID)
+	}
+
+	s.dropSession(sessionID, fmt.Sprintf("Session revoked by %s", c.user.Username))
+	s.auth.RemoveSession(sessionID)
+	s.audit.Record(audit.EventSessionRevoked, "user", info.Username, "session", sessionID, "remote", info.Remote, "by", c.user.Username, "by_session", c.sessionID)
+	if info.Username != c.user.Username {
+		s.audit.Record(audit.EventAdminAction, "user", c.user.Username, "session", c.sessionID, "action", "revoke",
+			"target_user", info.Username, "target_session", sessionID)
+	}
+	c.log.Info("Session revoked", "target", sessionID, "target_user", info.Username)
+	return c.reply(protocol.RespOK, fmt.Sprintf("Session %d revoked", sessionID))
+}
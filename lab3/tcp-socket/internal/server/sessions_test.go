@@ -0,0 +1,138 @@
+package server_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"socket-tcp/internal/audit"
+	"socket-tcp/internal/auth"
+	"socket-tcp/internal/protocol"
+	"socket-tcp/internal/server"
+)
+
+func TestSessionsAndRevoke(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.Open(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { auditLog.Close() })
+	ts := startServer(t, func(cfg *server.Config) { cfg.Audit = auditLog })
+	laptop, phone, admin := ts.dial(t), ts.dial(t), ts.dial(t)
+	laptop.login("user1", "user123")
+	phone.login("user1", "user123")
+	admin.login("admin", "123")
+
+	laptop.send(protocol.CmdSessions, "")
+	list := laptop.expect(protocol.RespOK, "2 sessions: ")
+	for _, want := range []string{
+		fmt.Sprintf("%d user1 from 127.0.0.1:", laptop.sessionID),
+		fmt.Sprintf("%d user1 from 127.0.0.1:", phone.sessionID),
+		"(this one)",
+	} {
+		if !strings.Contains(list.Payload, want) {
+			t.Fatalf("SESSIONS %q misses %q", list.Payload, want)
+		}
+	}
+	if strings.Contains(list.Payload, "admin") {
+		t.Fatalf("SESSIONS shows other users: %q", list.Payload)
+	}
+
+	laptop.run([]step{
+		{protocol.CmdSessions, "ALL", protocol.RespError, "Permission denied"},
+		{protocol.CmdRevoke, "abc", protocol.RespError, "Invalid session ID"},
+		{protocol.CmdRevoke, fmt.Sprint(laptop.sessionID), protocol.RespError, "use QUIT"},
+		{protocol.CmdRevoke, fmt.Sprint(admin.sessionID), protocol.RespError, "Session not found"},
+		{protocol.CmdRevoke, fmt.Sprint(phone.sessionID), protocol.RespOK, fmt.Sprintf("Session %d revoked", phone.sessionID)},
+	})
+	phone.expect(protocol.RespServer, "Session revoked by user1")
+	phone.expectClosed()
+	if ts.auth.ValidateSession(phone.sessionID) {
+		t.Fatal("the revoked session is still valid")
+	}
+
+	// admins see and end everyone's sessions, REST logins included
+	ac := ts.api(t)
+	restSession := ac.login("user1", "user123")
+	admin.run([]step{
+		{protocol.CmdSessions, "all", protocol.RespOK, "3 sessions: "},
+		{protocol.CmdRevoke, fmt.Sprint(restSession), protocol.RespOK, "revoked"},
+		{protocol.CmdRevoke, fmt.Sprint(laptop.sessionID), protocol.RespOK, "revoked"},
+	})
+	ac.do("GET", "/me", nil, http.StatusUnauthorized, nil)
+	laptop.expect(protocol.RespServer, "Session revoked by admin")
+	laptop.expectClosed()
+	if got := ts.auth.SessionsOf("user1"); len(got) != 0 {
+		t.Fatalf("user1 still has sessions %v", got)
+	}
+
+	// SESSIONS ALL and the two revokes of user1's sessions, not user1 revoking its own
+	data, _ := os.ReadFile(auditPath)
+	var actions []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var record map[string]any
+		if json.Unmarshal([]byte(line), &record) == nil && record["event"] == audit.EventAdminAction {
+			if record["user"] != "admin" || record["session"] != float64(admin.sessionID) {
+				t.Errorf("admin action by the wrong actor: %s", line)
+			}
+			actions = append(actions, fmt.Sprint(record["action"], " ", record["target_user"], " ", record["target_session"]))
+		}
+	}
+	want := []string{"sessions_all <nil> <nil>", fmt.Sprint("revoke user1 ", restSession), fmt.Sprint("revoke user1 ", laptop.sessionID)}
+	if !reflect.DeepEqual(actions, want) {
+		t.Fatalf("admin actions %q, want %q", actions, want)
+	}
+}
+
+func TestSessionPolicy(t *testing.T) {
+	t.Run("kick", func(t *testing.T) {
+		ts := startServer(t)
+		ts.auth.SetSessionPolicy(auth.SessionPolicyKick)
+		old := ts.dial(t)
+		old.login("user1", "user123")
+		old.run([]step{{protocol.CmdStartGame, "", protocol.RespOK, "Game started"}})
+
+		fresh := ts.dial(t)
+		fresh.login("user1", "user123")
+		old.expect(protocol.RespServer, "you logged in somewhere else")
+		old.expectClosed()
+		fresh.run([]step{{protocol.CmdStartGame, "", protocol.RespOK, "Game started"}})
+		if got := ts.auth.SessionsOf("user1"); len(got) != 1 || got[0].SessionID != fresh.sessionID {
+			t.Fatalf("want only the new session, got %v", got)
+		}
+		ts.dial(t).login("admin", "123") // other users are not affected
+		waitFor(t, func() bool { return ts.auth.SessionCount() == 2 })
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		ts := startServer(t)
+		ts.auth.SetSessionPolicy(auth.SessionPolicyReject)
+		first := ts.dial(t)
+		first.login("user1", "user123")
+
+		second := ts.dial(t)
+		second.run([]step{{protocol.CmdAuth, "user1 user123", protocol.RespError, "already logged in elsewhere"}})
+		first.run([]step{{protocol.CmdQuit, "", protocol.RespBye, "Goodbye"}})
+		waitFor(t, func() bool { return !ts.auth.ValidateSession(first.sessionID) })
+		second.login("user1", "user123")
+	})
+}
+
+func TestSessionEndedElsewhere(t *testing.T) {
+	ts := startServer(t)
+	tc := ts.dial(t)
+	tc.login("user1", "user123")
+
+	// what a REVOKE on another server sharing the AuthManager leaves behind
+	ts.auth.RemoveSession(tc.sessionID)
+	tc.run([]step{{protocol.CmdPing, "still there", protocol.CmdPong, "still there"}})
+	tc.send(protocol.CmdStartGame, "")
+	tc.expect(protocol.RespServer, "Session ended")
+	tc.expectClosed()
+}
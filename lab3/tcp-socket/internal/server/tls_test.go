@@ -0,0 +1,92 @@
+package server_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"socket-tcp/internal/protocol"
+	"socket-tcp/internal/server"
+)
+
+// selfSigned makes a certificate for 127.0.0.1 and a client config that trusts it
+func selfSigned(t *testing.T) (serverCfg, clientCfg *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test server"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	serverCfg = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	clientCfg = &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+	return serverCfg, clientCfg
+}
+
+func TestStartTLS(t *testing.T) {
+	serverCfg, clientCfg := selfSigned(t)
+	ts := startServer(t, func(cfg *server.Config) {
+		cfg.TLSConfig = serverCfg
+		cfg.RequireTLS = true
+	})
+
+	tc := ts.dialRaw(t)
+	tc.expect(protocol.CmdHello, "reqid starttls")
+	tc.send(protocol.CmdAuth, "user1 user123")
+	tc.expect(protocol.RespError, "TLS is required, send STARTTLS before AUTH")
+	tc.run([]step{{protocol.CmdStartTLS, "", protocol.RespOK, "STARTTLS Ready"}})
+
+	upgrader := tc.msgHandler.(protocol.TLSUpgrader)
+	if err := upgrader.StartTLS(clientCfg, false); err != nil {
+		t.Fatal(err)
+	}
+	// a fresh start inside TLS, without STARTTLS on offer
+	if msg := tc.expect(protocol.CmdHello, "1 binary"); msg.Payload != "1 binary compress=gzip,deflate reqid" {
+		t.Fatalf("HELLO inside TLS offers %q", msg.Payload)
+	}
+	tc.run([]step{{protocol.CmdHello, "1 binary compress=gzip", protocol.RespOK, "HELLO 1 binary compress=gzip"}})
+	tc.msgHandler.(protocol.Compressor).SetCompression(protocol.CodecGzip, 64)
+	tc.login("user1", "user123")
+	tc.run([]step{
+		{protocol.CmdStartTLS, "", protocol.RespError, "Already using TLS"},
+		{protocol.CmdStartGame, "", protocol.RespOK, "Game started"},
+	})
+
+	// plaintext after STARTTLS could have been injected by someone in the middle, the server hangs up
+	injected := ts.dialRaw(t)
+	injected.expect(protocol.CmdHello, "starttls")
+	if _, err := injected.conn.Write([]byte("0_STARTTLS\nAUTH user1 user123\n")); err != nil {
+		t.Fatal(err)
+	}
+	injected.expect(protocol.RespOK, "STARTTLS Ready")
+	injected.expectClosed()
+
+	// without a certificate there is nothing to upgrade to
+	plain := startServer(t).dial(t)
+	plain.run([]step{{protocol.CmdStartTLS, "", protocol.RespError, "STARTTLS is not available"}})
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"net"
+
+	"socket-tcp/internal/protocol"
+	"socket-tcp/internal/rudp"
+)
+
+// ListenUDP binds the configured address for datagram clients, see internal/rudp
+// A Server normally serves one transport, cmd/udpserver only calls this one
+func (s *Server) ListenUDP() error {
+	listener, err := rudp.Listen(s.cfg.Addr, s.cfg.UDP)
+	if err != nil {
+		return err
+	}
+	s.udpListener = listener
+	s.ready.Store(true)
+	s.log.Info("Server UDP is running", "addr", listener.Addr().String(), "loss", s.cfg.UDP.Loss)
+	return nil
+}
+
+// ServeUDP accepts UDP associations until Close is called, each gets the same command loop as TCP
+func (s *Server) ServeUDP() error {
+	if s.udpListener == nil {
+		return errors.New("server is not listening on UDP")
+	}
+
+	for {
+		conn, err := s.udpListener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			s.log.Warn("Failed to accept UDP association", "err", err)
+			continue
+		}
+
+		if reason, ok := s.limiter.acquire(conn.RemoteAddr()); !ok {
+			s.log.Warn("Rejecting connection", "remote", conn.RemoteAddr().String(), "reason", reason)
+			s.metrics.rejectedConns.Inc()
+			go func() {
+				defer conn.Close()
+				rudp.NewMessageConn(conn).SendMessage(0, protocol.RespError, reason)
+			}()
+			continue
+		}
+
+		if !s.track(conn) {
+			conn.Close()
+			s.limiter.release(conn.RemoteAddr())
+			return nil
+		}
+
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(conn)
+			defer s.limiter.release(conn.RemoteAddr())
+			s.handleUDPConnection(conn)
+		}()
+	}
+}
+
+func (s *Server) handleUDPConnection(conn *rudp.Conn) {
+	defer conn.Close()
+
+	s.serveClient(rudp.NewMessageConn(conn), conn, conn.RemoteAddr().String())
+
+	// UDP has no kernel counters to look at, the log line is the place to compare runs
+	stats := conn.Stats()
+	s.log.Debug("UDP association closed", "remote", conn.RemoteAddr().String(),
+		"sent", stats.Sent, "retransmits", stats.Retransmits, "received", stats.Received,
+		"duplicates", stats.Duplicates, "dropped", stats.Dropped)
+}
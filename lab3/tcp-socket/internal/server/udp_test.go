@@ -0,0 +1,48 @@
+package server_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"socket-tcp/internal/protocol"
+	"socket-tcp/internal/rudp"
+	"socket-tcp/internal/server"
+)
+
+// the same conversation as over TCP, with a fifth of the datagrams lost both ways
+func TestUDPUnderLoss(t *testing.T) {
+	lossy := rudp.Config{RetransmitTimeout: 10 * time.Millisecond, MaxRetries: 30, Loss: 0.2}
+	ts := startUDPServer(t, func(cfg *server.Config) {
+		cfg.UDP = lossy
+	})
+	content := bytes.Repeat([]byte("udp\n"), 2000)
+	if err := os.WriteFile(filepath.Join(ts.fileRoot, "sample.txt"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tc := ts.dialUDP(t, lossy)
+	tc.run([]step{{protocol.CmdStartGame, "", protocol.RespError, "Not authenticated"}})
+	tc.login("user1", "user123")
+	tc.run([]step{
+		{protocol.CmdStartGame, "", protocol.RespOK, "Game started"},
+		{protocol.CmdGuess, "abc", protocol.RespError, "Invalid guess"},
+		{protocol.CmdEndGame, "", protocol.RespOK, "Game ended"},
+		{protocol.CmdHello, "1 binary compress=gzip", protocol.RespOK, "HELLO 1 binary"}, // rudp cannot compress
+	})
+
+	tc.send(protocol.CmdFile, "sample.txt")
+	tc.expect(protocol.CmdFile, "sample.txt")
+	var got bytes.Buffer
+	if err := tc.msgHandler.ReadData(&got, int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), content) {
+		t.Fatal("downloaded content differs from the file")
+	}
+
+	tc.run([]step{{protocol.CmdQuit, "", protocol.RespBye, "Goodbye"}})
+	waitFor(t, func() bool { return !ts.auth.ValidateSession(tc.sessionID) })
+}
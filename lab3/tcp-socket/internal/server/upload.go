@@ -0,0 +1,359 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"socket-tcp/internal/audit"
+	"socket-tcp/internal/protocol"
+)
+
+// Upload protocol:
+//
+//	UPLOAD <size> <name>     -> OK RESUME <offset> <name>, offset = bytes the server already has
+//	DATA <offset> <n> + n raw bytes, repeated until size is reached -> OK Uploaded <name> (<size> bytes)
+//
+// DATA is only answered when the file is complete or something went wrong, so a client can stream
+// chunks without waiting. The partial file survives a lost connection and the next UPLOAD resumes it.
+
+// maxDataChunk bounds one DATA message, bigger ones cannot be drained safely and end the connection
+const maxDataChunk = 4 << 20
+
+const uploadsDir = "uploads" // below FileRoot
+
+// pendingUpload is the file a client announced with UPLOAD
+type pendingUpload struct {
+	name string
+	size int64
+	dir  string
+	part string // partial file path, renamed to dir/name when complete
+	key  string // entry in Server.uploads
+}
+
+// partName keeps the size in the name, so a new upload with another size does not resume a stale file
+func partName(name string, size int64) string {
+	return fmt.Sprintf(".%s.%d.part", name, size)
+}
+
+// parsePartName is the reverse of partName, ok is false for anything else
+func parsePartName(file string) (name string, size int64, ok bool) {
+	if !strings.HasPrefix(file, ".") || !strings.HasSuffix(file, ".part") {
+		return "", 0, false
+	}
+	rest := strings.TrimSuffix(strings.TrimPrefix(file, "."), ".part")
+	dot := strings.LastIndex(rest, ".")
+	if dot <= 0 {
+		return "", 0, false
+	}
+	size, err := strconv.ParseInt(rest[dot+1:], 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+	return rest[:dot], size, true
+}
+
+// cleanUploadName is cleanFileName plus what LIST needs to print names on one line
+func cleanUploadName(name string) (string, error) {
+	name, err := cleanFileName(name)
+	if err != nil {
+		return "", err
+	}
+	if len(name) > 255 || strings.ContainsRune(name, '|') || strings.IndexFunc(name, func(r rune) bool { return !unicode.IsPrint(r) }) >= 0 {
+		return "", errors.New("Invalid file name")
+	}
+	return name, nil
+}
+
+// userUploadDir returns (and creates) FileRoot/uploads/<username>
+func (s *Server) userUploadDir(username string) (string, error) {
+	name, err := cleanFileName(username)
+	if err != nil {
+		return "", errors.New("Uploads are not available for this user")
+	}
+	dir := filepath.Join(s.cfg.FileRoot, uploadsDir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// uploadQuota is the user's own quota if set, else the server default; 0 = unlimited
+func (s *Server) uploadQuota(c *client) int64 {
+	user := s.auth.User(c.sessionID) // the stored copy, an admin may have changed the quota since AUTH
+	if user == nil {
+		user = c.user
+	}
+	switch {
+	case user.UploadQuota < 0:
+		return 0
+	case user.UploadQuota > 0:
+		return user.UploadQuota
+	}
+	return s.cfg.UploadQuota
+}
+
+// dirUsage adds up every file in dir, partial ones included, except the names given and their partial files
+func dirUsage(dir string, except ...string) (int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	skip := make(map[string]bool, len(except))
+	for _, name := range except {
+		skip[name] = true
+	}
+	var used int64
+	for _, entry := range entries {
+		if partOf, _, ok := parsePartName(entry.Name()); skip[entry.Name()] || ok && skip[partOf] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		used += info.Size()
+	}
+	return used, nil
+}
+
+var (
+	errUploadInProgress = errors.New("upload in progress")
+	errQuotaExceeded    = errors.New("quota exceeded")
+)
+
+// claimUpload makes sure only one connection writes a partial file at a time, and reserves its size:
+// the quota check counts the full size of every pending upload in the directory, not what they sent so far,
+// so uploads over several connections cannot add up beyond the quota. used is what the check counted.
+func (s *Server) claimUpload(up *pendingUpload, quota int64) (used int64, err error) {
+	s.uploadsMu.Lock()
+	defer s.uploadsMu.Unlock()
+	if s.uploads[up.key] != nil {
+		return 0, errUploadInProgress
+	}
+
+	// a pending upload replaces the file of its name, that file and the partial one are counted as its size
+	names := []string{up.name}
+	var reserved int64
+	for _, other := range s.uploads {
+		if other.dir == up.dir {
+			names = append(names, other.name)
+			reserved += other.size
+		}
+	}
+	used, err = dirUsage(up.dir, names...)
+	if err != nil {
+		return 0, err
+	}
+	used += reserved
+	if quota > 0 && used+up.size > quota {
+		return used, errQuotaExceeded
+	}
+	s.uploads[up.key] = up
+	return used, nil
+}
+
+// releaseUpload forgets the client's pending upload, the partial file is kept
+func (s *Server) releaseUpload(c *client) {
+	if c.upload == nil {
+		return
+	}
+	s.uploadsMu.Lock()
+	delete(s.uploads, c.upload.key)
+	s.uploadsMu.Unlock()
+	c.upload = nil
+}
+
+// handleUpload checks name, size and quota and tells the client where to start sending
+func (s *Server) handleUpload(c *client, msg *protocol.Message) error {
+	sizeStr, rawName, found := strings.Cut(strings.TrimSpace(msg.Payload), " ")
+	size, err := strconv.ParseInt(sizeStr, 10, 64)
+	if !found || err != nil || size < 0 {
+		return c.replyError("Invalid upload format, use UPLOAD size filename")
+	}
+	name, err := cleanUploadName(rawName)
+	if err != nil {
+		return c.replyError("%v", err)
+	}
+	if s.cfg.MaxUploadSize > 0 && size > s.cfg.MaxUploadSize {
+		return c.replyError("File too large: %d bytes, the limit is %d", size, s.cfg.MaxUploadSize)
+	}
+
+	dir, err := s.userUploadDir(c.user.Username)
+	if err != nil {
+		c.log.Error("Failed to create upload directory", "err", err)
+		return c.replyError("Upload failed")
+	}
+
+	s.releaseUpload(c) // a new UPLOAD replaces one that was never finished
+	up := &pendingUpload{name: name, size: size, dir: dir, part: filepath.Join(dir, partName(name, size)), key: filepath.Join(dir, name)}
+	quota := s.uploadQuota(c)
+	used, err := s.claimUpload(up, quota)
+	switch {
+	case errors.Is(err, errUploadInProgress):
+		return c.replyError("Upload of %s is already in progress", name)
+	case errors.Is(err, errQuotaExceeded):
+		return c.replyError("Quota exceeded: %d of %d bytes used, %s needs %d", used, quota, name, size)
+	case err != nil:
+		c.log.Error("Failed to read upload directory", "err", err)
+		return c.replyError("Upload failed")
+	}
+	c.upload = up
+
+	// partial files of the same name but another size are from an abandoned upload
+	if entries, err := os.ReadDir(dir); err == nil {
+		for _, entry := range entries {
+			if partOf, partSize, ok := parsePartName(entry.Name()); ok && partOf == name && partSize != size {
+				os.Remove(filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+
+	var offset int64
+	if info, err := os.Stat(up.part); err == nil && info.Size() <= size {
+		offset = info.Size()
+	} else if err := os.WriteFile(up.part, nil, 0644); err != nil {
+		s.releaseUpload(c)
+		c.log.Error("Failed to create partial file", "err", err)
+		return c.replyError("Upload failed")
+	}
+
+	if offset == size {
+		// nothing (left) to send, e.g. an empty file
+		return s.finishUpload(c)
+	}
+	c.log.Info("Upload started", "file", name, "size", size, "offset", offset)
+	return c.reply(protocol.RespOK, fmt.Sprintf("RESUME %d %s", offset, name))
+}
+
+// handleData appends one chunk to the pending upload
+func (s *Server) handleData(c *client, msg *protocol.Message) error {
+	var offset, n int64
+	if _, err := fmt.Sscanf(msg.Payload, "%d %d", &offset, &n); err != nil || n < 0 || n > maxDataChunk {
+		// without a trustworthy length the raw bytes cannot be skipped
+		c.replyError("Invalid DATA header, use DATA offset length (at most %d bytes)", maxDataChunk)
+		return errCloseConnection
+	}
+
+	refuse := func(format string, args ...any) error {
+		if err := c.msgHandler.ReadData(io.Discard, n); err != nil {
+			return err
+		}
+		return c.replyError(format, args...)
+	}
+
+	up := c.upload
+	switch {
+	case !c.authenticated:
+		return refuse("Not authenticated")
+	case msg.SessionID != c.sessionID:
+		return refuse("Invalid session ID")
+	case up == nil:
+		return refuse("No upload in progress, use UPLOAD size filename first")
+	}
+
+	info, err := os.Stat(up.part)
+	if err != nil {
+		s.releaseUpload(c)
+		return refuse("Upload of %s was interrupted, send UPLOAD again", up.name)
+	}
+	if offset != info.Size() || offset+n > up.size {
+		s.releaseUpload(c)
+		return refuse("Unexpected chunk at %d (%d bytes), the server has %d of %d bytes, send UPLOAD again", offset, n, info.Size(), up.size)
+	}
+
+	file, err := os.OpenFile(up.part, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		s.releaseUpload(c)
+		c.log.Error("Failed to open partial file", "err", err)
+		return refuse("Upload failed")
+	}
+	w := &recordingWriter{w: file}
+	err = c.msgHandler.ReadData(w, n)
+	file.Close()
+	if err != nil {
+		if w.err == nil {
+			return err // the connection broke, what arrived stays in the partial file
+		}
+		s.releaseUpload(c)
+		c.log.Error("Failed to write partial file", "err", w.err)
+		return c.replyError("Upload failed")
+	}
+
+	if offset+n < up.size {
+		return nil // more chunks to come
+	}
+	return s.finishUpload(c)
+}
+
+// finishUpload moves the complete partial file in place of the final one
+func (s *Server) finishUpload(c *client) error {
+	up := c.upload
+	s.releaseUpload(c)
+	if err := os.Rename(up.part, filepath.Join(up.dir, up.name)); err != nil {
+		c.log.Error("Failed to store upload", "err", err)
+		return c.replyError("Upload failed")
+	}
+	s.audit.Record(audit.EventFileUpload, "user", c.user.Username, "session", c.sessionID, "file", up.name, "size", up.size)
+	c.log.Info("File received", "file", up.name, "size", up.size)
+	return c.reply(protocol.RespOK, fmt.Sprintf("Uploaded %s (%d bytes)", up.name, up.size))
+}
+
+// handleList answers with the user's files on one line, "name size" separated by " | "
+// Unfinished uploads are listed too, they count against the quota
+func (s *Server) handleList(c *client, msg *protocol.Message) error {
+	dir, err := s.userUploadDir(c.user.Username)
+	if err != nil {
+		return c.replyError("%v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		c.log.Error("Failed to read upload directory", "err", err)
+		return c.replyError("Listing failed")
+	}
+
+	var files []string
+	var used int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		used += info.Size()
+		if name, size, ok := parsePartName(entry.Name()); ok {
+			files = append(files, fmt.Sprintf("%s (partial, %d of %d bytes)", name, info.Size(), size))
+		} else if !strings.HasPrefix(entry.Name(), ".") {
+			files = append(files, fmt.Sprintf("%s %d", entry.Name(), info.Size()))
+		}
+	}
+	sort.Strings(files)
+
+	usage := fmt.Sprintf("%d bytes used", used)
+	if quota := s.uploadQuota(c); quota > 0 {
+		usage = fmt.Sprintf("%d of %d bytes used", used, quota)
+	}
+	if len(files) == 0 {
+		return c.reply(protocol.RespOK, "No files, "+usage)
+	}
+	return c.reply(protocol.RespOK, fmt.Sprintf("%d files, %s: %s", len(files), usage, strings.Join(files, " | ")))
+}
+
+// recordingWriter remembers a write error, so a full disk can be told apart from a broken connection
+type recordingWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	n, err := rw.w.Write(p)
+	if err != nil && rw.err == nil {
+		rw.err = err
+	}
+	return n, err
+}
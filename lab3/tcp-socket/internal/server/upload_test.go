@@ -0,0 +1,147 @@
+package server_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"socket-tcp/internal/protocol"
+	"socket-tcp/internal/server"
+)
+
+// sendChunk sends one DATA message with its raw bytes
+func (tc *testClient) sendChunk(offset int64, data []byte) {
+	tc.t.Helper()
+	if err := tc.msgHandler.SendData(tc.sessionID, protocol.CmdData, fmt.Sprintf("%d %d", offset, len(data)), bytes.NewReader(data), int64(len(data))); err != nil {
+		tc.t.Fatalf("send DATA: %v", err)
+	}
+}
+
+func TestUpload(t *testing.T) {
+	ts := startServer(t, func(cfg *server.Config) {
+		cfg.MaxUploadSize = 300000
+		cfg.UploadQuota = 400000
+	})
+	content := bytes.Repeat([]byte("upload me\n"), 25000)
+
+	tc := ts.dial(t)
+	tc.run([]step{{protocol.CmdUpload, "10 a.txt", protocol.RespError, "Not authenticated"}})
+	tc.login("user1", "user123")
+
+	tc.run([]step{
+		{protocol.CmdList, "", protocol.RespOK, "No files, 0 of 400000 bytes used"},
+		{protocol.CmdUpload, fmt.Sprintf("%d data.txt", len(content)), protocol.RespOK, "RESUME 0 data.txt"},
+	})
+	tc.sendChunk(0, content[:100000])
+	tc.sendChunk(100000, content[100000:])
+	tc.expect(protocol.RespOK, fmt.Sprintf("Uploaded data.txt (%d bytes)", len(content)))
+
+	got, err := os.ReadFile(filepath.Join(ts.fileRoot, "uploads", "user1", "data.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("uploaded content differs")
+	}
+
+	tc.run([]step{
+		{protocol.CmdList, "", protocol.RespOK, "1 files, 250000 of 400000 bytes used: data.txt 250000"},
+		{protocol.CmdUpload, "0 empty.txt", protocol.RespOK, "Uploaded empty.txt (0 bytes)"},
+		{protocol.CmdUpload, "300001 big.bin", protocol.RespError, "File too large"},
+		{protocol.CmdUpload, "200000 more.bin", protocol.RespError, "Quota exceeded"},
+		// replacing a file only counts the new size
+		{protocol.CmdUpload, "300000 data.txt", protocol.RespOK, "RESUME 0 data.txt"},
+		{protocol.CmdUpload, "10 ../escape.txt", protocol.RespError, "Invalid file name"},
+		{protocol.CmdUpload, "10 .hidden", protocol.RespError, "Invalid file name"},
+		{protocol.CmdUpload, "ten a.txt", protocol.RespError, "Invalid upload format"},
+	})
+}
+
+func TestUploadQuotaAcrossConnections(t *testing.T) {
+	ts := startServer(t, func(cfg *server.Config) { cfg.UploadQuota = 1000 })
+	first, second := ts.dial(t), ts.dial(t)
+	first.login("user1", "user123")
+	second.login("user1", "user123")
+
+	// the first upload has sent nothing yet, its size is reserved all the same
+	first.run([]step{{protocol.CmdUpload, "1000 a.bin", protocol.RespOK, "RESUME 0 a.bin"}})
+	second.run([]step{{protocol.CmdUpload, "1000 b.bin", protocol.RespError, "Quota exceeded: 1000 of 1000 bytes used, b.bin needs 1000"}})
+	first.sendChunk(0, bytes.Repeat([]byte("a"), 600))
+	second.run([]step{
+		{protocol.CmdUpload, "1 b.bin", protocol.RespError, "Quota exceeded"},
+		// replacing the pending file's name is no way around it either
+		{protocol.CmdUpload, "1000 a.bin", protocol.RespError, "already in progress"},
+	})
+
+	first.sendChunk(600, bytes.Repeat([]byte("a"), 400))
+	first.expect(protocol.RespOK, "Uploaded a.bin (1000 bytes)")
+	second.run([]step{
+		{protocol.CmdUpload, "1 b.bin", protocol.RespError, "Quota exceeded"},
+		{protocol.CmdUpload, "500 a.bin", protocol.RespOK, "RESUME 0 a.bin"},
+	})
+}
+
+func TestUploadResume(t *testing.T) {
+	ts := startServer(t)
+	content := bytes.Repeat([]byte("0123456789"), 5000)
+
+	first := ts.dial(t)
+	first.login("user1", "user123")
+	first.run([]step{{protocol.CmdUpload, fmt.Sprintf("%d resume.bin", len(content)), protocol.RespOK, "RESUME 0 resume.bin"}})
+	first.sendChunk(0, content[:20000])
+	// a second connection may not write the same file meanwhile
+	other := ts.dial(t)
+	other.login("user1", "user123")
+	other.run([]step{{protocol.CmdUpload, fmt.Sprintf("%d resume.bin", len(content)), protocol.RespError, "already in progress"}})
+	first.run([]step{{protocol.CmdList, "", protocol.RespOK, "resume.bin (partial, 20000 of 50000 bytes)"}})
+	first.send(protocol.CmdQuit, "")
+	first.expect(protocol.RespBye, "Goodbye")
+	first.expectClosed()
+
+	tc := ts.dial(t)
+	tc.login("user1", "user123")
+	// the server may still be cleaning up the first connection
+	waitFor(t, func() bool {
+		tc.send(protocol.CmdUpload, fmt.Sprintf("%d resume.bin", len(content)))
+		msg, err := tc.msgHandler.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg.Command == protocol.RespOK && msg.Payload == "RESUME 20000 resume.bin"
+	})
+
+	// a chunk at the wrong offset is refused and the connection stays in sync
+	tc.sendChunk(0, content[:10])
+	tc.expect(protocol.RespError, "Unexpected chunk at 0")
+	tc.run([]step{{protocol.CmdUpload, fmt.Sprintf("%d resume.bin", len(content)), protocol.RespOK, "RESUME 20000 resume.bin"}})
+	tc.sendChunk(20000, content[20000:])
+	tc.expect(protocol.RespOK, "Uploaded resume.bin")
+
+	got, err := os.ReadFile(filepath.Join(ts.fileRoot, "uploads", "user1", "resume.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("resumed upload differs from the original")
+	}
+	tc.run([]step{{protocol.CmdList, "", protocol.RespOK, "1 files"}})
+}
+
+func TestDataWithoutUpload(t *testing.T) {
+	ts := startServer(t)
+
+	tc := ts.dial(t)
+	tc.sendChunk(0, []byte("hello"))
+	tc.expect(protocol.RespError, "Not authenticated")
+	tc.login("admin", "123")
+	tc.sendChunk(0, []byte("hello\nQUIT\n"))
+	tc.expect(protocol.RespError, "No upload in progress")
+	tc.run([]step{{protocol.CmdList, "", protocol.RespOK, "No files"}})
+
+	// a length that cannot be drained ends the connection
+	tc.send(protocol.CmdData, "0 999999999999")
+	tc.expect(protocol.RespError, "Invalid DATA header")
+	tc.expectClosed()
+}
@@ -0,0 +1,50 @@
+package server_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"socket-tcp/internal/protocol"
+)
+
+func TestGameVariants(t *testing.T) {
+	ts := startServer(t)
+	tc := ts.dial(t)
+	tc.login("user1", "user123")
+	tc.run([]step{
+		{protocol.CmdStartGame, "chess", protocol.RespError, "unknown variant \"chess\", try classic, hotcold, mastermind"},
+		{protocol.CmdStartGame, "guesses=lots", protocol.RespError, "Invalid game options"},
+		{protocol.CmdStartGame, "mastermind digits=3 guesses=5", protocol.RespOK, "Guess the 3 digit code"},
+		{protocol.CmdGuess, "112", protocol.RespError, "Invalid guess, use GUESS with 3 different digits"},
+		{protocol.CmdGuess, "987", protocol.RespOK, ", 4 guesses left"},
+		{protocol.CmdEndGame, "", protocol.RespOK, "Game ended. The code was "},
+		{protocol.CmdStartGame, "hotcold range=1-10 guesses=1", protocol.RespOK, "between 1 and 10, hints say how close"},
+		{protocol.CmdGuess, "11", protocol.RespError, "between 1 and 10"},
+	})
+	// one guess, won or lost it is over
+	tc.send(protocol.CmdGuess, "5")
+	tc.expect(protocol.RespOK, "The number was ")
+	tc.run([]step{{protocol.CmdGuess, "5", protocol.RespError, "No active game"}})
+
+	ac := ts.api(t)
+	gamePath := fmt.Sprintf("/games/%d/guesses", ac.login("admin", "123"))
+	ac.do("POST", "/games", map[string]any{"variant": "mastermind", "min": 5}, http.StatusBadRequest, nil)
+	var started map[string]any
+	ac.do("POST", "/games", map[string]any{"variant": "mastermind", "max_guesses": 1, "time_limit": 60}, http.StatusCreated, &started)
+	if started["variant"] != "mastermind" || started["digits"] != float64(4) || started["max_guesses"] != float64(1) || started["deadline"] == nil || started["min"] != nil {
+		t.Fatalf("unexpected game %v", started)
+	}
+	ac.do("POST", "/games/0/guesses", map[string]any{"guess": "01"}, http.StatusNotFound, nil) // not our game
+
+	var result struct {
+		Won     bool
+		Outcome string
+	}
+	ac.do("POST", gamePath, map[string]any{"guess": "01"}, http.StatusBadRequest, nil)
+	ac.do("POST", gamePath, map[string]any{"guess": "0123"}, http.StatusOK, &result)
+	if result.Outcome == "playing" || result.Won != (result.Outcome == "won") {
+		t.Fatalf("one guess allowed, got %+v", result)
+	}
+	ac.do("POST", gamePath, map[string]any{"guess": "0123"}, http.StatusNotFound, nil)
+}
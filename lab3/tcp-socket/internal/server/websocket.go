@@ -0,0 +1,41 @@
+package server
+
+import (
+	"net/http"
+
+	"socket-tcp/internal/protocol"
+	"socket-tcp/internal/websocket"
+)
+
+// WebSocketHandler upgrades browser connections and runs them through the same command loop as TCP,
+// so they share logins, sessions and games with the TCP clients of this Server
+// Every protocol line is one text frame, FILE data comes as binary frames
+func (s *Server) WebSocketHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r)
+		if err != nil {
+			s.log.Debug("WebSocket upgrade failed", "remote", r.RemoteAddr, "err", err)
+			return // Upgrade already answered with an HTTP error
+		}
+
+		if reason, ok := s.limiter.acquire(conn.RemoteAddr()); !ok {
+			s.log.Warn("Rejecting connection", "remote", r.RemoteAddr, "reason", reason)
+			s.metrics.rejectedConns.Inc()
+			websocket.NewMessageConn(conn).SendMessage(0, protocol.RespError, reason)
+			conn.Close()
+			return
+		}
+		defer s.limiter.release(conn.RemoteAddr())
+
+		if !s.track(conn) {
+			conn.Close()
+			return
+		}
+		defer s.wg.Done()
+		defer s.untrack(conn)
+		defer conn.Close()
+
+		// bytes are not counted here, net/http owns the connection until the upgrade
+		s.serveClient(websocket.NewMessageConn(conn), conn, conn.RemoteAddr().String())
+	})
+}
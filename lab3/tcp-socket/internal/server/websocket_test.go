@@ -0,0 +1,77 @@
+package server_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"socket-tcp/internal/protocol"
+	"socket-tcp/internal/websocket"
+)
+
+// a browser and a TCP client share one AuthManager and one set of games
+func TestWebSocketSharesStateWithTCP(t *testing.T) {
+	ts := startServer(t)
+	content := bytes.Repeat([]byte("ws\n"), 20000) // more than one binary frame
+	if err := os.WriteFile(filepath.Join(ts.fileRoot, "sample.txt"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	browser := ts.dialWS(t)
+	browser.login("admin", "123")
+	browser.run([]step{
+		{protocol.CmdStartGame, "", protocol.RespOK, "Game started"},
+		{protocol.CmdGuess, "abc", protocol.RespError, "Invalid guess"},
+	})
+
+	tcp := ts.dial(t)
+	tcp.login("user1", "user123")
+	if !ts.auth.ValidateSession(browser.sessionID) || !ts.auth.ValidateSession(tcp.sessionID) {
+		t.Fatal("both sessions should be known to the shared AuthManager")
+	}
+
+	// the TCP client cannot use the browser's session
+	tcp.sessionID = browser.sessionID
+	tcp.run([]step{{protocol.CmdEndGame, "", protocol.RespError, "Invalid session ID"}})
+
+	browser.send(protocol.CmdFile, "sample.txt")
+	browser.expect(protocol.CmdFile, "sample.txt")
+	var got bytes.Buffer
+	if err := browser.msgHandler.ReadData(&got, int64(len(content))); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), content) {
+		t.Fatal("downloaded content differs from the file")
+	}
+
+	browser.run([]step{
+		{protocol.CmdEndGame, "", protocol.RespOK, "Game ended"},
+		{protocol.CmdQuit, "", protocol.RespBye, "Goodbye"},
+	})
+	browser.expectClosed()
+	waitFor(t, func() bool { return !ts.auth.ValidateSession(browser.sessionID) })
+}
+
+func TestWebSocketRejectsOtherOrigins(t *testing.T) {
+	ts := startServer(t)
+	gateway := httptest.NewServer(ts.WebSocketHandler())
+	defer gateway.Close()
+
+	wsURL := strings.Replace(gateway.URL, "http://", "ws://", 1) + "/ws"
+	if _, err := websocket.Dial(wsURL, "http://evil.example"); err == nil {
+		t.Fatal("cross-origin upgrade should fail")
+	}
+
+	resp, err := http.Get(gateway.URL + "/ws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("plain GET got %d, want 400", resp.StatusCode)
+	}
+}
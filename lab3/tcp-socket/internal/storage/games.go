@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"socket-tcp/internal/model"
+)
+
+// GameStorage keeps the running games over a restart in a JSON file
+type GameStorage struct {
+	filePath string
+	mu       sync.Mutex
+}
+
+// NewGameStorage creates a GameStorage, the file is created on the first save
+func NewGameStorage(filePath string) *GameStorage {
+	return &GameStorage{filePath: filePath}
+}
+
+// LoadGames reads the saved games, no file means no games
+func (gs *GameStorage) LoadGames() ([]model.SavedGame, error) {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	data, err := os.ReadFile(gs.filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var games []model.SavedGame
+	if err := json.Unmarshal(data, &games); err != nil {
+		return nil, err
+	}
+	return games, nil
+}
+
+// SaveGames replaces the saved games, through a temporary file so a crash mid-write keeps the old ones
+func (gs *GameStorage) SaveGames(games []model.SavedGame) error {
+	gs.mu.Lock()
+	defer gs.mu.Unlock()
+
+	if games == nil {
+		games = []model.SavedGame{}
+	}
+	data, err := json.MarshalIndent(games, "", " ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(gs.filePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(gs.filePath)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // gone after the rename anyway
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), gs.filePath)
+}
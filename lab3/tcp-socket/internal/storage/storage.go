@@ -83,6 +83,7 @@ func (us *UserStorage) CreateDefaultUsers() ([]*model.User, error) {
 			Password: auth.EncryptPassword("123"),
 			Fullname: "Admin",
 			Emails: []string{"admin@gmail.com"},
+			Role: model.RoleAdmin,
 			Addresses: []model.Address{
 				{
 					Type: "work",
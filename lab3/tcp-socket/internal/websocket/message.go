@@ -0,0 +1,134 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"socket-tcp/internal/protocol"
+)
+
+// raw file data goes out in binary frames of this size
+const dataChunkSize = 32 * 1024
+
+// MessageConn speaks the line protocol over WebSocket, it implements protocol.MessageConn
+// Every protocol line is one text frame (without the newline), SendData adds binary frames
+type MessageConn struct {
+	conn         *Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	sendMu       sync.Mutex // keeps a SendData header and its frames together
+}
+
+func NewMessageConn(conn *Conn) *MessageConn {
+	return &MessageConn{conn: conn}
+}
+
+func (mc *MessageConn) SetReadTimeout(d time.Duration)  { mc.readTimeout = d }
+func (mc *MessageConn) SetWriteTimeout(d time.Duration) { mc.writeTimeout = d }
+
+func (mc *MessageConn) Close() error { return mc.conn.Close() }
+
+// a deadline hit in the middle of a frame leaves the stream unusable, the caller hangs up then anyway
+func (mc *MessageConn) readDeadline() {
+	if mc.readTimeout > 0 {
+		mc.conn.SetReadDeadline(time.Now().Add(mc.readTimeout))
+	}
+}
+
+func (mc *MessageConn) writeDeadline() {
+	if mc.writeTimeout > 0 {
+		mc.conn.SetWriteDeadline(time.Now().Add(mc.writeTimeout))
+	}
+}
+
+func (mc *MessageConn) SendMessage(sessionID int, command protocol.CommandType, payload string) error {
+	line, err := protocol.EncodeMessage(sessionID, command, payload)
+	if err != nil {
+		return err
+	}
+
+	mc.sendMu.Lock()
+	defer mc.sendMu.Unlock()
+	mc.writeDeadline()
+	return mc.conn.WriteMessage(OpText, []byte(strings.TrimSuffix(line, "\n")))
+}
+
+// SendData sends the header line and then n bytes from r as binary frames
+func (mc *MessageConn) SendData(sessionID int, command protocol.CommandType, payload string, r io.Reader, n int64) error {
+	line, err := protocol.EncodeMessage(sessionID, command, payload)
+	if err != nil {
+		return err
+	}
+
+	mc.sendMu.Lock()
+	defer mc.sendMu.Unlock()
+
+	mc.writeDeadline()
+	if err := mc.conn.WriteMessage(OpText, []byte(strings.TrimSuffix(line, "\n"))); err != nil {
+		return err
+	}
+
+	buf := make([]byte, dataChunkSize)
+	for n > 0 {
+		chunk := buf
+		if n < int64(len(chunk)) {
+			chunk = chunk[:n]
+		}
+		read, err := io.ReadFull(r, chunk)
+		if err != nil {
+			return err
+		}
+		mc.writeDeadline()
+		if err := mc.conn.WriteMessage(OpBinary, chunk[:read]); err != nil {
+			return err
+		}
+		n -= int64(read)
+	}
+	return nil
+}
+
+// ReadMessage returns the next text frame as a message, stray binary frames are skipped
+func (mc *MessageConn) ReadMessage() (*protocol.Message, error) {
+	for {
+		mc.readDeadline()
+		opcode, data, err := mc.conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if opcode != OpText {
+			continue
+		}
+		line := strings.TrimRight(string(data), "\r\n")
+		if strings.ContainsAny(line, "\r\n") {
+			return nil, fmt.Errorf("%w: one message per frame", protocol.ErrInvalidFormat)
+		}
+		return protocol.ParseMessage(line)
+	}
+}
+
+// ReadData copies n bytes of binary frames into w, like the TCP version it keeps reading if w fails
+func (mc *MessageConn) ReadData(w io.Writer, n int64) error {
+	var writeErr error
+	for n > 0 {
+		mc.readDeadline()
+		opcode, data, err := mc.conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if opcode != OpBinary {
+			return errors.New("websocket: expected binary data")
+		}
+		if int64(len(data)) > n {
+			return errors.New("websocket: more data than announced")
+		}
+		if writeErr == nil {
+			_, writeErr = w.Write(data)
+		}
+		n -= int64(len(data))
+	}
+	return writeErr
+}
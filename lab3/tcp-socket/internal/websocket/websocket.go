@@ -0,0 +1,330 @@
+/* websocket - just enough of RFC 6455 for the browser gateway: the handshake,
+masked client frames, fragmentation and the ping/pong/close control frames.
+Dial is the client side, tests use it to talk to the gateway like a browser would.
+No extensions (compression) and no subprotocols, the standard library has no WebSocket support
+and the project keeps to it.
+*/
+
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opcodes from the RFC
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// magic value the handshake hashes with the client key
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// MaxMessageSize caps a reassembled message, the protocol lines are tiny
+const MaxMessageSize = 64 * 1024
+
+var (
+	ErrBadHandshake  = errors.New("websocket: bad handshake")
+	ErrCrossOrigin   = errors.New("websocket: origin not allowed")
+	ErrProtocol      = errors.New("websocket: protocol error")
+	ErrMessageTooBig = errors.New("websocket: message too big")
+)
+
+// Conn is one WebSocket connection, from Upgrade (server) or Dial (client)
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	client bool // clients mask what they send, servers never do
+
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+}
+
+// Upgrade answers the handshake and takes over the connection from net/http
+// Browsers send an Origin header, it must match the host so other sites cannot use our login
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "WebSocket upgrade expected", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "Invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return nil, ErrCrossOrigin
+		}
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return nil, ErrBadHandshake
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	if _, err := conn.Write([]byte(response)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// rw.Reader may already hold the first frame
+	return &Conn{conn: conn, reader: rw.Reader}, nil
+}
+
+// Dial connects to a ws:// URL, origin is sent as the Origin header when not empty
+func Dial(rawURL, origin string) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ws" {
+		return nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	request := "GET " + u.RequestURI() + " HTTP/1.1\r\n" +
+		"Host: " + u.Host + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n"
+	if origin != "" {
+		request += "Origin: " + origin + "\r\n"
+	}
+	if _, err := conn.Write([]byte(request + "\r\n")); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %s", ErrBadHandshake, resp.Status)
+	}
+	return &Conn{conn: conn, reader: reader, client: true}, nil
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerContains checks a comma separated header for a token, case insensitive
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+// ReadMessage returns the next text or binary message, pings are answered on the way
+// A close frame from the client is answered and reported as io.EOF
+func (c *Conn) ReadMessage() (opcode int, data []byte, err error) {
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case OpPing:
+			if err := c.WriteMessage(OpPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			c.writeClose(payload)
+			return 0, nil, io.EOF
+		case OpContinuation:
+			if opcode == 0 {
+				return 0, nil, ErrProtocol
+			}
+			data = append(data, payload...)
+		case OpText, OpBinary:
+			if opcode != 0 {
+				return 0, nil, ErrProtocol // new message before the last one finished
+			}
+			opcode, data = op, payload
+		default:
+			return 0, nil, ErrProtocol
+		}
+
+		if len(data) > MaxMessageSize {
+			return 0, nil, ErrMessageTooBig
+		}
+		if fin {
+			return opcode, data, nil
+		}
+	}
+}
+
+// readFrame reads one frame and unmasks it, only frames from clients are masked
+func (c *Conn) readFrame() (fin bool, opcode int, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = int(header[0] & 0x0F)
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	if header[0]&0x70 != 0 || masked == c.client {
+		return false, 0, nil, ErrProtocol // no extensions negotiated, or masking the wrong way round
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if opcode >= OpClose && (!fin || length > 125) {
+		return false, 0, nil, ErrProtocol // control frames are small and never fragmented
+	}
+	if length > MaxMessageSize {
+		return false, 0, nil, ErrMessageTooBig
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return false, 0, nil, err
+		}
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	if masked {
+		maskBytes(payload, mask)
+	}
+	return fin, opcode, payload, nil
+}
+
+func maskBytes(data []byte, mask [4]byte) {
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+}
+
+// WriteMessage sends data as one frame, safe for concurrent use
+func (c *Conn) WriteMessage(opcode int, data []byte) error {
+	var maskBit byte
+	if c.client {
+		maskBit = 0x80
+	}
+
+	header := make([]byte, 0, 14)
+	header = append(header, 0x80|byte(opcode))
+	switch {
+	case len(data) < 126:
+		header = append(header, maskBit|byte(len(data)))
+	case len(data) <= 0xFFFF:
+		header = append(header, maskBit|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(data)))
+	default:
+		header = append(header, maskBit|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(len(data)))
+	}
+
+	if c.client {
+		var mask [4]byte
+		rand.Read(mask[:])
+		header = append(header, mask[:]...)
+		data = bytes.Clone(data)
+		maskBytes(data, mask)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// writeClose echoes the status code of a close frame, once
+func (c *Conn) writeClose(payload []byte) {
+	c.closeOnce.Do(func() {
+		if len(payload) > 2 {
+			payload = payload[:2]
+		}
+		c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.WriteMessage(OpClose, payload)
+	})
+}
+
+// Close says goodbye with a normal closure and hangs up
+func (c *Conn) Close() error {
+	c.writeClose([]byte{0x03, 0xE8}) // 1000
+	return c.conn.Close()
+}
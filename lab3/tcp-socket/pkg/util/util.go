@@ -1,30 +1,113 @@
 // Build the utilities for common use-able
 
-package util 
+package util
 
 import (
-
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
 )
 
+// Alphabets for GenerateRandomString
+const (
+	Digits       = "0123456789"
+	LowerLetters = "abcdefghijklmnopqrstuvwxyz"
+	UpperLetters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	HexDigits    = "0123456789abcdef"
+	AlphaNumeric = Digits + LowerLetters + UpperLetters
+)
 
-func GenrateRandomBytes () {
-
+// GenerateRandomBytes returns n bytes from crypto/rand
+func GenerateRandomBytes(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, errors.New("negative length")
+	}
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
 }
 
-func GenerateRandomString () {
+// GenerateRandomString returns n characters picked uniformly from alphabet
+func GenerateRandomString(n int, alphabet string) (string, error) {
+	if n < 0 {
+		return "", errors.New("negative length")
+	}
+	if alphabet == "" {
+		return "", errors.New("empty alphabet")
+	}
 
+	chars := []rune(alphabet)
+	result := make([]rune, n)
+	for i := range result {
+		idx, err := GenerateRandomInt(0, len(chars)-1)
+		if err != nil {
+			return "", err
+		}
+		result[i] = chars[idx]
+	}
+	return string(result), nil
 }
 
-func GenerateRandomInt () {
+// GenerateRandomInt returns a number in [min, max], both ends included
+func GenerateRandomInt(min, max int) (int, error) {
+	if min > max {
+		return 0, errors.New("min is greater than max")
+	}
 
+	// max-min+1 overflows an int64 for the full range, big.Int does not
+	span := new(big.Int).Sub(big.NewInt(int64(max)), big.NewInt(int64(min)))
+	span.Add(span, big.NewInt(1))
+	nBig, err := rand.Int(rand.Reader, span)
+	if err != nil {
+		return 0, err
+	}
+	return int(nBig.Add(nBig, big.NewInt(int64(min))).Int64()), nil
 }
 
-// Function to check if a slice contains a string
-func ContainsString () {
+// Function to check if a slice contains a value
+func Contains[T comparable](slice []T, value T) bool {
+	for _, item := range slice {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
 
+// function to remove every occurrence of a value from a slice, the input is not modified
+func Remove[T comparable](slice []T, value T) []T {
+	result := make([]T, 0, len(slice))
+	for _, item := range slice {
+		if item != value {
+			result = append(result, item)
+		}
+	}
+	return result
 }
 
-// function to remove string from a slice
-func RemoveString () {
+// SplitList splits a flag value like "a, b,,c" into its trimmed non-empty items
+func SplitList(s, sep string) []string {
+	var items []string
+	for _, item := range strings.Split(s, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
 
-}
\ No newline at end of file
+// Unique drops duplicates and keeps the first occurrence order
+func Unique[T comparable](slice []T) []T {
+	seen := make(map[T]bool, len(slice))
+	result := make([]T, 0, len(slice))
+	for _, item := range slice {
+		if !seen[item] {
+			seen[item] = true
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -0,0 +1,103 @@
+package util
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateRandomBytes(t *testing.T) {
+	b, err := GenerateRandomBytes(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 32 {
+		t.Fatalf("got %d bytes, want 32", len(b))
+	}
+
+	if _, err := GenerateRandomBytes(-1); err == nil {
+		t.Error("expected error for negative length")
+	}
+}
+
+func TestGenerateRandomString(t *testing.T) {
+	s, err := GenerateRandomString(64, HexDigits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s) != 64 {
+		t.Fatalf("got length %d, want 64", len(s))
+	}
+	for _, r := range s {
+		if !strings.ContainsRune(HexDigits, r) {
+			t.Fatalf("character %q is not in the alphabet", r)
+		}
+	}
+
+	if _, err := GenerateRandomString(5, ""); err == nil {
+		t.Error("expected error for empty alphabet")
+	}
+}
+
+func TestGenerateRandomInt(t *testing.T) {
+	seen := make(map[int]bool)
+	for i := 0; i < 1000; i++ {
+		n, err := GenerateRandomInt(1, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n < 1 || n > 3 {
+			t.Fatalf("%d is out of [1, 3]", n)
+		}
+		seen[n] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("expected every value of [1, 3] after 1000 draws, got %v", seen)
+	}
+
+	if n, err := GenerateRandomInt(7, 7); err != nil || n != 7 {
+		t.Errorf("GenerateRandomInt(7, 7) = %d, %v", n, err)
+	}
+	if _, err := GenerateRandomInt(5, 1); err == nil {
+		t.Error("expected error when min > max")
+	}
+	// the span of these does not fit an int64
+	if _, err := GenerateRandomInt(math.MinInt, math.MaxInt); err != nil {
+		t.Errorf("full range: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		if n, err := GenerateRandomInt(math.MinInt, math.MinInt+1); err != nil || n > math.MinInt+1 {
+			t.Fatalf("GenerateRandomInt(MinInt, MinInt+1) = %d, %v", n, err)
+		}
+	}
+}
+
+func TestSliceHelpers(t *testing.T) {
+	words := []string{"a", "b", "a", "c"}
+
+	if !Contains(words, "c") || Contains(words, "z") {
+		t.Error("Contains gave the wrong answer")
+	}
+	if got := Remove(words, "a"); !reflect.DeepEqual(got, []string{"b", "c"}) {
+		t.Errorf("Remove = %v", got)
+	}
+	if !reflect.DeepEqual(words, []string{"a", "b", "a", "c"}) {
+		t.Errorf("Remove modified its input: %v", words)
+	}
+	if got := Unique(words); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("Unique = %v", got)
+	}
+	if got := Unique([]int{3, 3, 1}); !reflect.DeepEqual(got, []int{3, 1}) {
+		t.Errorf("Unique = %v", got)
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	if got := SplitList(" uploads, *.tmp,,", ","); !reflect.DeepEqual(got, []string{"uploads", "*.tmp"}) {
+		t.Errorf("SplitList = %q", got)
+	}
+	if got := SplitList("", ","); got != nil {
+		t.Errorf("SplitList of empty string = %q", got)
+	}
+}